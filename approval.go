@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultApprovalExpiry is how long a pending change can be approved.
+const defaultApprovalExpiry = time.Hour
+
+// ApprovalConfig lists the environments whose updates must be approved by a second
+// API key identity before they are applied.
+type ApprovalConfig struct {
+	EnvironmentIDs []string `json:"environment_ids,omitempty"`
+	Targets        []string `json:"targets,omitempty"`
+
+	// ExpireAfterSeconds is how long a pending change can be approved (default 3600).
+	ExpireAfterSeconds int `json:"expire_after_seconds,omitempty"`
+
+	environments map[string]bool
+}
+
+// validate resolves targets and checks the environment IDs.
+func (a *ApprovalConfig) validate(cfg *Config) error {
+	if a.ExpireAfterSeconds < 0 {
+		return fmt.Errorf("expire_after_seconds cannot be negative")
+	}
+
+	a.environments = make(map[string]bool)
+	for _, environmentID := range a.EnvironmentIDs {
+		if _, err := uuid.Parse(environmentID); err != nil {
+			return fmt.Errorf("invalid environment_ids entry %q: must be a valid UUID", environmentID)
+		}
+		a.environments[environmentID] = true
+	}
+	for _, name := range a.Targets {
+		target, ok := cfg.Target(name)
+		if !ok {
+			return fmt.Errorf("unknown target %s", name)
+		}
+		a.environments[target.EnvironmentID] = true
+	}
+	if len(a.environments) == 0 {
+		return fmt.Errorf("environment_ids or targets must be provided")
+	}
+	return nil
+}
+
+func (a *ApprovalConfig) expiry() time.Duration {
+	if a.ExpireAfterSeconds > 0 {
+		return time.Duration(a.ExpireAfterSeconds) * time.Second
+	}
+	return defaultApprovalExpiry
+}
+
+// RequiresApproval reports whether updating any of environmentIDs must be approved.
+func (c *Config) RequiresApproval(environmentIDs []string) bool {
+	if c.Approvals == nil {
+		return false
+	}
+	for _, environmentID := range environmentIDs {
+		if c.Approvals.environments[environmentID] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkApprovals returns an error naming the first of environmentIDs that requires
+// approval. Registry pushes and watchers cannot wait for one, so they skip it.
+func (c *Config) checkApprovals(environmentIDs []string) error {
+	for _, environmentID := range environmentIDs {
+		if c.RequiresApproval([]string{environmentID}) {
+			return fmt.Errorf("environment %s requires approval, update it with PUT /update", environmentID)
+		}
+	}
+	return nil
+}
+
+// PlannedEnvironment lists the services a pending change will update in one environment.
+type PlannedEnvironment struct {
+	EnvironmentID string          `json:"environment_id"`
+	Services      []ServiceUpdate `json:"services"`
+}
+
+// PendingChange is an /update request waiting for approval. Request has variable
+// values redacted; the request to apply is only kept in memory.
+type PendingChange struct {
+	ID          string               `json:"id"`
+	RequestedBy string               `json:"requested_by"`
+	CreatedAt   time.Time            `json:"created_at"`
+	ExpiresAt   time.Time            `json:"expires_at"`
+	Request     UpdateRequest        `json:"request"`
+	Plan        []PlannedEnvironment `json:"plan"`
+
+	request UpdateRequest
+}
+
+// approvalState is the outcome of ApprovalStore.Take.
+type approvalState int
+
+const (
+	// approvalTaken means the change was removed from the store and can be applied.
+	approvalTaken approvalState = iota
+	// approvalMissing means no change with the ID is pending.
+	approvalMissing
+	// approvalExpired means the change expired before it was approved.
+	approvalExpired
+	// approvalSelf means the approver requested the change; it stays pending.
+	approvalSelf
+)
+
+// ApprovalStore holds pending changes in memory until they are approved or expire.
+type ApprovalStore struct {
+	mu      sync.Mutex
+	pending map[string]*PendingChange
+}
+
+func NewApprovalStore() *ApprovalStore {
+	return &ApprovalStore{pending: make(map[string]*PendingChange)}
+}
+
+// Add stores a pending change and forgets changes that expired before now.
+func (s *ApprovalStore) Add(change *PendingChange, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(now)
+	s.pending[change.ID] = change
+}
+
+// List returns the changes still pending at now, oldest first.
+func (s *ApprovalStore) List(now time.Time) []*PendingChange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(now)
+	changes := make([]*PendingChange, 0, len(s.pending))
+	for _, change := range s.pending {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].CreatedAt.Before(changes[j].CreatedAt)
+	})
+	return changes
+}
+
+// Take removes the change with the given ID so approver can apply it. A change can
+// only be taken once, and never by the identity that requested it.
+func (s *ApprovalStore) Take(id, approver string, now time.Time) (*PendingChange, approvalState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change, ok := s.pending[id]
+	switch {
+	case !ok:
+		return nil, approvalMissing
+	case !now.Before(change.ExpiresAt):
+		delete(s.pending, id)
+		return change, approvalExpired
+	case change.RequestedBy == approver:
+		return change, approvalSelf
+	}
+	delete(s.pending, id)
+	return change, approvalTaken
+}
+
+// evict forgets expired changes. The caller must hold s.mu.
+func (s *ApprovalStore) evict(now time.Time) {
+	for id, change := range s.pending {
+		if !now.Before(change.ExpiresAt) {
+			delete(s.pending, id)
+		}
+	}
+}
+
+// PendingChangeResponse is returned by /update when the update waits for approval.
+type PendingChangeResponse struct {
+	Message       string         `json:"message"`
+	PendingChange *PendingChange `json:"pending_change"`
+}
+
+// ApprovalsResponse is returned by GET /approvals.
+type ApprovalsResponse struct {
+	PendingChanges []*PendingChange `json:"pending_changes"`
+}
+
+// requestApproval plans the validated update req and stores it as a pending change
+// instead of applying it. original is the request as it was sent, before its
+// target was resolved, so it can be validated again on approval. When nothing
+// would change the update is applied right away.
+func (s *Server) requestApproval(w http.ResponseWriter, original, req UpdateRequest, opts UpdateOptions, identity string) {
+	if identity == "" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Updates of this environment require approval by a second API key, but API_KEYS is not configured"})
+		return
+	}
+
+	plan := make([]PlannedEnvironment, 0)
+	changes := 0
+	for _, environmentID := range req.environments() {
+		planned, err := s.client.PlanUpdate(environmentID, opts)
+		if err != nil {
+			w.WriteHeader(updateErrorStatus(err))
+			json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to plan update: %v", err), RailwayErrors: railwayErrors(err)})
+			return
+		}
+		plan = append(plan, PlannedEnvironment{EnvironmentID: environmentID, Services: planned})
+		changes += len(planned)
+	}
+	if changes == 0 {
+		s.apply(w, req, opts)
+		return
+	}
+
+	now := s.now()
+	change := &PendingChange{
+		ID:          uuid.NewString(),
+		RequestedBy: identity,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.config.Get().Approvals.expiry()),
+		Request:     original.redacted(),
+		Plan:        plan,
+		request:     original,
+	}
+	s.approvals.Add(change, now)
+
+	log.Printf("%s requested approval %s for updating %d service(s) to %s", identity, change.ID, changes, req.NewVersion)
+	for _, environmentID := range req.environments() {
+		s.audit.Record(AuditEntry{
+			Action:        AuditApprovalRequested,
+			Identity:      identity,
+			EnvironmentID: environmentID,
+			Version:       req.NewVersion,
+			Detail:        change.ID,
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(PendingChangeResponse{
+		Message:       fmt.Sprintf("Update of %d service(s) is pending approval: another API key must POST /approvals/%s/approve before %s", changes, change.ID, change.ExpiresAt.UTC().Format(time.RFC3339)),
+		PendingChange: change,
+	})
+}
+
+// handleListApprovals serves GET /approvals, listing the changes waiting for approval.
+func (s *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use GET"})
+		return
+	}
+
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ApprovalsResponse{PendingChanges: s.approvals.List(s.now())})
+}
+
+// handleApprove serves POST /approvals/{id}/approve. The change is validated again
+// against the current configuration, including freeze windows, and applied, or
+// scheduled if it has an apply_at time, on behalf of the approver.
+func (s *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use POST"})
+		return
+	}
+
+	approver, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	change, state := s.approvals.Take(id, approver, s.now())
+	switch state {
+	case approvalMissing:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("No pending change %s", id)})
+		return
+	case approvalExpired:
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Pending change %s expired at %s", id, change.ExpiresAt.UTC().Format(time.RFC3339))})
+		return
+	case approvalSelf:
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "A change must be approved by a different API key than the one that requested it"})
+		return
+	}
+
+	req := change.request
+	if req.ApplyAt != nil && !req.ApplyAt.After(s.now()) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Pending change %s was to be applied at %s, which has passed", id, req.ApplyAt.UTC().Format(time.RFC3339))})
+		return
+	}
+	opts, reqErr := validateUpdateRequest(&req, s.config.Get())
+	if reqErr == nil {
+		reqErr = enforceFreezes(s.config.Get(), &req, approver, req.applyTime(s.now()), s.audit)
+	}
+	if reqErr != nil {
+		w.WriteHeader(reqErr.Status)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: reqErr.Message})
+		return
+	}
+	opts.Owner = fmt.Sprintf("%s approving %s's change %s", approver, change.RequestedBy, change.ID)
+
+	log.Printf("%s approved change %s requested by %s", approver, change.ID, change.RequestedBy)
+	for _, environmentID := range req.environments() {
+		s.audit.Record(AuditEntry{
+			Action:        AuditApproved,
+			Identity:      approver,
+			EnvironmentID: environmentID,
+			Version:       req.NewVersion,
+			Detail:        change.ID,
+		})
+	}
+
+	if req.ApplyAt != nil {
+		s.schedule(w, change.request, req, approver)
+		return
+	}
+	s.apply(w, req, opts)
+}
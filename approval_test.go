@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const approvalEnvironmentID = "550e8400-e29b-41d4-a716-446655440001"
+
+// approvalServer returns a server requiring approval for approvalEnvironmentID,
+// with API keys for alice and bob, whose api service runs ghcr.io/returnearly/api:v1.
+func approvalServer(t *testing.T) (*fakeRailway, *Server, *bytes.Buffer) {
+	t.Helper()
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData([3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"}), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+
+	cfg := &Config{Approvals: &ApprovalConfig{EnvironmentIDs: []string{approvalEnvironmentID}, ExpireAfterSeconds: 600}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+	server := newTestServer(client, cfg)
+	server.apiKeys = APIKeys{"alice": "alice-key", "bob": "bob-key"}
+	var audit bytes.Buffer
+	server.audit = NewAuditLog(&audit)
+	return fake, server, &audit
+}
+
+func sendAuthorized(handler http.Handler, method, path, apiKey string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func approvalRequest(version string) UpdateRequest {
+	return UpdateRequest{
+		ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentID: approvalEnvironmentID,
+		ImagePrefixes: []string{"ghcr.io/returnearly/api"},
+		NewVersion:    version,
+	}
+}
+
+func TestHandleUpdate_RequiresApproval(t *testing.T) {
+	fake, server, audit := approvalServer(t)
+	handler := server.routes()
+
+	w := sendAuthorized(handler, http.MethodPut, "/update", "alice-key", approvalRequest("v2"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	var pending PendingChangeResponse
+	if err := json.NewDecoder(w.Body).Decode(&pending); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	change := pending.PendingChange
+	if change.RequestedBy != "alice" || len(change.Plan) != 1 || len(change.Plan[0].Services) != 1 {
+		t.Fatalf("Unexpected pending change %+v", change)
+	}
+	if image := change.Plan[0].Services[0].NewImage; image != "ghcr.io/returnearly/api:v2" {
+		t.Errorf("Expected planned image ghcr.io/returnearly/api:v2, got %s", image)
+	}
+	for _, op := range fake.operations() {
+		if op != "Environment" {
+			t.Fatalf("Expected no changes before approval, got %v", fake.operations())
+		}
+	}
+
+	w = sendAuthorized(handler, http.MethodGet, "/approvals", "bob-key", nil)
+	var list ApprovalsResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(list.PendingChanges) != 1 || list.PendingChanges[0].ID != change.ID {
+		t.Errorf("Expected pending change %s to be listed, got %+v", change.ID, list.PendingChanges)
+	}
+
+	w = sendAuthorized(handler, http.MethodPost, "/approvals/"+change.ID+"/approve", "alice-key", nil)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected self-approval to be refused with %d, got %d", http.StatusForbidden, w.Code)
+	}
+
+	w = sendAuthorized(handler, http.MethodPost, "/approvals/"+change.ID+"/approve", "bob-key", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var response SuccessResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.UpdatedServices) != 1 || response.UpdatedServices[0] != "api" {
+		t.Errorf("Expected api to be updated, got %v", response.UpdatedServices)
+	}
+
+	w = sendAuthorized(handler, http.MethodPost, "/approvals/"+change.ID+"/approve", "bob-key", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected a second approval to return %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to decode audit entry: %v", err)
+		}
+		actions = append(actions, entry.Action+":"+entry.Identity)
+	}
+	if got := strings.Join(actions, ","); got != "approval_requested:alice,approved:bob" {
+		t.Errorf("Unexpected audit entries %s", got)
+	}
+}
+
+func TestHandleUpdate_ApprovalRedactsVariables(t *testing.T) {
+	fake, server, _ := approvalServer(t)
+	handler := server.routes()
+
+	req := approvalRequest("v2")
+	req.Variables = &VariableChanges{Shared: VariableSet{Upsert: map[string]string{"API_SECRET": "s3cret"}}}
+	w := sendAuthorized(handler, http.MethodPut, "/update", "alice-key", req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if strings.Contains(body, "s3cret") || !strings.Contains(body, "API_SECRET") {
+		t.Errorf("Expected the variable value to be redacted, got %s", body)
+	}
+	var pending PendingChangeResponse
+	if err := json.Unmarshal([]byte(body), &pending); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	w = sendAuthorized(handler, http.MethodGet, "/approvals", "bob-key", nil)
+	if strings.Contains(w.Body.String(), "s3cret") {
+		t.Errorf("Expected the listed variable value to be redacted, got %s", w.Body.String())
+	}
+
+	// The approved change still applies the real value
+	w = sendAuthorized(handler, http.MethodPost, "/approvals/"+pending.PendingChange.ID+"/approve", "bob-key", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	upserted := false
+	for _, call := range fake.calls {
+		if call.Operation == "VariableCollectionUpsert" {
+			value := call.Variables["input"].(map[string]interface{})["variables"].(map[string]interface{})["API_SECRET"]
+			upserted = value == "s3cret"
+		}
+	}
+	if !upserted {
+		t.Errorf("Expected API_SECRET to be upserted with its real value, got %v", fake.operations())
+	}
+}
+
+func TestHandleApprove_Expired(t *testing.T) {
+	_, server, _ := approvalServer(t)
+	now := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	handler := server.routes()
+
+	w := sendAuthorized(handler, http.MethodPut, "/update", "alice-key", approvalRequest("v2"))
+	var pending PendingChangeResponse
+	if err := json.NewDecoder(w.Body).Decode(&pending); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	now = now.Add(10 * time.Minute)
+	w = sendAuthorized(handler, http.MethodPost, "/approvals/"+pending.PendingChange.ID+"/approve", "bob-key", nil)
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusGone, w.Code, w.Body.String())
+	}
+}
+
+func TestHandleUpdate_ApprovalWithoutChanges(t *testing.T) {
+	_, server, _ := approvalServer(t)
+
+	w := sendAuthorized(server.routes(), http.MethodPut, "/update", "alice-key", approvalRequest("v1"))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected an update without changes to apply immediately, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleUpdate_ApprovalRequiresAPIKeys(t *testing.T) {
+	_, server, _ := approvalServer(t)
+	server.apiKeys = nil
+
+	body, _ := json.Marshal(approvalRequest("v2"))
+	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+	server.handleUpdate(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusForbidden, w.Code, w.Body.String())
+	}
+}
+
+func TestTriggerRoutes_RequiresApproval(t *testing.T) {
+	fake, client := registryFake(t)
+	cfg := &Config{Routes: testRoutes(t), Approvals: &ApprovalConfig{EnvironmentIDs: []string{approvalEnvironmentID}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+
+	results := triggerRoutes(client, cfg, []string{"ghcr.io/returnearly/api"}, "v1.2.3")
+
+	if len(results) != 1 || results[0].Error != "" || !strings.Contains(results[0].Skipped, "requires approval") {
+		t.Fatalf("Expected the route to be skipped, got %+v", results)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestHandleDockerHubWebhook_RequiresApproval(t *testing.T) {
+	fake, client := registryFake(t)
+	cfg := &Config{Routes: testRoutes(t), Approvals: &ApprovalConfig{EnvironmentIDs: []string{approvalEnvironmentID}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/dockerhub?token="+testWebhookSecret, bytes.NewReader(readFixture(t, "dockerhub_push.json")))
+	w := httptest.NewRecorder()
+	newTestServer(client, cfg).handleDockerHubWebhook(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	var resp WebhookResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Routes) != 1 || resp.Routes[0].Skipped == "" || resp.Routes[0].Error != "" {
+		t.Errorf("Expected web-staging to be skipped, got %+v", resp.Routes)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestRegistryPoller_RequiresApproval(t *testing.T) {
+	registry, repository := newFakeRegistry(t)
+	registry.set([]string{"build-9", "build-10", "main"}, "")
+
+	poller, deployed, _ := watcherPoller(t, []string{repository + ":build-8"}, Watcher{
+		Repository: repository,
+		Policy:     TagPolicy{Regex: `^build-\d+$`},
+	})
+	cfg := poller.config.Get()
+	cfg.Approvals = &ApprovalConfig{EnvironmentIDs: []string{approvalEnvironmentID}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+
+	poller.Check()
+	if images := deployed(); len(images) != 0 {
+		t.Errorf("Expected no update of a protected environment, got %v", images)
+	}
+	state := poller.States()[0]
+	if state.Tag != "" || !strings.Contains(state.Error, "requires approval") {
+		t.Errorf("Expected build-10 to be skipped, got %+v", state)
+	}
+}
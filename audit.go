@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry records a privileged action, such as overriding a freeze window.
+type AuditEntry struct {
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	Identity      string    `json:"identity"`
+	EnvironmentID string    `json:"environment_id,omitempty"`
+	Version       string    `json:"version,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	Detail        string    `json:"detail,omitempty"`
+}
+
+// Audit actions.
+const (
+	AuditFreezeOverride    = "freeze_override"
+	AuditApprovalRequested = "approval_requested"
+	AuditApproved          = "approved"
+	AuditScheduled         = "scheduled"
+	AuditScheduleCancelled = "schedule_cancelled"
+	AuditScheduleMissed    = "schedule_missed"
+	AuditConverted         = "converted"
+)
+
+// AuditLog appends entries as JSON lines to a writer, or to the standard logger
+// when the writer is nil.
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// openAuditLog appends to the file at path, or writes to fallback when path is empty.
+func openAuditLog(path string, fallback io.Writer) (*AuditLog, error) {
+	if path == "" {
+		return NewAuditLog(fallback), nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return NewAuditLog(f), nil
+}
+
+// Record appends the entry, stamping it with the current time if unset.
+func (a *AuditLog) Record(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Failed to marshal audit entry: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.w == nil {
+		log.Printf("Audit: %s", line)
+		return
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write audit entry %s: %v", line, err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIKeys maps the identities allowed to call the API to their keys. When empty the
+// API is unauthenticated.
+type APIKeys map[string]string
+
+// parseAPIKeys parses API_KEYS, a comma-separated list of name:key pairs such as
+// "ci:3f9a...,alice:77b2...".
+func parseAPIKeys(spec string) (APIKeys, error) {
+	keys := make(APIKeys)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(pair, ":")
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("invalid API key entry %q: expected name:key", pair)
+		}
+		if _, exists := keys[name]; exists {
+			return nil, fmt.Errorf("duplicate API key name %s", name)
+		}
+		keys[name] = key
+	}
+	return keys, nil
+}
+
+// identify returns the identity whose key is sent as "Authorization: Bearer <key>".
+func (k APIKeys) identify(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	for name, key := range k {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// defaultBatchSize sends a mutation per service.
+const defaultBatchSize = 1
+
+// maxBatchSize bounds the aliased fields in one document, which Railway counts
+// towards a request's complexity.
+const maxBatchSize = 50
+
+// batchArgument is an argument of a batched mutation field. Shared arguments are
+// declared once; the others get one variable per alias, suffixed with its index.
+type batchArgument struct {
+	Name   string
+	Type   string
+	Shared bool
+}
+
+// batchMutation calls Field once per item in a single document, aliased s0, s1, ...
+// Items are encoded from V, whose JSON fields are the field's arguments, and each
+// alias's result decodes into R.
+type batchMutation[V, R any] struct {
+	Name      string
+	Field     string
+	Arguments []batchArgument
+}
+
+// batchDefinition describes a batch mutation for validation, with a zero value of
+// its item variables.
+type batchDefinition interface {
+	batchDefinition(count int) (name, document string, variables interface{})
+}
+
+func (m batchMutation[V, R]) batchDefinition(count int) (string, string, interface{}) {
+	var variables V
+	return m.Name, m.document(count), variables
+}
+
+// document returns the mutation document for count items.
+func (m batchMutation[V, R]) document(count int) string {
+	var declarations []string
+	for _, arg := range m.Arguments {
+		if arg.Shared {
+			declarations = append(declarations, fmt.Sprintf("$%s: %s", arg.Name, arg.Type))
+		}
+	}
+
+	var fields strings.Builder
+	for i := 0; i < count; i++ {
+		params := make([]string, 0, len(m.Arguments))
+		for _, arg := range m.Arguments {
+			variable := arg.Name
+			if !arg.Shared {
+				variable = fmt.Sprintf("%s%d", arg.Name, i)
+				declarations = append(declarations, fmt.Sprintf("$%s: %s", variable, arg.Type))
+			}
+			params = append(params, fmt.Sprintf("%s: $%s", arg.Name, variable))
+		}
+		fmt.Fprintf(&fields, "\t%s: %s(%s)\n", batchAlias(i), m.Field, strings.Join(params, ", "))
+	}
+
+	return fmt.Sprintf("mutation %s(%s) {\n%s}", m.Name, strings.Join(declarations, ", "), fields.String())
+}
+
+func batchAlias(i int) string {
+	return fmt.Sprintf("s%d", i)
+}
+
+// railwayBatchMutations lists every batch mutation the client sends.
+var railwayBatchMutations = []batchDefinition{
+	serviceInstanceUpdateBatch,
+	serviceInstanceDeployV2Batch,
+}
+
+var serviceInstanceUpdateBatch = batchMutation[serviceInstanceUpdateVariables, bool]{
+	Name:  "ServiceInstanceUpdateBatch",
+	Field: "serviceInstanceUpdate",
+	Arguments: []batchArgument{
+		{Name: "environmentId", Type: "String!", Shared: true},
+		{Name: "serviceId", Type: "String!"},
+		{Name: "input", Type: "ServiceInstanceUpdateInput!"},
+	},
+}
+
+var serviceInstanceDeployV2Batch = batchMutation[serviceInstanceDeployV2Variables, string]{
+	Name:  "ServiceInstanceDeployV2Batch",
+	Field: "serviceInstanceDeployV2",
+	Arguments: []batchArgument{
+		{Name: "environmentId", Type: "String!", Shared: true},
+		{Name: "serviceId", Type: "String!"},
+	},
+}
+
+// executeBatch sends m for items and returns each item's result and error, in item
+// order. Errors whose path starts with an alias are attributed to that item; any
+// other error fails the whole batch. When alias errors null the whole response, the
+// other items are sent again. Shared arguments are taken from the first item.
+func executeBatch[V, R any](c *RailwayClient, environmentID string, m batchMutation[V, R], items []V) ([]R, []error, error) {
+	token, err := c.tokenFor(environmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vars := make(map[string]interface{})
+	for i, item := range items {
+		encoded, err := json.Marshal(item)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal %s variables: %w", m.Name, err)
+		}
+		var itemVars map[string]interface{}
+		if err := json.Unmarshal(encoded, &itemVars); err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal %s variables: %w", m.Name, err)
+		}
+		for _, arg := range m.Arguments {
+			value, ok := itemVars[arg.Name]
+			switch {
+			case !ok:
+			case arg.Shared && i == 0:
+				vars[arg.Name] = value
+			case !arg.Shared:
+				vars[fmt.Sprintf("%s%d", arg.Name, i)] = value
+			}
+		}
+	}
+
+	data, err := c.doRequest(token, m.Name, m.document(len(items)), vars)
+	errs := make([]error, len(items))
+	if err != nil {
+		var graphQLErrs *GraphQLErrors
+		if !errors.As(err, &graphQLErrs) {
+			return nil, nil, err
+		}
+		if err := attributeBatchErrors(graphQLErrs, errs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if len(data) > 0 && string(data) != "null" {
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s response: %w", m.Name, err)
+		}
+	}
+
+	results := make([]R, len(items))
+	if fields == nil && err != nil {
+		// An error on a non-null field nulls the whole data, leaving the other
+		// aliases without a result, so they are sent again without the failed ones
+		var pending []int
+		for i := range items {
+			if errs[i] == nil {
+				pending = append(pending, i)
+			}
+		}
+		if len(pending) > 0 && len(pending) < len(items) {
+			retry := make([]V, 0, len(pending))
+			for _, i := range pending {
+				retry = append(retry, items[i])
+			}
+			log.Printf("%s returned no data, retrying %d of %d item(s) without the failed ones", m.Name, len(retry), len(items))
+			retryResults, retryErrs, err := executeBatch(c, environmentID, m, retry)
+			if err != nil {
+				return nil, nil, err
+			}
+			for j, i := range pending {
+				results[i], errs[i] = retryResults[j], retryErrs[j]
+			}
+			return results, errs, nil
+		}
+	}
+
+	for i := range items {
+		if errs[i] != nil {
+			continue
+		}
+		raw, ok := fields[batchAlias(i)]
+		if !ok {
+			errs[i] = fmt.Errorf("%s response has no result for %s", m.Name, batchAlias(i))
+			continue
+		}
+		if err := json.Unmarshal(raw, &results[i]); err != nil {
+			errs[i] = fmt.Errorf("failed to parse %s response: %w", m.Name, err)
+		}
+	}
+	return results, errs, nil
+}
+
+// attributeBatchErrors sets errs[i] to the errors of alias i. It returns all of
+// graphQLErrs when one of them does not belong to an alias, e.g. a rate limit.
+func attributeBatchErrors(graphQLErrs *GraphQLErrors, errs []error) error {
+	byItem := make(map[int][]GraphQLError)
+	for _, graphQLErr := range graphQLErrs.Errors {
+		item := -1
+		if len(graphQLErr.Path) > 0 {
+			alias, _ := graphQLErr.Path[0].(string)
+			for i := range errs {
+				if alias == batchAlias(i) {
+					item = i
+					break
+				}
+			}
+		}
+		if item < 0 {
+			return graphQLErrs
+		}
+		byItem[item] = append(byItem[item], graphQLErr)
+	}
+
+	for item, itemErrs := range byItem {
+		errs[item] = &GraphQLErrors{Operation: graphQLErrs.Operation, HTTPStatus: graphQLErrs.HTTPStatus, Errors: itemErrs}
+	}
+	return nil
+}
+
+// updateServiceBatch updates services like updateService and records each service
+// that fails. With a batch size above 1, the serviceInstanceUpdate mutations of up
+// to batchSize services are sent as one request, then their variables are applied,
+// then their deploys are sent as one request; a failed service is not deployed but
+// the others in its request are. It stops at the first failure unless opts.Rollout
+// continues past failures, and then returns every failure.
+func (c *RailwayClient) updateServiceBatch(environmentID string, services []Service, opts UpdateOptions) ([]ServiceUpdate, []ServiceFailure, error) {
+	continueOnFailure := opts.Rollout != nil && !opts.Rollout.abortOnFailure()
+	updates := make([]ServiceUpdate, 0, len(services))
+	var failures []ServiceFailure
+	var failed []error
+
+	if c.batchSize <= 1 {
+		for _, service := range services {
+			update, err := c.updateService(environmentID, service, opts)
+			if err != nil {
+				failures = append(failures, ServiceFailure{ServiceName: service.Name, Error: err.Error()})
+				failed = append(failed, err)
+				if !continueOnFailure {
+					break
+				}
+				continue
+			}
+			updates = append(updates, update)
+		}
+		return updates, failures, errors.Join(failed...)
+	}
+
+	for start := 0; start < len(services); start += c.batchSize {
+		end := min(start+c.batchSize, len(services))
+		batch, batchFailures, err := c.updateChunk(environmentID, services[start:end], opts)
+		updates = append(updates, batch...)
+		failures = append(failures, batchFailures...)
+		if err != nil {
+			failed = append(failed, err)
+			if !continueOnFailure {
+				break
+			}
+		}
+	}
+	return updates, failures, errors.Join(failed...)
+}
+
+// updateChunk updates services with one update and one deploy request.
+func (c *RailwayClient) updateChunk(environmentID string, services []Service, opts UpdateOptions) ([]ServiceUpdate, []ServiceFailure, error) {
+	names := make([]string, 0, len(services))
+	updateVars := make([]serviceInstanceUpdateVariables, 0, len(services))
+	planned := make([]ServiceUpdate, 0, len(services))
+	for _, service := range services {
+		// Replace the tag (or digest) on the parsed repository so registry ports survive
+		newImage := parseImageRef(service.Image).WithTag(opts.NewVersion)
+		log.Printf("Updating service %s from %s to %s (replicas=%d)", service.Name, service.Image, newImage, service.NumReplicas)
+
+		names = append(names, service.Name)
+		updateVars = append(updateVars, serviceInstanceUpdateVariables{
+			EnvironmentID: environmentID,
+			ServiceID:     service.ID,
+			Input:         c.serviceInstanceUpdateInput(serviceSourceInput{Image: newImage}, service.NumReplicas),
+		})
+		planned = append(planned, ServiceUpdate{
+			ServiceID:     service.ID,
+			ServiceName:   service.Name,
+			PreviousImage: service.Image,
+			NewImage:      newImage,
+			NumReplicas:   service.NumReplicas,
+		})
+	}
+
+	var failed []error
+	var failures []ServiceFailure
+	fail := func(name string, err error) {
+		failed = append(failed, err)
+		failures = append(failures, ServiceFailure{ServiceName: name, Error: err.Error()})
+	}
+
+	_, updateErrs, err := executeBatch(c, environmentID, serviceInstanceUpdateBatch, updateVars)
+	if err != nil {
+		for _, update := range planned {
+			failures = append(failures, ServiceFailure{ServiceName: update.ServiceName, Error: err.Error()})
+		}
+		return nil, failures, fmt.Errorf("failed to update services %s: %w", strings.Join(names, ", "), err)
+	}
+
+	deployable := make([]ServiceUpdate, 0, len(planned))
+	deployVars := make([]serviceInstanceDeployV2Variables, 0, len(planned))
+	for i, update := range planned {
+		if updateErrs[i] != nil {
+			fail(update.ServiceName, fmt.Errorf("failed to update service %s: failed to update service instance: %w", update.ServiceName, updateErrs[i]))
+			continue
+		}
+		if opts.Variables != nil {
+			if err := c.applyVariables(opts.ProjectID, environmentID, update.ServiceID, opts.Variables.Services[update.ServiceName]); err != nil {
+				fail(update.ServiceName, fmt.Errorf("failed to update variables for service %s: %w", update.ServiceName, err))
+				continue
+			}
+		}
+		deployable = append(deployable, update)
+		deployVars = append(deployVars, serviceInstanceDeployV2Variables{
+			ServiceID:     update.ServiceID,
+			EnvironmentID: environmentID,
+		})
+	}
+
+	updates := make([]ServiceUpdate, 0, len(deployable))
+	if len(deployable) > 0 {
+		deploymentIDs, deployErrs, err := executeBatch(c, environmentID, serviceInstanceDeployV2Batch, deployVars)
+		if err != nil {
+			for _, update := range deployable {
+				fail(update.ServiceName, fmt.Errorf("failed to deploy service %s: %w", update.ServiceName, err))
+			}
+			return nil, failures, errors.Join(failed...)
+		}
+		for i, update := range deployable {
+			if deployErrs[i] != nil {
+				fail(update.ServiceName, fmt.Errorf("failed to update service %s: failed to deploy service instance: %w", update.ServiceName, deployErrs[i]))
+				continue
+			}
+			update.DeploymentID = deploymentIDs[i]
+			updates = append(updates, update)
+		}
+	}
+
+	return updates, failures, errors.Join(failed...)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// batchFake serves api, web and worker. Batched updates and deploys fail for the
+// services in failUpdate and failDeploy, with errors on their aliases. As the
+// mutations return non-null types, any alias error nulls the whole data.
+func batchFake(t *testing.T, failUpdate, failDeploy string) (*fakeRailway, *RailwayClient) {
+	t.Helper()
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData(
+				[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-2", "web", "ghcr.io/returnearly/web:v1"},
+				[3]string{"svc-3", "worker", "ghcr.io/returnearly/worker:v1"},
+			), nil
+		case "ServiceInstanceUpdateBatch", "ServiceInstanceDeployV2Batch":
+			data := make(map[string]interface{})
+			errs := &GraphQLErrors{}
+			for i := 0; ; i++ {
+				serviceID, ok := variables[fmt.Sprintf("serviceId%d", i)].(string)
+				if !ok {
+					break
+				}
+				alias := batchAlias(i)
+				failing := failUpdate
+				if operation == "ServiceInstanceDeployV2Batch" {
+					failing = failDeploy
+				}
+				if serviceID == failing {
+					errs.Errors = append(errs.Errors, GraphQLError{Message: "Service not found", Path: []interface{}{alias}})
+					continue
+				}
+				data[alias] = true
+				if operation == "ServiceInstanceDeployV2Batch" {
+					data[alias] = "deploy-" + serviceID
+				}
+			}
+			if len(errs.Errors) > 0 {
+				return nil, errs
+			}
+			return data, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+	client.batchSize = 2
+	return fake, client
+}
+
+func batchFilter(t *testing.T) ServiceFilter {
+	t.Helper()
+	filter := ServiceFilter{Matchers: []ImageMatcher{
+		{Repository: "ghcr.io/returnearly/api"},
+		{Repository: "ghcr.io/returnearly/web"},
+		{Repository: "ghcr.io/returnearly/worker"},
+	}}
+	if err := filter.Validate(); err != nil {
+		t.Fatalf("Validate() returned error: %v", err)
+	}
+	return filter
+}
+
+func TestUpdateServices_Batched(t *testing.T) {
+	tests := []struct {
+		name        string
+		failUpdate  string
+		failDeploy  string
+		expectedOps []string
+		updated     []string
+		failed      []string
+	}{
+		{
+			name:        "all succeed",
+			expectedOps: []string{"Environment", "ServiceInstanceUpdateBatch", "ServiceInstanceDeployV2Batch", "ServiceInstanceUpdateBatch", "ServiceInstanceDeployV2Batch"},
+			updated:     []string{"api", "web", "worker"},
+		},
+		{
+			name:        "update fails",
+			failUpdate:  "svc-2",
+			expectedOps: []string{"Environment", "ServiceInstanceUpdateBatch", "ServiceInstanceUpdateBatch", "ServiceInstanceDeployV2Batch"},
+			updated:     []string{"api"},
+			failed:      []string{"web"},
+		},
+		{
+			name:        "deploy fails",
+			failDeploy:  "svc-1",
+			expectedOps: []string{"Environment", "ServiceInstanceUpdateBatch", "ServiceInstanceDeployV2Batch", "ServiceInstanceDeployV2Batch"},
+			updated:     []string{"web"},
+			failed:      []string{"api"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := batchFake(t, tt.failUpdate, tt.failDeploy)
+
+			result, err := client.UpdateServices("env-1", UpdateOptions{Filter: batchFilter(t), NewVersion: "v2"})
+			if (err != nil) != (len(tt.failed) > 0) {
+				t.Fatalf("Expected error %v, got %v", len(tt.failed) > 0, err)
+			}
+
+			if ops := strings.Join(fake.operations(), ","); ops != strings.Join(tt.expectedOps, ",") {
+				t.Errorf("Expected operations %v, got %s", tt.expectedOps, ops)
+			}
+			if names := strings.Join(result.UpdatedNames(), ","); names != strings.Join(tt.updated, ",") {
+				t.Errorf("Expected updated %v, got %s", tt.updated, names)
+			}
+			for _, update := range result.Updated {
+				if update.DeploymentID != "deploy-"+update.ServiceID || update.NewImage != "ghcr.io/returnearly/"+update.ServiceName+":v2" {
+					t.Errorf("Unexpected update %+v", update)
+				}
+			}
+
+			var failed []string
+			for _, failure := range result.Failed {
+				failed = append(failed, failure.ServiceName)
+				if !strings.Contains(failure.Error, "Service not found (path "+batchAlias(0)) && !strings.Contains(failure.Error, "Service not found (path "+batchAlias(1)) {
+					t.Errorf("Expected the alias error for %s, got %q", failure.ServiceName, failure.Error)
+				}
+			}
+			if strings.Join(failed, ",") != strings.Join(tt.failed, ",") {
+				t.Errorf("Expected failed %v, got %v", tt.failed, failed)
+			}
+		})
+	}
+}
+
+func TestUpdateServices_BatchedVariables(t *testing.T) {
+	fake, client := batchFake(t, "", "")
+
+	if _, err := client.UpdateServices("env-1", UpdateOptions{Filter: batchFilter(t), NewVersion: "v2"}); err != nil {
+		t.Fatalf("UpdateServices returned error: %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	update := fake.calls[1].Variables
+	if update["environmentId"] != "env-1" || update["serviceId0"] != "svc-1" || update["serviceId1"] != "svc-2" {
+		t.Errorf("Unexpected update variables %v", update)
+	}
+	input, _ := update["input1"].(map[string]interface{})
+	source, _ := input["source"].(map[string]interface{})
+	if source["image"] != "ghcr.io/returnearly/web:v2" {
+		t.Errorf("Expected input1 to point web at v2, got %v", input)
+	}
+	if deploy := fake.calls[4].Variables; deploy["serviceId0"] != "svc-3" || deploy["serviceId1"] != nil {
+		t.Errorf("Expected the last deploy batch to hold only worker, got %v", deploy)
+	}
+}
+
+func TestUpdateServices_BatchRateLimited(t *testing.T) {
+	_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		if operation == "Environment" {
+			return environmentData(
+				[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-2", "web", "ghcr.io/returnearly/web:v1"},
+			), nil
+		}
+		return nil, &GraphQLErrors{HTTPStatus: http.StatusTooManyRequests, Errors: []GraphQLError{{Message: "Rate limit exceeded"}}}
+	})
+	client.batchSize = 2
+
+	result, err := client.UpdateServices("env-1", UpdateOptions{Filter: batchFilter(t), NewVersion: "v2"})
+	var graphQLErrs *GraphQLErrors
+	if !errors.As(err, &graphQLErrs) || updateErrorStatus(err) != http.StatusTooManyRequests {
+		t.Fatalf("Expected a rate limit error, got %v", err)
+	}
+	if len(result.Updated) != 0 || len(result.Failed) != 2 {
+		t.Errorf("Expected both services to fail, got %+v", result)
+	}
+}
+
+func TestBatchMutation_Document(t *testing.T) {
+	expected := "mutation ServiceInstanceDeployV2Batch($environmentId: String!, $serviceId0: String!, $serviceId1: String!) {\n" +
+		"\ts0: serviceInstanceDeployV2(environmentId: $environmentId, serviceId: $serviceId0)\n" +
+		"\ts1: serviceInstanceDeployV2(environmentId: $environmentId, serviceId: $serviceId1)\n" +
+		"}"
+	if document := serviceInstanceDeployV2Batch.document(2); document != expected {
+		t.Errorf("Expected document:\n%s\ngot:\n%s", expected, document)
+	}
+}
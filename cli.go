@@ -0,0 +1,524 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Exit codes of the command-line mode.
+const (
+	exitOK      = 0
+	exitFailed  = 1 // nothing was updated
+	exitUsage   = 2 // invalid arguments or request
+	exitPartial = 3 // some services were updated, others failed
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+const usage = `Usage: railway-image-updater [command] [flags]
+
+Commands:
+  serve    Run the HTTP server (default)
+  update   Update matching services to a new version
+  plan     Show which services update would change, without changing them
+  list     List the image and repo services in an environment
+
+Run "railway-image-updater <command> -h" for the flags of a command.
+`
+
+// runCLI runs the command named by args[0], defaulting to serve, and returns the
+// process exit code.
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve", "update", "plan", "list":
+	case "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q\n\n%s", command, usage)
+		return exitUsage
+	}
+
+	tokens, err := tokensFromEnv()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	registryUser := os.Getenv("RAILWAY_DOCKER_REGISTRY_USER")
+	registryPass := os.Getenv("RAILWAY_DOCKER_REGISTRY_TOKEN")
+
+	client := NewRailwayClient("", registryUser, registryPass)
+	client.tokens = tokens
+	client.notifier = notifierFromEnv()
+
+	batchSize, err := batchSizeFromEnv()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	client.batchSize = batchSize
+
+	if command == "serve" {
+		fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+		fs.SetOutput(stderr)
+		if err := fs.Parse(args); err != nil {
+			return exitUsage
+		}
+		if err := serve(client); err != nil {
+			log.Print(err)
+			return exitFailed
+		}
+		return exitOK
+	}
+
+	return runCommand(command, args, client, stdout, stderr)
+}
+
+// tokensFromEnv returns the Railway tokens configured by RAILWAY_API_TOKEN, whose
+// type is RAILWAY_TOKEN_TYPE, and RAILWAY_PROJECT_TOKENS. At least one is required.
+func tokensFromEnv() (*RailwayTokens, error) {
+	tokenType := os.Getenv("RAILWAY_TOKEN_TYPE")
+	if tokenType == "" {
+		tokenType = TokenTypeTeam
+	}
+	if err := validateTokenType(tokenType); err != nil {
+		return nil, fmt.Errorf("invalid RAILWAY_TOKEN_TYPE: %w", err)
+	}
+
+	projectTokens, err := parseProjectTokens(os.Getenv("RAILWAY_PROJECT_TOKENS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RAILWAY_PROJECT_TOKENS: %w", err)
+	}
+
+	token := os.Getenv("RAILWAY_API_TOKEN")
+	if token == "" && len(projectTokens) == 0 {
+		return nil, fmt.Errorf("RAILWAY_API_TOKEN or RAILWAY_PROJECT_TOKENS environment variable is required")
+	}
+
+	tokens := NewRailwayTokens(RailwayToken{Value: token, Type: tokenType})
+	tokens.Projects = projectTokens
+	return tokens, nil
+}
+
+// notifierFromEnv returns the notifiers configured by SLACK_WEBHOOK_URL and
+// NOTIFICATION_WEBHOOK_URL, or nil when neither is set.
+func notifierFromEnv() Notifier {
+	var notifiers MultiNotifier
+	if url := os.Getenv("SLACK_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, NewSlackNotifier(url))
+	}
+	if url := os.Getenv("NOTIFICATION_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(url))
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	return notifiers
+}
+
+// batchSizeFromEnv returns the batch size set by RAILWAY_BATCH_SIZE, or
+// defaultBatchSize when it is unset.
+func batchSizeFromEnv() (int, error) {
+	value := os.Getenv("RAILWAY_BATCH_SIZE")
+	if value == "" {
+		return defaultBatchSize, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 || size > maxBatchSize {
+		return 0, fmt.Errorf("invalid RAILWAY_BATCH_SIZE %q: must be between 1 and %d", value, maxBatchSize)
+	}
+	return size, nil
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// commandFlags are the flags shared by the update, plan and list commands.
+type commandFlags struct {
+	fs *flag.FlagSet
+
+	req          UpdateRequest
+	prefixes     stringList
+	repositories stringList
+	globs        stringList
+	regexes      stringList
+	serviceIDs   stringList
+	serviceNames stringList
+	excludes     stringList
+	sources      stringList
+	batchSize    int
+	rollback     bool
+
+	configPath string
+	output     string
+	verbose    bool
+}
+
+func newCommandFlags(command string, stderr io.Writer) *commandFlags {
+	f := &commandFlags{fs: flag.NewFlagSet(command, flag.ContinueOnError)}
+	fs := f.fs
+	fs.SetOutput(stderr)
+
+	fs.StringVar(&f.req.Target, "target", "", "Configured target to update")
+	fs.StringVar(&f.req.ProjectID, "project", "", "Railway project UUID (defaults to the environment's project)")
+	fs.StringVar(&f.req.EnvironmentID, "env", "", "Railway environment UUID")
+	fs.Var(&f.prefixes, "prefix", "Image prefix to match (repeatable)")
+	fs.Var(&f.repositories, "repository", "Exact image repository to match (repeatable)")
+	fs.Var(&f.globs, "glob", "Image repository glob to match (repeatable)")
+	fs.Var(&f.regexes, "regex", "Image repository regular expression to match (repeatable)")
+	fs.Var(&f.serviceIDs, "service-id", "Service UUID to match (repeatable)")
+	fs.Var(&f.serviceNames, "service", "Service name or glob to match (repeatable)")
+	fs.Var(&f.excludes, "exclude", "Service name or glob to skip (repeatable)")
+	fs.StringVar(&f.req.MatchLogic, "match-logic", "", `How image and service criteria combine: "or" or "and"`)
+	fs.StringVar(&f.configPath, "config", os.Getenv("CONFIG_FILE"), "Configuration file with targets")
+	fs.StringVar(&f.output, "output", outputText, `Output format: "text" or "json"`)
+	fs.BoolVar(&f.verbose, "verbose", false, "Log Railway API requests to stderr")
+
+	if command == "list" {
+		fs.Var(&f.sources, "source", `Service source to list, "image" or "repo" (repeatable, default both)`)
+	} else {
+		fs.StringVar(&f.req.NewVersion, "version", "", "New image tag")
+		fs.BoolVar(&f.req.Force, "force", false, "Redeploy services that already run the new image")
+	}
+	if command == "update" {
+		fs.IntVar(&f.batchSize, "batch-size", 0, "Update services in batches of this size, waiting for each to deploy")
+		fs.BoolVar(&f.rollback, "rollback", false, "Roll back the updated services if a batch fails (requires --batch-size)")
+		fs.StringVar(&f.req.FreezeOverrideReason, "freeze-override-reason", "", "Update despite an active freeze window, recording this reason in the audit log")
+	}
+
+	return f
+}
+
+// parse parses args into f.req.
+func (f *commandFlags) parse(args []string) error {
+	if err := f.fs.Parse(args); err != nil {
+		return err
+	}
+	if f.fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(f.fs.Args(), " "))
+	}
+
+	switch f.output {
+	case outputText, outputJSON:
+	default:
+		return fmt.Errorf("invalid --output %q: must be %q or %q", f.output, outputText, outputJSON)
+	}
+
+	f.req.ImagePrefixes = f.prefixes
+	f.req.ServiceIDs = f.serviceIDs
+	f.req.ServiceNames = f.serviceNames
+	f.req.ExcludeServiceNames = f.excludes
+	for _, repository := range f.repositories {
+		f.req.Matchers = append(f.req.Matchers, ImageMatcher{Repository: repository})
+	}
+	for _, glob := range f.globs {
+		f.req.Matchers = append(f.req.Matchers, ImageMatcher{Glob: glob})
+	}
+	for _, regex := range f.regexes {
+		f.req.Matchers = append(f.req.Matchers, ImageMatcher{Regex: regex})
+	}
+
+	if f.batchSize > 0 {
+		f.req.Rollout = &RolloutStrategy{BatchSize: f.batchSize, RollbackOnFailure: f.rollback}
+	} else if f.rollback {
+		return fmt.Errorf("--rollback requires --batch-size")
+	}
+
+	return nil
+}
+
+// config loads the configuration file, if any.
+func (f *commandFlags) config() (*Config, error) {
+	if f.configPath == "" {
+		return &Config{}, nil
+	}
+	return LoadConfig(f.configPath)
+}
+
+// runCommand runs the update, plan or list command with client and returns the
+// process exit code.
+func runCommand(command string, args []string, client *RailwayClient, stdout, stderr io.Writer) int {
+	f := newCommandFlags(command, stderr)
+	if err := f.parse(args); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(stderr, err)
+		}
+		return exitUsage
+	}
+
+	// Railway API logs are noise on the command line unless asked for
+	if !f.verbose {
+		previous := log.Writer()
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(previous)
+	}
+
+	cfg, err := f.config()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	switch command {
+	case "list":
+		return runList(f, cfg, client, stdout, stderr)
+	case "plan":
+		return runPlan(f, cfg, client, stdout, stderr)
+	default:
+		return runUpdate(f, cfg, client, stdout, stderr)
+	}
+}
+
+// resolveProject fills in the project of the request's environment when neither a
+// target nor a project was given, so --env is enough on the command line.
+func resolveProject(req *UpdateRequest, client *RailwayClient) error {
+	if req.Target != "" || req.ProjectID != "" {
+		return nil
+	}
+	if _, err := uuid.Parse(req.EnvironmentID); err != nil {
+		return nil
+	}
+
+	projectID, err := client.getProjectID(req.EnvironmentID)
+	if err != nil {
+		return fmt.Errorf("failed to get project ID: %w", err)
+	}
+	req.ProjectID = projectID
+	return nil
+}
+
+// prepareUpdate resolves and validates the request built from the flags.
+func prepareUpdate(f *commandFlags, cfg *Config, client *RailwayClient, stderr io.Writer) (UpdateOptions, int) {
+	if err := resolveProject(&f.req, client); err != nil {
+		fmt.Fprintln(stderr, err)
+		return UpdateOptions{}, exitFailed
+	}
+
+	opts, reqErr := validateUpdateRequest(&f.req, cfg)
+	if reqErr != nil {
+		fmt.Fprintln(stderr, reqErr)
+		return UpdateOptions{}, exitUsage
+	}
+	return opts, exitOK
+}
+
+// updateOutput is the JSON output of the update command.
+type updateOutput struct {
+	*UpdateResult
+	Error string `json:"error,omitempty"`
+}
+
+func runUpdate(f *commandFlags, cfg *Config, client *RailwayClient, stdout, stderr io.Writer) int {
+	opts, code := prepareUpdate(f, cfg, client, stderr)
+	if code != exitOK {
+		return code
+	}
+
+	// The command line cannot collect a second key's approval, so protected
+	// environments are refused before a freeze override is audited
+	if err := cfg.checkApprovals(f.req.environments()); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	// Holding the Railway token already grants full access, so the command line may
+	// override any freeze as long as it gives a reason
+	identity := "command line"
+	if user := os.Getenv("USER"); user != "" {
+		identity += " (" + user + ")"
+	}
+	audit, err := openAuditLog(os.Getenv("AUDIT_LOG_FILE"), stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+	cliFreezes := *cfg
+	cliFreezes.Freezes = append([]FreezeWindow(nil), cfg.Freezes...)
+	for i := range cliFreezes.Freezes {
+		cliFreezes.Freezes[i].OverrideBy = nil
+	}
+	if reqErr := enforceFreezes(&cliFreezes, &f.req, identity, time.Now(), audit); reqErr != nil {
+		fmt.Fprintln(stderr, reqErr)
+		return exitUsage
+	}
+
+	opts.Owner = identity
+	result, err := client.UpdateServices(f.req.EnvironmentID, opts)
+
+	code = exitOK
+	if err != nil || len(result.Failed) > 0 {
+		code = exitPartial
+		if len(result.Updated) == 0 {
+			code = exitFailed
+		}
+	}
+
+	if f.output == outputJSON {
+		out := updateOutput{UpdateResult: result}
+		if err != nil {
+			out.Error = err.Error()
+		}
+		writeJSON(stdout, out)
+		return code
+	}
+
+	if len(result.Updated) == 0 && len(result.Unchanged) == 0 && err == nil {
+		fmt.Fprintln(stdout, "No services matched the provided filters")
+		return code
+	}
+
+	fmt.Fprintf(stdout, "Updated %d service(s) in environment %s\n", len(result.Updated), result.EnvironmentID)
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, update := range result.Updated {
+		fmt.Fprintf(tw, "  %s\t%s -> %s\n", update.ServiceName, update.PreviousImage, update.NewImage)
+	}
+	tw.Flush()
+	if len(result.Unchanged) > 0 {
+		fmt.Fprintf(stdout, "Unchanged: %s\n", strings.Join(result.Unchanged, ", "))
+	}
+	for _, failure := range result.Failed {
+		fmt.Fprintf(stdout, "Failed: %s: %s\n", failure.ServiceName, failure.Error)
+	}
+	if len(result.RolledBack) > 0 {
+		fmt.Fprintf(stdout, "Rolled back: %s\n", strings.Join(result.RolledBack, ", "))
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to update services: %v\n", err)
+	}
+	return code
+}
+
+func runPlan(f *commandFlags, cfg *Config, client *RailwayClient, stdout, stderr io.Writer) int {
+	opts, code := prepareUpdate(f, cfg, client, stderr)
+	if code != exitOK {
+		return code
+	}
+
+	planned, err := client.PlanUpdate(f.req.EnvironmentID, opts)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+
+	if f.output == outputJSON {
+		writeJSON(stdout, planned)
+		return exitOK
+	}
+
+	if len(planned) == 0 {
+		fmt.Fprintln(stdout, "No services need to be updated")
+		return exitOK
+	}
+
+	fmt.Fprintf(stdout, "Would update %d service(s) in environment %s\n", len(planned), f.req.EnvironmentID)
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, update := range planned {
+		fmt.Fprintf(tw, "  %s\t%s -> %s\n", update.ServiceName, update.PreviousImage, update.NewImage)
+	}
+	tw.Flush()
+	return exitOK
+}
+
+func runList(f *commandFlags, cfg *Config, client *RailwayClient, stdout, stderr io.Writer) int {
+	req := f.req
+	if req.Target != "" {
+		target, ok := cfg.Target(req.Target)
+		if !ok {
+			fmt.Fprintf(stderr, "Unknown target %q\n", req.Target)
+			return exitUsage
+		}
+		if err := target.applyTo(&req); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+	}
+	if _, err := uuid.Parse(req.EnvironmentID); err != nil {
+		fmt.Fprintln(stderr, "Invalid environment_id: must be a valid UUID")
+		return exitUsage
+	}
+
+	// Without filters every image and repo service is listed
+	selected, err := listingSelector(ServiceFilter{
+		ImagePrefixes: req.ImagePrefixes,
+		Matchers:      req.Matchers,
+		ServiceIDs:    req.ServiceIDs,
+		ServiceNames:  req.ServiceNames,
+		ExcludeNames:  req.ExcludeServiceNames,
+		Logic:         req.MatchLogic,
+		Sources:       f.sources,
+		Scope:         req.scope,
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	services, err := client.forProject(req.ProjectID).GetServices(req.EnvironmentID)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitFailed
+	}
+
+	listed := make([]Service, 0, len(services))
+	for _, service := range services {
+		if selected(service) {
+			listed = append(listed, service)
+		}
+	}
+
+	if f.output == outputJSON {
+		writeJSON(stdout, listed)
+		return exitOK
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tID\tIMAGE\tREPLICAS")
+	for _, service := range listed {
+		// Repo services show the repo and branch they track in place of an image
+		image := service.Image
+		if service.Source() == SourceRepo {
+			image = "repo " + service.Repo
+			if service.Branch != "" {
+				image += "@" + service.Branch
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", service.Name, service.ID, image, service.NumReplicas)
+	}
+	tw.Flush()
+	return exitOK
+}
+
+func writeJSON(w io.Writer, v interface{}) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	cliProjectID     = "550e8400-e29b-41d4-a716-446655440000"
+	cliEnvironmentID = "550e8400-e29b-41d4-a716-446655440001"
+)
+
+// cliFake serves two services in a project with a valid UUID and fails
+// ServiceInstanceUpdate for the services named in failing.
+func cliFake(t *testing.T, failing ...string) (*fakeRailway, *RailwayClient) {
+	t.Helper()
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment", "EnvironmentProject":
+			data := environmentData(
+				[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-2", "worker", "ghcr.io/returnearly/worker:v1"},
+			)
+			data["environment"].(map[string]interface{})["projectId"] = cliProjectID
+			return data, nil
+		case "ServiceInstanceUpdate":
+			for _, id := range failing {
+				if variables["serviceId"] == id {
+					return nil, fmt.Errorf("service instance not found")
+				}
+			}
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-" + variables["serviceId"].(string)}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+}
+
+func TestRunCommand_Plan(t *testing.T) {
+	fake, client := cliFake(t)
+	var stdout, stderr bytes.Buffer
+
+	code := runCommand("plan", []string{"--env", cliEnvironmentID, "--glob", "api", "--version", "v2", "--output", "json"}, client, &stdout, &stderr)
+
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+
+	var planned []ServiceUpdate
+	if err := json.Unmarshal(stdout.Bytes(), &planned); err != nil {
+		t.Fatalf("Failed to decode output: %v", err)
+	}
+	if len(planned) != 1 || planned[0].NewImage != "ghcr.io/returnearly/api:v2" {
+		t.Errorf("Expected api to be planned for v2, got %+v", planned)
+	}
+
+	// The project is resolved from the environment and nothing is changed
+	for _, op := range fake.operations() {
+		if op != "Environment" && op != "EnvironmentProject" {
+			t.Errorf("Expected no mutations, got %s", op)
+		}
+	}
+}
+
+func TestRunCommand_UpdateExitCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		failing  []string
+		args     []string
+		expected int
+	}{
+		{"success", nil, []string{"--prefix", "ghcr.io/returnearly/"}, exitOK},
+		{"partial failure", []string{"svc-2"}, []string{"--prefix", "ghcr.io/returnearly/"}, exitPartial},
+		{"total failure", []string{"svc-1"}, []string{"--prefix", "ghcr.io/returnearly/api"}, exitFailed},
+		{"missing version", nil, []string{"--prefix", "ghcr.io/returnearly/", "--version", ""}, exitUsage},
+		{"invalid matcher", nil, []string{"--regex", "("}, exitUsage},
+		{"rollback without batches", nil, []string{"--prefix", "x", "--rollback"}, exitUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := cliFake(t, tt.failing...)
+			var stdout, stderr bytes.Buffer
+
+			args := append([]string{"--project", cliProjectID, "--env", cliEnvironmentID, "--version", "v2"}, tt.args...)
+			code := runCommand("update", args, client, &stdout, &stderr)
+
+			if code != tt.expected {
+				t.Errorf("Expected exit code %d, got %d: %s%s", tt.expected, code, stdout.String(), stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunCommand_UpdateRequiresApproval(t *testing.T) {
+	fake, client := cliFake(t)
+	var stdout, stderr bytes.Buffer
+
+	path := writeConfig(t, "config.yaml", "approvals:\n  environment_ids: ["+cliEnvironmentID+"]\n")
+	args := []string{"--config", path, "--project", cliProjectID, "--env", cliEnvironmentID, "--prefix", "ghcr.io/returnearly/", "--version", "v2"}
+	code := runCommand("update", args, client, &stdout, &stderr)
+
+	if code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+	if !strings.Contains(stderr.String(), "requires approval") {
+		t.Errorf("Expected an approval error, got %q", stderr.String())
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestRunCommand_UpdateJSON(t *testing.T) {
+	_, client := cliFake(t, "svc-2")
+	var stdout, stderr bytes.Buffer
+
+	args := []string{"--project", cliProjectID, "--env", cliEnvironmentID, "--prefix", "ghcr.io/returnearly/", "--version", "v2", "--output", "json"}
+	runCommand("update", args, client, &stdout, &stderr)
+
+	var out struct {
+		UpdatedServices []ServiceUpdate `json:"updated_services"`
+		Error           string          `json:"error"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatalf("Failed to decode output: %v", err)
+	}
+	if len(out.UpdatedServices) != 1 || out.UpdatedServices[0].ServiceName != "api" {
+		t.Errorf("Expected api to be updated, got %+v", out.UpdatedServices)
+	}
+	if !strings.Contains(out.Error, "worker") {
+		t.Errorf("Expected error to mention worker, got %q", out.Error)
+	}
+}
+
+func TestRunCommand_List(t *testing.T) {
+	_, client := cliFake(t)
+	var stdout, stderr bytes.Buffer
+
+	code := runCommand("list", []string{"--env", cliEnvironmentID, "--service", "work*"}, client, &stdout, &stderr)
+
+	if code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	output := stdout.String()
+	if !strings.Contains(output, "ghcr.io/returnearly/worker:v1") || strings.Contains(output, "api") {
+		t.Errorf("Expected only worker to be listed, got:\n%s", output)
+	}
+}
+
+func TestRunCLI_UnknownCommand(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	if code := runCLI([]string{"deploy"}, &stdout, &stderr); code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+}
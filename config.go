@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the optional configuration file loaded from CONFIG_FILE.
+type Config struct {
+	// Targets are named update destinations that /update requests can refer to
+	// instead of passing raw project and environment UUIDs.
+	Targets []Target `json:"targets"`
+
+	// Routes map pushed images to the environments that should be updated when a
+	// registry webhook reports a new tag.
+	Routes []Route `json:"routes"`
+
+	// Drift lists the environments compared by GET /drift.
+	Drift *DriftConfig `json:"drift,omitempty"`
+
+	// Freezes are windows during which updates are refused unless overridden.
+	Freezes []FreezeWindow `json:"freezes,omitempty"`
+
+	// Watchers poll registries that cannot send webhooks for new tags.
+	Watchers []Watcher `json:"watchers,omitempty"`
+
+	// Approvals lists the environments whose /update requests wait for approval.
+	Approvals *ApprovalConfig `json:"approvals,omitempty"`
+}
+
+// Target is a named environment together with the services to update in it and
+// the policy to apply, e.g. "api-staging".
+type Target struct {
+	Name                string         `json:"name"`
+	ProjectID           string         `json:"project_id"`
+	EnvironmentID       string         `json:"environment_id"`
+	ImagePrefixes       []string       `json:"image_prefixes,omitempty"`
+	Matchers            []ImageMatcher `json:"matchers,omitempty"`
+	ServiceIDs          []string       `json:"service_ids,omitempty"`
+	ServiceNames        []string       `json:"service_names,omitempty"`
+	ExcludeServiceNames []string       `json:"exclude_service_names,omitempty"`
+	MatchLogic          string         `json:"match_logic,omitempty"`
+	Policy              TargetPolicy   `json:"policy,omitempty"`
+}
+
+// TargetPolicy controls how updates to a target are applied.
+type TargetPolicy struct {
+	Rollout *RolloutStrategy `json:"rollout,omitempty"`
+}
+
+// Validate checks the target's IDs, filters and policy.
+func (t *Target) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if _, err := uuid.Parse(t.ProjectID); err != nil {
+		return fmt.Errorf("target %s: invalid project_id: must be a valid UUID", t.Name)
+	}
+	if _, err := uuid.Parse(t.EnvironmentID); err != nil {
+		return fmt.Errorf("target %s: invalid environment_id: must be a valid UUID", t.Name)
+	}
+	filter := t.Filter()
+	if err := filter.Validate(); err != nil {
+		return fmt.Errorf("target %s: %w", t.Name, err)
+	}
+	if t.Policy.Rollout != nil {
+		if err := t.Policy.Rollout.Validate(); err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// Filter returns the service filter described by the target. Its matchers are a copy,
+// so validating the filter never writes to the shared configuration.
+func (t *Target) Filter() ServiceFilter {
+	return ServiceFilter{
+		ImagePrefixes: t.ImagePrefixes,
+		Matchers:      append([]ImageMatcher(nil), t.Matchers...),
+		ServiceIDs:    t.ServiceIDs,
+		ServiceNames:  t.ServiceNames,
+		ExcludeNames:  t.ExcludeServiceNames,
+		Logic:         t.MatchLogic,
+	}
+}
+
+// scope returns the validated filter of the target, which limits the services that
+// requests, routes and watchers naming the target may update.
+func (t *Target) scope() (*ServiceFilter, error) {
+	scope := t.Filter()
+	if err := scope.Validate(); err != nil {
+		return nil, fmt.Errorf("target %s: %w", t.Name, err)
+	}
+	return &scope, nil
+}
+
+// applyTo fills an update request from the target. Filters in the request narrow
+// the target's: a service is only updated when the target's filters select it too.
+// The request cannot redirect the target to another project or environment.
+func (t *Target) applyTo(req *UpdateRequest) error {
+	if req.ProjectID != "" || req.EnvironmentID != "" || len(req.EnvironmentIDs) > 0 {
+		return fmt.Errorf("target cannot be combined with project_id, environment_id or environment_ids")
+	}
+
+	scope, err := t.scope()
+	if err != nil {
+		return err
+	}
+	req.scope = scope
+
+	req.ProjectID = t.ProjectID
+	req.EnvironmentID = t.EnvironmentID
+
+	if len(req.ImagePrefixes) == 0 && len(req.Matchers) == 0 && len(req.ServiceIDs) == 0 && len(req.ServiceNames) == 0 {
+		req.ImagePrefixes = t.ImagePrefixes
+		req.Matchers = append([]ImageMatcher(nil), t.Matchers...)
+		req.ServiceIDs = t.ServiceIDs
+		req.ServiceNames = t.ServiceNames
+		req.MatchLogic = t.MatchLogic
+	}
+	req.ExcludeServiceNames = append(req.ExcludeServiceNames, t.ExcludeServiceNames...)
+
+	if req.Rollout == nil {
+		req.Rollout = t.Policy.Rollout
+	}
+	return nil
+}
+
+// Target returns the target with the given name.
+func (c *Config) Target(name string) (*Target, bool) {
+	for i := range c.Targets {
+		if c.Targets[i].Name == name {
+			return &c.Targets[i], true
+		}
+	}
+	return nil, false
+}
+
+// LoadConfig reads and validates a configuration file. Files ending in .yaml or .yml
+// are parsed as YAML, everything else as JSON.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		// Decode YAML generically and re-encode it as JSON so the config types only
+		// need their json tags.
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// Validate checks every target and route in the configuration and resolves routes
+// that refer to a target.
+func (c *Config) Validate() error {
+	names := make(map[string]bool)
+	for i := range c.Targets {
+		if err := c.Targets[i].Validate(); err != nil {
+			return fmt.Errorf("targets[%d]: %w", i, err)
+		}
+		if names[c.Targets[i].Name] {
+			return fmt.Errorf("targets[%d]: duplicate target name %s", i, c.Targets[i].Name)
+		}
+		names[c.Targets[i].Name] = true
+	}
+
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if route.Target != "" {
+			if route.ProjectID != "" || route.EnvironmentID != "" {
+				return fmt.Errorf("routes[%d]: target cannot be combined with project_id or environment_id", i)
+			}
+			target, ok := c.Target(route.Target)
+			if !ok {
+				return fmt.Errorf("routes[%d]: unknown target %s", i, route.Target)
+			}
+			scope, err := target.scope()
+			if err != nil {
+				return fmt.Errorf("routes[%d]: %w", i, err)
+			}
+			route.ProjectID = target.ProjectID
+			route.EnvironmentID = target.EnvironmentID
+			route.scope = scope
+		}
+		if err := route.Validate(); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+
+	names = make(map[string]bool)
+	for i := range c.Watchers {
+		watcher := &c.Watchers[i]
+		if watcher.Target != "" {
+			if watcher.ProjectID != "" || watcher.EnvironmentID != "" {
+				return fmt.Errorf("watchers[%d]: target cannot be combined with project_id or environment_id", i)
+			}
+			target, ok := c.Target(watcher.Target)
+			if !ok {
+				return fmt.Errorf("watchers[%d]: unknown target %s", i, watcher.Target)
+			}
+			scope, err := target.scope()
+			if err != nil {
+				return fmt.Errorf("watchers[%d]: %w", i, err)
+			}
+			watcher.ProjectID = target.ProjectID
+			watcher.EnvironmentID = target.EnvironmentID
+			watcher.scope = scope
+		}
+		if err := watcher.Validate(); err != nil {
+			return fmt.Errorf("watchers[%d]: %w", i, err)
+		}
+		if names[watcher.Name] {
+			return fmt.Errorf("watchers[%d]: duplicate watcher name %s", i, watcher.Name)
+		}
+		names[watcher.Name] = true
+	}
+
+	if c.Drift != nil {
+		if err := c.Drift.validate(c); err != nil {
+			return fmt.Errorf("drift: %w", err)
+		}
+	}
+
+	for i := range c.Freezes {
+		if err := c.Freezes[i].Validate(c); err != nil {
+			return fmt.Errorf("freezes[%d]: %w", i, err)
+		}
+	}
+
+	if c.Approvals != nil {
+		if err := c.Approvals.validate(c); err != nil {
+			return fmt.Errorf("approvals: %w", err)
+		}
+	}
+	return nil
+}
+
+// ValidateConfig checks the configuration against the Railway API: every referenced
+// environment must exist and belong to the configured project.
+func (c *RailwayClient) ValidateConfig(cfg *Config) error {
+	expected := make(map[string]string)
+	for _, target := range cfg.Targets {
+		expected[target.EnvironmentID] = target.ProjectID
+	}
+	for _, route := range cfg.Routes {
+		expected[route.EnvironmentID] = route.ProjectID
+	}
+	for _, watcher := range cfg.Watchers {
+		expected[watcher.EnvironmentID] = watcher.ProjectID
+	}
+
+	for environmentID, projectID := range expected {
+		actual, err := c.getProjectID(environmentID)
+		if err != nil {
+			return fmt.Errorf("environment %s: %w", environmentID, err)
+		}
+		if actual != projectID {
+			return fmt.Errorf("environment %s belongs to project %s, not %s", environmentID, actual, projectID)
+		}
+	}
+	return nil
+}
+
+// ConfigStore holds the current configuration and reloads it when the file changes.
+type ConfigStore struct {
+	path     string
+	validate func(*Config) error
+	current  atomic.Pointer[Config]
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// NewConfigStore returns a store that always serves cfg.
+func NewConfigStore(cfg *Config) *ConfigStore {
+	s := &ConfigStore{}
+	s.current.Store(cfg)
+	return s
+}
+
+// LoadConfigStore loads path and validates it with validate, which may be nil.
+func LoadConfigStore(path string, validate func(*Config) error) (*ConfigStore, error) {
+	s := &ConfigStore{path: path, validate: validate}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the current configuration.
+func (s *ConfigStore) Get() *Config {
+	return s.current.Load()
+}
+
+// Watch polls the config file every interval and reloads it when its modification
+// time changes. An invalid file is logged and the previous configuration kept. The
+// returned function stops watching.
+func (s *ConfigStore) Watch(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	if s.path == "" {
+		return func() {}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(s.path)
+			if err != nil {
+				log.Printf("Failed to stat config %s: %v", s.path, err)
+				continue
+			}
+
+			s.mu.Lock()
+			changed := !info.ModTime().Equal(s.modTime)
+			s.mu.Unlock()
+			if !changed {
+				continue
+			}
+
+			if err := s.reload(); err != nil {
+				log.Printf("Keeping previous config, reload failed: %v", err)
+				continue
+			}
+			log.Printf("Reloaded config from %s", s.path)
+		}
+	}()
+
+	return sync.OnceFunc(func() { close(done) })
+}
+
+func (s *ConfigStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	// Record the attempt so a broken file is not re-parsed until it changes again
+	s.modTime = info.ModTime()
+
+	cfg, err := LoadConfig(s.path)
+	if err != nil {
+		return err
+	}
+	if s.validate != nil {
+		if err := s.validate(cfg); err != nil {
+			return fmt.Errorf("invalid config %s: %w", s.path, err)
+		}
+	}
+
+	s.current.Store(cfg)
+	return nil
+}
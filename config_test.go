@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testConfigYAML = `
+targets:
+  - name: api-staging
+    project_id: 550e8400-e29b-41d4-a716-446655440000
+    environment_id: 550e8400-e29b-41d4-a716-446655440001
+    matchers:
+      - repository: ghcr.io/returnearly/api
+    policy:
+      rollout:
+        batch_size: 1
+routes:
+  - name: api-staging-push
+    repository:
+      repository: ghcr.io/returnearly/api
+    target: api-staging
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_YAML(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, "config.yaml", testConfigYAML))
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+
+	target, ok := cfg.Target("api-staging")
+	if !ok {
+		t.Fatal("Expected api-staging target")
+	}
+	if target.Policy.Rollout == nil || target.Policy.Rollout.BatchSize != 1 {
+		t.Errorf("Expected rollout policy, got %+v", target.Policy)
+	}
+
+	if cfg.Routes[0].EnvironmentID != target.EnvironmentID {
+		t.Errorf("Expected route to resolve target environment, got %q", cfg.Routes[0].EnvironmentID)
+	}
+}
+
+func TestLoadConfig_JSON(t *testing.T) {
+	content := `{"targets":[{"name":"web","project_id":"550e8400-e29b-41d4-a716-446655440000",` +
+		`"environment_id":"550e8400-e29b-41d4-a716-446655440001","image_prefixes":["returnearly/web"]}]}`
+
+	cfg, err := LoadConfig(writeConfig(t, "config.json", content))
+	if err != nil {
+		t.Fatalf("LoadConfig returned error: %v", err)
+	}
+	if _, ok := cfg.Target("web"); !ok {
+		t.Error("Expected web target")
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"bad target id", "targets:\n  - name: x\n    project_id: nope\n    environment_id: nope\n    image_prefixes: [x]\n"},
+		{"target without filters", "targets:\n  - name: x\n    project_id: 550e8400-e29b-41d4-a716-446655440000\n    environment_id: 550e8400-e29b-41d4-a716-446655440001\n"},
+		{"unknown route target", "routes:\n  - name: r\n    repository: {repository: x}\n    target: missing\n"},
+		{"route target and project", testConfigYAML + "  - name: r\n    repository: {repository: x}\n    target: api-staging\n    project_id: 550e8400-e29b-41d4-a716-446655440009\n"},
+		{"duplicate target", "targets:\n" +
+			"  - {name: x, project_id: 550e8400-e29b-41d4-a716-446655440000, environment_id: 550e8400-e29b-41d4-a716-446655440001, image_prefixes: [x]}\n" +
+			"  - {name: x, project_id: 550e8400-e29b-41d4-a716-446655440000, environment_id: 550e8400-e29b-41d4-a716-446655440001, image_prefixes: [x]}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadConfig(writeConfig(t, "config.yaml", tt.content)); err == nil {
+				t.Error("Expected LoadConfig error")
+			}
+		})
+	}
+}
+
+func TestConfigStore_Reload(t *testing.T) {
+	path := writeConfig(t, "config.yaml", testConfigYAML)
+	store, err := LoadConfigStore(path, nil)
+	if err != nil {
+		t.Fatalf("LoadConfigStore returned error: %v", err)
+	}
+
+	// An invalid file keeps the previous config
+	if err := os.WriteFile(path, []byte("targets: [{name: broken}]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.reload(); err == nil {
+		t.Error("Expected reload error for invalid config")
+	}
+	if _, ok := store.Get().Target("api-staging"); !ok {
+		t.Error("Expected previous config to be kept")
+	}
+
+	updated := testConfigYAML + "  - name: web-push\n    repository: {repository: returnearly/web}\n    target: api-staging\n"
+	if err := os.WriteFile(path, []byte(updated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.reload(); err != nil {
+		t.Fatalf("reload returned error: %v", err)
+	}
+	if routes := store.Get().Routes; len(routes) != 2 {
+		t.Errorf("Expected 2 routes after reload, got %d", len(routes))
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		return map[string]interface{}{
+			"environment": map[string]interface{}{"projectId": "550e8400-e29b-41d4-a716-446655440099"},
+		}, nil
+	})
+
+	cfg, err := LoadConfig(writeConfig(t, "config.yaml", testConfigYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.ValidateConfig(cfg); err == nil {
+		t.Error("Expected error for environment in another project")
+	}
+}
+
+func TestHandleUpdate_Target(t *testing.T) {
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData(
+				[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-2", "api-legacy", "ghcr.io/returnearly/api-legacy:v1"},
+			), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		case "Deployment":
+			return map[string]interface{}{"deployment": map[string]interface{}{"id": "deploy-1", "status": "SUCCESS"}}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+
+	cfg, err := LoadConfig(writeConfig(t, "config.yaml", testConfigYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(client, cfg)
+
+	body, _ := json.Marshal(UpdateRequest{Target: "api-staging", NewVersion: "v1.2.3"})
+	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	server.handleUpdate(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp SuccessResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.UpdatedServices) != 1 || resp.UpdatedServices[0] != "api" {
+		t.Errorf("Expected only api to be updated, got %v", resp.UpdatedServices)
+	}
+
+	// The target's rollout policy waits for the deployment
+	polled := false
+	for _, op := range fake.operations() {
+		if op == "Deployment" {
+			polled = true
+		}
+	}
+	if !polled {
+		t.Error("Expected the target's rollout policy to be applied")
+	}
+}
+
+func TestHandleUpdate_UnknownTarget(t *testing.T) {
+	client := NewRailwayClient("test-token", "", "")
+	body, _ := json.Marshal(UpdateRequest{Target: "missing", NewVersion: "v1.2.3"})
+	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).handleUpdate(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestConfigStore_Watch(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "targets: []\n")
+	store, err := LoadConfigStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := store.Watch(5 * time.Millisecond)
+	defer stop()
+
+	// Ensure the modification time moves even on coarse-grained filesystems
+	later := time.Now().Add(time.Second)
+	if err := os.WriteFile(path, []byte(testConfigYAML), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := store.Get().Target("api-staging"); ok {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Error("Expected config to be reloaded")
+}
+
+func TestHandleUpdate_TargetFiltersNarrow(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      UpdateRequest
+		expected string
+	}{
+		{"service name glob", UpdateRequest{Target: "api-staging", ServiceNames: []string{"*"}, NewVersion: "v1.2.3"}, "api"},
+		{"image prefix", UpdateRequest{Target: "api-staging", ImagePrefixes: []string{"ghcr.io/returnearly/"}, NewVersion: "v1.2.3"}, "api"},
+		{"outside the target", UpdateRequest{Target: "api-staging", ServiceNames: []string{"api-legacy"}, NewVersion: "v1.2.3"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+				switch operation {
+				case "Environment":
+					return environmentData(
+						[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"},
+						[3]string{"svc-2", "api-legacy", "ghcr.io/returnearly/api-legacy:v1"},
+					), nil
+				case "ServiceInstanceDeployV2":
+					return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+				case "Deployment":
+					return map[string]interface{}{"deployment": map[string]interface{}{"id": "deploy-1", "status": "SUCCESS"}}, nil
+				}
+				return map[string]interface{}{}, nil
+			})
+			cfg, err := LoadConfig(writeConfig(t, "config.yaml", testConfigYAML))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, _ := json.Marshal(tt.req)
+			req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+			newTestServer(client, cfg).handleUpdate(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			var resp SuccessResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if updated := strings.Join(resp.UpdatedServices, ","); updated != tt.expected {
+				t.Errorf("Expected updated services %q, got %q", tt.expected, updated)
+			}
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			for _, call := range fake.calls {
+				if call.Variables["serviceId"] == "svc-2" {
+					t.Errorf("Expected api-legacy to be untouched, got %s", call.Operation)
+				}
+			}
+		})
+	}
+}
+
+// Run with -race: requests for a target must not write to its shared matchers.
+func TestValidateUpdateRequest_ConcurrentTarget(t *testing.T) {
+	cfg := &Config{Targets: []Target{{
+		Name:          "api",
+		ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+		Matchers:      []ImageMatcher{{Regex: "ghcr.io/returnearly/(api|worker)"}},
+	}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := UpdateRequest{Target: "api", NewVersion: "v2"}
+			opts, reqErr := validateUpdateRequest(&req, cfg)
+			if reqErr != nil {
+				t.Errorf("validateUpdateRequest returned error: %v", reqErr)
+				return
+			}
+			if !opts.Filter.MatchesImage("ghcr.io/returnearly/api:v1") {
+				t.Error("Expected the target's regex to match")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTriggerRoutes_TargetScope(t *testing.T) {
+	fake, client := registryFake(t)
+	cfg := &Config{
+		Targets: []Target{{
+			Name:                "staging",
+			ProjectID:           "550e8400-e29b-41d4-a716-446655440000",
+			EnvironmentID:       "550e8400-e29b-41d4-a716-446655440001",
+			ImagePrefixes:       []string{"ghcr.io/returnearly/"},
+			ExcludeServiceNames: []string{"api-legacy"},
+		}},
+		Routes: []Route{{Name: "legacy-push", Repository: ImageMatcher{Glob: "ghcr.io/returnearly/*"}, Target: "staging"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+
+	results := triggerRoutes(client, cfg, []string{"ghcr.io/returnearly/api-legacy"}, "v2")
+
+	if len(results) != 1 || len(results[0].UpdatedServices) != 0 {
+		t.Errorf("Expected the target to exclude api-legacy, got %+v", results)
+	}
+	for _, op := range fake.operations() {
+		if op != "Environment" {
+			t.Errorf("Expected no changes, got %v", fake.operations())
+			break
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errServiceNotFound = errors.New("service not found")
+	errNotRepoService  = errors.New("service is not deployed from a repo")
+)
+
+// ConversionResult describes a repo service switched to a prebuilt image.
+type ConversionResult struct {
+	EnvironmentID  string `json:"environment_id"`
+	ServiceID      string `json:"service_id"`
+	ServiceName    string `json:"service_name"`
+	PreviousRepo   string `json:"previous_repo"`
+	PreviousBranch string `json:"previous_branch,omitempty"`
+	Image          string `json:"image"`
+	DeploymentID   string `json:"deployment_id,omitempty"`
+}
+
+// ConvertToImage switches the repo service identified by service (an ID or name) to
+// image and deploys it, while holding the environment's lock. Afterwards the service
+// is an image service that updates apply to.
+func (c *RailwayClient) ConvertToImage(environmentID, service, image, owner string) (*ConversionResult, error) {
+	release, err := c.locks.Acquire(environmentID, owner, image, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	result, err := c.convertToImage(environmentID, service, image)
+
+	if c.notifier != nil && result != nil {
+		update := &UpdateResult{EnvironmentID: environmentID, Updated: make([]ServiceUpdate, 0)}
+		if err == nil {
+			update.Updated = append(update.Updated, ServiceUpdate{
+				ServiceID:     result.ServiceID,
+				ServiceName:   result.ServiceName,
+				PreviousImage: "repo " + result.PreviousRepo,
+				NewImage:      image,
+				DeploymentID:  result.DeploymentID,
+			})
+		} else {
+			update.Failed = append(update.Failed, ServiceFailure{ServiceName: result.ServiceName, Error: err.Error()})
+		}
+		if notifyErr := c.notifier.Notify(newUpdateSummary(update, image, err, time.Since(start))); notifyErr != nil {
+			log.Printf("Failed to send update notification: %v", notifyErr)
+		}
+	}
+
+	return result, err
+}
+
+// convertToImage returns a partial result once the service is found, so failures can
+// be attributed to it.
+func (c *RailwayClient) convertToImage(environmentID, name, image string) (*ConversionResult, error) {
+	services, err := c.GetServices(environmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+
+	var service *Service
+	for i := range services {
+		if services[i].ID == name || services[i].Name == name {
+			service = &services[i]
+			break
+		}
+	}
+	if service == nil {
+		return nil, fmt.Errorf("%w: %s", errServiceNotFound, name)
+	}
+	if service.Source() != SourceRepo {
+		return nil, fmt.Errorf("%w: %s runs image %s", errNotRepoService, service.Name, service.Image)
+	}
+
+	result := &ConversionResult{
+		EnvironmentID:  environmentID,
+		ServiceID:      service.ID,
+		ServiceName:    service.Name,
+		PreviousRepo:   service.Repo,
+		PreviousBranch: service.Branch,
+		Image:          image,
+	}
+
+	log.Printf("Converting service %s from repo %s to image %s (replicas=%d)", service.Name, service.Repo, image, service.NumReplicas)
+
+	source := serviceSourceInput{Image: image, Repo: json.RawMessage("null")}
+	if err := c.updateServiceInstanceSource(service.ID, environmentID, source, service.NumReplicas); err != nil {
+		return result, fmt.Errorf("failed to convert service %s: %w", service.Name, err)
+	}
+
+	result.DeploymentID, err = c.deployServiceInstance(service.ID, environmentID)
+	if err != nil {
+		return result, fmt.Errorf("failed to deploy service %s: %w", service.Name, err)
+	}
+
+	return result, nil
+}
+
+// ConvertRequest is the body of POST /environments/{id}/services/{service}/convert.
+type ConvertRequest struct {
+	Image                string `json:"image"`
+	FreezeOverrideReason string `json:"freeze_override_reason,omitempty"`
+}
+
+type ConvertResponse struct {
+	Message string `json:"message"`
+	ConversionResult
+}
+
+// handleConvertService serves POST /environments/{id}/services/{service}/convert,
+// switching a repo service to a prebuilt image. Conversions respect freeze windows
+// but cannot be approved, so they are refused in environments requiring approval.
+func (s *Server) handleConvertService(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use POST"})
+		return
+	}
+
+	identity, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	environmentID := r.PathValue("id")
+	if _, err := uuid.Parse(environmentID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid environment_id: must be a valid UUID"})
+		return
+	}
+
+	var body ConvertRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateBodySize)).Decode(&body); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit)})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Invalid JSON: %v", err)})
+		return
+	}
+	ref := parseImageRef(body.Image)
+	if ref.Repository == "" || strings.ContainsAny(body.Image, " \t\n") || (ref.Tag == "" && ref.Digest == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "image must be an image reference with a tag or digest"})
+		return
+	}
+
+	cfg := s.config.Get()
+	req := UpdateRequest{
+		EnvironmentID:        environmentID,
+		NewVersion:           body.Image,
+		FreezeOverrideReason: body.FreezeOverrideReason,
+	}
+	// Approval is checked first so a refused conversion does not audit a freeze override
+	if cfg.RequiresApproval(req.environments()) {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Services in environments that require approval cannot be converted"})
+		return
+	}
+	if reqErr := enforceFreezes(cfg, &req, identity, s.now(), s.audit); reqErr != nil {
+		w.WriteHeader(reqErr.Status)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: reqErr.Message})
+		return
+	}
+
+	service := r.PathValue("service")
+	owner := "POST convert from " + r.RemoteAddr
+	if identity != "" {
+		owner = identity + " via POST convert"
+	}
+	result, err := s.client.ConvertToImage(environmentID, service, body.Image, owner)
+	if err != nil {
+		status := updateErrorStatus(err)
+		switch {
+		case errors.Is(err, errServiceNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errNotRepoService):
+			status = http.StatusConflict
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to convert service: %v", err)})
+		return
+	}
+
+	s.audit.Record(AuditEntry{
+		Action:        AuditConverted,
+		Identity:      identity,
+		EnvironmentID: environmentID,
+		Version:       body.Image,
+		Detail:        fmt.Sprintf("%s from repo %s", result.ServiceName, result.PreviousRepo),
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ConvertResponse{
+		Message:          fmt.Sprintf("Converted service %s from repo %s to image %s", result.ServiceName, result.PreviousRepo, result.Image),
+		ConversionResult: *result,
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const convertPath = "/environments/550e8400-e29b-41d4-a716-446655440001/services/"
+
+func convertFake(t *testing.T) (*fakeRailway, *RailwayClient) {
+	t.Helper()
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return inventoryData(), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-9"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+}
+
+func TestHandleConvertService(t *testing.T) {
+	fake, client := convertFake(t)
+	req := httptest.NewRequest(http.MethodPost, convertPath+"docs/convert", strings.NewReader(`{"image":"ghcr.io/returnearly/docs:v1"}`))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).routes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp ConvertResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.ServiceID != "svc-3" || resp.PreviousRepo != "returnearly/docs" || resp.PreviousBranch != "main" || resp.DeploymentID != "deploy-9" {
+		t.Errorf("Unexpected conversion %+v", resp.ConversionResult)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	var source map[string]interface{}
+	for _, call := range fake.calls {
+		if call.Operation == "ServiceInstanceUpdate" {
+			source = call.Variables["input"].(map[string]interface{})["source"].(map[string]interface{})
+		}
+	}
+	if source["image"] != "ghcr.io/returnearly/docs:v1" {
+		t.Errorf("Expected image ghcr.io/returnearly/docs:v1, got %v", source["image"])
+	}
+	if repo, ok := source["repo"]; !ok || repo != nil {
+		t.Errorf("Expected the repo to be cleared, got %v", source)
+	}
+}
+
+func TestHandleConvertService_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		body    string
+		status  int
+	}{
+		{"image service", "api", `{"image":"ghcr.io/returnearly/api:v2"}`, http.StatusConflict},
+		{"unknown service", "billing", `{"image":"ghcr.io/returnearly/billing:v1"}`, http.StatusNotFound},
+		{"missing tag", "docs", `{"image":"ghcr.io/returnearly/docs"}`, http.StatusBadRequest},
+		{"missing image", "docs", `{}`, http.StatusBadRequest},
+		{"body too large", "docs", `{"image":"` + strings.Repeat("x", maxUpdateBodySize) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := convertFake(t)
+			req := httptest.NewRequest(http.MethodPost, convertPath+tt.service+"/convert", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			newTestServer(client, nil).routes().ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			for _, op := range fake.operations() {
+				if op == "ServiceInstanceUpdate" {
+					t.Errorf("Expected no service update, got %v", fake.operations())
+				}
+			}
+		})
+	}
+}
+
+func TestHandleConvertService_RequiresApproval(t *testing.T) {
+	fake, client := convertFake(t)
+	cfg := &Config{
+		Freezes:   []FreezeWindow{{Name: "always", EnvironmentIDs: []string{approvalEnvironmentID}, Cron: "* * * * *"}},
+		Approvals: &ApprovalConfig{EnvironmentIDs: []string{approvalEnvironmentID}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+	server := newTestServer(client, cfg)
+	server.apiKeys = APIKeys{"alice": "alice-key"}
+	var audit bytes.Buffer
+	server.audit = NewAuditLog(&audit)
+
+	body := ConvertRequest{Image: "ghcr.io/returnearly/docs:v1", FreezeOverrideReason: "hotfix"}
+	w := sendAuthorized(server.routes(), http.MethodPost, convertPath+"docs/convert", "alice-key", body)
+
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "approval") {
+		t.Errorf("Expected status %d for approval, got %d: %s", http.StatusForbidden, w.Code, w.Body.String())
+	}
+	if audit.Len() != 0 {
+		t.Errorf("Expected no freeze override to be audited, got %s", audit.String())
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule_Matches(t *testing.T) {
+	// 2024-05-03 is a Friday
+	friday := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 3, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		at       time.Time
+		expected bool
+	}{
+		{"every minute", "* * * * *", friday(9, 0), true},
+		{"friday afternoon", "* 15-23 * * 5", friday(15, 0), true},
+		{"friday morning", "* 15-23 * * 5", friday(14, 59), false},
+		{"sunday as 7", "* * * * 7", time.Date(2024, 5, 5, 12, 0, 0, 0, time.UTC), true},
+		{"list", "0,30 * * * *", friday(10, 30), true},
+		{"step", "*/15 * * * *", friday(10, 45), true},
+		{"step miss", "*/15 * * * *", friday(10, 46), false},
+		{"step from value", "5/15 * * * *", friday(10, 50), true},
+		{"step from value miss", "5/15 * * * *", friday(10, 0), false},
+		{"month", "* * * 12 *", friday(10, 0), false},
+		{"day of month or week", "* * 24 * 5", friday(10, 0), true},
+		{"day of month and step", "* * */2 * 5", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron returned error: %v", err)
+			}
+			if got := schedule.matches(tt.at); got != tt.expected {
+				t.Errorf("Expected %q at %s to be %v, got %v", tt.expr, tt.at, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 5-2 * * *", "*/0 * * * *", "x * * * *", "* * 0 * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected error for %q", expr)
+		}
+	}
+}
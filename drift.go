@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultDriftCheckInterval is how often drift is checked in the background.
+const defaultDriftCheckInterval = 5 * time.Minute
+
+// driftMetrics are published on /debug/vars:
+//   - drifted_repositories: repositories whose versions differ in the last check
+//   - persistent_repositories: those drifted for longer than the threshold
+//   - alerts: alerts sent for persistent drift
+var driftMetrics = expvar.NewMap("drift")
+
+// DriftConfig lists the environments whose images are compared by GET /drift.
+type DriftConfig struct {
+	Environments []DriftEnvironment `json:"environments"`
+
+	// ThresholdSeconds is how long drift may persist before it is reported as
+	// persistent and an alert is sent. Zero disables alerts.
+	ThresholdSeconds int `json:"threshold_seconds,omitempty"`
+
+	// CheckIntervalSeconds is how often drift is checked in the background so
+	// alerts fire without anyone calling GET /drift (default 300).
+	CheckIntervalSeconds int `json:"check_interval_seconds,omitempty"`
+
+	// WebhookURL receives a JSON POST for every repository whose drift becomes
+	// persistent.
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// DriftEnvironment is an environment compared for drift, given directly or by target.
+type DriftEnvironment struct {
+	Name          string `json:"name,omitempty"`
+	EnvironmentID string `json:"environment_id,omitempty"`
+	Target        string `json:"target,omitempty"`
+}
+
+// validate resolves targets and checks that at least two environments are compared.
+func (d *DriftConfig) validate(cfg *Config) error {
+	if len(d.Environments) < 2 {
+		return fmt.Errorf("at least two environments must be compared")
+	}
+	if d.ThresholdSeconds < 0 || d.CheckIntervalSeconds < 0 {
+		return fmt.Errorf("threshold_seconds and check_interval_seconds cannot be negative")
+	}
+
+	names := make(map[string]bool)
+	for i := range d.Environments {
+		env := &d.Environments[i]
+		if env.Target != "" {
+			target, ok := cfg.Target(env.Target)
+			if !ok {
+				return fmt.Errorf("environments[%d]: unknown target %s", i, env.Target)
+			}
+			env.EnvironmentID = target.EnvironmentID
+			if env.Name == "" {
+				env.Name = target.Name
+			}
+		}
+		if _, err := uuid.Parse(env.EnvironmentID); err != nil {
+			return fmt.Errorf("environments[%d]: invalid environment_id: must be a valid UUID", i)
+		}
+		if env.Name == "" {
+			env.Name = env.EnvironmentID
+		}
+		if names[env.Name] {
+			return fmt.Errorf("environments[%d]: duplicate environment name %s", i, env.Name)
+		}
+		names[env.Name] = true
+	}
+	return nil
+}
+
+func (d *DriftConfig) threshold() time.Duration {
+	return time.Duration(d.ThresholdSeconds) * time.Second
+}
+
+func (d *DriftConfig) checkInterval() time.Duration {
+	if d.CheckIntervalSeconds > 0 {
+		return time.Duration(d.CheckIntervalSeconds) * time.Second
+	}
+	return defaultDriftCheckInterval
+}
+
+// DeployedVersion is the version of a repository a service runs in one environment.
+type DeployedVersion struct {
+	Environment   string `json:"environment"`
+	EnvironmentID string `json:"environment_id"`
+	ServiceName   string `json:"service_name"`
+	Image         string `json:"image"`
+	Version       string `json:"version"`
+}
+
+// RepositoryDrift is a repository that runs different versions across environments.
+type RepositoryDrift struct {
+	Repository      string            `json:"repository"`
+	FirstDetectedAt time.Time         `json:"first_detected_at"`
+	Persistent      bool              `json:"persistent"`
+	Versions        []DeployedVersion `json:"versions"`
+}
+
+type DriftReport struct {
+	Environments []string          `json:"environments"`
+	CheckedAt    time.Time         `json:"checked_at"`
+	Drift        []RepositoryDrift `json:"drift"`
+}
+
+// DriftAlert is posted to the drift webhook when drift becomes persistent.
+type DriftAlert struct {
+	Event string `json:"event"`
+	RepositoryDrift
+}
+
+// DriftMonitor compares the configured environments and remembers since when each
+// repository has drifted, so persistent drift can be alerted on once.
+type DriftMonitor struct {
+	client     *RailwayClient
+	config     *ConfigStore
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu        sync.Mutex
+	firstSeen map[string]time.Time
+	alerted   map[string]bool
+}
+
+func NewDriftMonitor(client *RailwayClient, config *ConfigStore) *DriftMonitor {
+	return &DriftMonitor{
+		client:     client,
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+		firstSeen:  make(map[string]time.Time),
+		alerted:    make(map[string]bool),
+	}
+}
+
+// imageVersion identifies what an image reference runs: its tag, its digest, or both.
+func imageVersion(ref ImageRef) string {
+	switch {
+	case ref.Digest == "":
+		return ref.Tag
+	case ref.Tag == "":
+		return "@" + ref.Digest
+	default:
+		return ref.Tag + "@" + ref.Digest
+	}
+}
+
+// findDrift groups the services of every environment by repository and returns the
+// repositories that appear in more than one environment with differing versions.
+func findDrift(versions []DeployedVersion) map[string][]DeployedVersion {
+	byRepository := make(map[string][]DeployedVersion)
+	for _, version := range versions {
+		repository := parseImageRef(version.Image).Repository
+		byRepository[repository] = append(byRepository[repository], version)
+	}
+
+	drifted := make(map[string][]DeployedVersion)
+	for repository, deployed := range byRepository {
+		environments := make(map[string]bool)
+		distinct := make(map[string]bool)
+		for _, version := range deployed {
+			environments[version.EnvironmentID] = true
+			distinct[version.Version] = true
+		}
+		if len(environments) > 1 && len(distinct) > 1 {
+			drifted[repository] = deployed
+		}
+	}
+	return drifted
+}
+
+// Check compares the configured environments, records when drift was first seen and
+// alerts on drift that has persisted past the threshold. It returns nil when drift
+// detection is not configured.
+func (m *DriftMonitor) Check() (*DriftReport, error) {
+	cfg := m.config.Get().Drift
+	if cfg == nil {
+		return nil, nil
+	}
+
+	report := &DriftReport{CheckedAt: m.now(), Drift: make([]RepositoryDrift, 0)}
+	var versions []DeployedVersion
+	for _, env := range cfg.Environments {
+		report.Environments = append(report.Environments, env.Name)
+
+		services, err := m.client.GetServices(env.EnvironmentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get services for %s: %w", env.Name, err)
+		}
+		for _, service := range services {
+			if service.Image == "" {
+				continue
+			}
+			versions = append(versions, DeployedVersion{
+				Environment:   env.Name,
+				EnvironmentID: env.EnvironmentID,
+				ServiceName:   service.Name,
+				Image:         service.Image,
+				Version:       imageVersion(parseImageRef(service.Image)),
+			})
+		}
+	}
+	drifted := findDrift(versions)
+
+	m.mu.Lock()
+	var alerts []RepositoryDrift
+	for repository := range m.firstSeen {
+		if _, ok := drifted[repository]; !ok {
+			delete(m.firstSeen, repository)
+			delete(m.alerted, repository)
+		}
+	}
+
+	persistent := 0
+	for repository, deployed := range drifted {
+		firstSeen, ok := m.firstSeen[repository]
+		if !ok {
+			firstSeen = report.CheckedAt
+			m.firstSeen[repository] = firstSeen
+		}
+
+		drift := RepositoryDrift{
+			Repository:      repository,
+			FirstDetectedAt: firstSeen,
+			Persistent:      cfg.ThresholdSeconds > 0 && report.CheckedAt.Sub(firstSeen) >= cfg.threshold(),
+			Versions:        deployed,
+		}
+		if drift.Persistent {
+			persistent++
+			if !m.alerted[repository] {
+				m.alerted[repository] = true
+				alerts = append(alerts, drift)
+			}
+		}
+		report.Drift = append(report.Drift, drift)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(report.Drift, func(i, j int) bool {
+		return report.Drift[i].Repository < report.Drift[j].Repository
+	})
+
+	driftMetrics.Set("drifted_repositories", intVar(len(drifted)))
+	driftMetrics.Set("persistent_repositories", intVar(persistent))
+	for _, drift := range alerts {
+		driftMetrics.Add("alerts", 1)
+		log.Printf("Drift for %s has persisted since %s", drift.Repository, drift.FirstDetectedAt.Format(time.RFC3339))
+		if cfg.WebhookURL != "" {
+			if err := m.sendAlert(cfg.WebhookURL, drift); err != nil {
+				log.Printf("Failed to send drift alert for %s: %v", drift.Repository, err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func intVar(n int) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(int64(n))
+	return v
+}
+
+func (m *DriftMonitor) sendAlert(url string, drift RepositoryDrift) error {
+	return postJSON(m.httpClient, url, DriftAlert{Event: "drift", RepositoryDrift: drift}, defaultRetryPolicy)
+}
+
+// Watch checks drift in the background at the configured interval so alerts fire
+// without anyone calling GET /drift. The returned function stops watching.
+func (m *DriftMonitor) Watch() (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			interval := defaultDriftCheckInterval
+			if cfg := m.config.Get().Drift; cfg != nil {
+				interval = cfg.checkInterval()
+			}
+
+			select {
+			case <-done:
+				return
+			case <-time.After(interval):
+			}
+
+			if _, err := m.Check(); err != nil {
+				log.Printf("Drift check failed: %v", err)
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// handleDrift serves GET /drift, comparing the versions of every repository across
+// the configured environments.
+func (s *Server) handleDrift(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use GET"})
+		return
+	}
+
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	report, err := s.drift.Check()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to check drift: %v", err)})
+		return
+	}
+	if report == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Drift detection is not configured"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	stagingEnvironmentID    = "550e8400-e29b-41d4-a716-446655440001"
+	productionEnvironmentID = "550e8400-e29b-41d4-a716-446655440002"
+)
+
+func driftFake(t *testing.T) (*fakeRailway, *RailwayClient) {
+	t.Helper()
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		if variables["environmentId"] == stagingEnvironmentID {
+			return environmentData(
+				[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1.4.0"},
+				[3]string{"svc-2", "worker", "ghcr.io/returnearly/worker:v2.0.0"},
+				[3]string{"svc-3", "preview", "ghcr.io/returnearly/preview:v0.1.0"},
+			), nil
+		}
+		return environmentData(
+			[3]string{"svc-4", "api", "ghcr.io/returnearly/api:v1.3.2"},
+			[3]string{"svc-5", "worker", "ghcr.io/returnearly/worker:v2.0.0"},
+		), nil
+	})
+}
+
+func driftConfig(t *testing.T, drift *DriftConfig) *Config {
+	t.Helper()
+	if drift.Environments == nil {
+		drift.Environments = []DriftEnvironment{
+			{Name: "staging", EnvironmentID: stagingEnvironmentID},
+			{Name: "production", EnvironmentID: productionEnvironmentID},
+		}
+	}
+	cfg := &Config{Drift: drift}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+	return cfg
+}
+
+func TestHandleDrift(t *testing.T) {
+	_, client := driftFake(t)
+	req := httptest.NewRequest(http.MethodGet, "/drift", nil)
+	w := httptest.NewRecorder()
+
+	newTestServer(client, driftConfig(t, &DriftConfig{})).handleDrift(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var report DriftReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// worker runs the same version everywhere and preview only runs in staging
+	if len(report.Drift) != 1 || report.Drift[0].Repository != "ghcr.io/returnearly/api" {
+		t.Fatalf("Expected only api to drift, got %+v", report.Drift)
+	}
+	versions := report.Drift[0].Versions
+	if len(versions) != 2 || versions[0].Version != "v1.4.0" || versions[1].Version != "v1.3.2" {
+		t.Errorf("Unexpected versions %+v", versions)
+	}
+	if report.Drift[0].Persistent {
+		t.Error("Expected drift not to be persistent without a threshold")
+	}
+}
+
+func TestHandleDrift_NotConfigured(t *testing.T) {
+	fake, client := driftFake(t)
+	req := httptest.NewRequest(http.MethodGet, "/drift", nil)
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).handleDrift(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestHandleDrift_Unauthorized(t *testing.T) {
+	fake, client := driftFake(t)
+	server := newTestServer(client, driftConfig(t, &DriftConfig{}))
+	server.apiKeys = APIKeys{"alice": "alice-key"}
+
+	paths := []string{"/drift", "/debug/vars"}
+	for _, path := range paths {
+		w := sendAuthorized(server.routes(), http.MethodGet, path, "wrong-key", nil)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusUnauthorized, path, w.Code)
+		}
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+
+	for _, path := range paths {
+		w := sendAuthorized(server.routes(), http.MethodGet, path, "alice-key", nil)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d for %s, got %d: %s", http.StatusOK, path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestDriftMonitor_Alert(t *testing.T) {
+	var mu sync.Mutex
+	var alerts []DriftAlert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert DriftAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		mu.Lock()
+		alerts = append(alerts, alert)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	_, client := driftFake(t)
+	cfg := driftConfig(t, &DriftConfig{ThresholdSeconds: 60, WebhookURL: webhook.URL})
+	monitor := NewDriftMonitor(client, NewConfigStore(cfg))
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	monitor.now = func() time.Time { return now }
+
+	for _, elapsed := range []time.Duration{0, 30 * time.Second, 2 * time.Minute, 5 * time.Minute} {
+		now = now.Add(elapsed)
+		report, err := monitor.Check()
+		if err != nil {
+			t.Fatalf("Check returned error: %v", err)
+		}
+		if expected := elapsed >= 2*time.Minute; report.Drift[0].Persistent != expected {
+			t.Errorf("After %s: expected persistent=%v", elapsed, expected)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(alerts) != 1 {
+		t.Fatalf("Expected exactly 1 alert, got %d", len(alerts))
+	}
+	if alerts[0].Event != "drift" || alerts[0].Repository != "ghcr.io/returnearly/api" {
+		t.Errorf("Unexpected alert %+v", alerts[0])
+	}
+}
+
+func TestDriftConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		drift DriftConfig
+	}{
+		{"single environment", DriftConfig{Environments: []DriftEnvironment{{EnvironmentID: stagingEnvironmentID}}}},
+		{"unknown target", DriftConfig{Environments: []DriftEnvironment{{Target: "missing"}, {EnvironmentID: stagingEnvironmentID}}}},
+		{"duplicate name", DriftConfig{Environments: []DriftEnvironment{
+			{Name: "x", EnvironmentID: stagingEnvironmentID},
+			{Name: "x", EnvironmentID: productionEnvironmentID},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Drift: &tt.drift}
+			if err := cfg.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
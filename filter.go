@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/google/uuid"
+)
+
+const (
+	MatchLogicOr  = "or"
+	MatchLogicAnd = "and"
+)
+
+// Service sources, see Service.Source.
+const (
+	SourceImage = "image"
+	SourceRepo  = "repo"
+)
+
+// ServiceFilter decides which services an update applies to.
+//
+// Image criteria select a service when its raw image starts with one of ImagePrefixes
+// or its parsed repository is selected by one of Matchers. Service criteria select a
+// service when its ID is listed in ServiceIDs or its name matches a glob in
+// ServiceNames. Logic combines the two groups: with "or" (the default) either group
+// may select a service, with "and" every group that was provided must select it.
+// ExcludeNames always wins.
+//
+// Sources restricts the filter to services deployed from the listed sources. It
+// defaults to image services only, so updates never touch services built from a
+// repository.
+//
+// Scope, when set, must also select the service. It holds the filters of the target
+// a request names, so the request's own filters can only narrow the target.
+type ServiceFilter struct {
+	ImagePrefixes []string
+	Matchers      []ImageMatcher
+	ServiceIDs    []string
+	ServiceNames  []string
+	ExcludeNames  []string
+	Logic         string
+	Sources       []string
+	Scope         *ServiceFilter
+}
+
+// Validate checks that the filter selects something and that all patterns are valid.
+// It also normalizes an empty Logic to "or".
+func (f *ServiceFilter) Validate() error {
+	if !f.hasImageCriteria() && !f.hasServiceCriteria() {
+		return fmt.Errorf("image_prefixes, matchers, service_ids or service_names must be provided")
+	}
+
+	switch f.Logic {
+	case "":
+		f.Logic = MatchLogicOr
+	case MatchLogicOr, MatchLogicAnd:
+	default:
+		return fmt.Errorf("invalid match_logic %q: must be %q or %q", f.Logic, MatchLogicOr, MatchLogicAnd)
+	}
+
+	if err := f.validateSources(); err != nil {
+		return err
+	}
+
+	for i := range f.Matchers {
+		if err := f.Matchers[i].Validate(); err != nil {
+			return fmt.Errorf("invalid matchers[%d]: %w", i, err)
+		}
+	}
+
+	for _, id := range f.ServiceIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return fmt.Errorf("invalid service_ids entry %q: must be a valid UUID", id)
+		}
+	}
+
+	for _, patterns := range [][]string{f.ServiceNames, f.ExcludeNames} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid service name glob %q: %w", pattern, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *ServiceFilter) validateSources() error {
+	for _, source := range f.Sources {
+		if source != SourceImage && source != SourceRepo {
+			return fmt.Errorf("invalid source %q: must be %q or %q", source, SourceImage, SourceRepo)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the service is selected by the filter.
+func (f *ServiceFilter) Matches(service Service) bool {
+	if !f.MatchesSource(service) || matchesAnyGlob(service.Name, f.ExcludeNames) {
+		return false
+	}
+	if f.Scope != nil && !f.Scope.Matches(service) {
+		return false
+	}
+
+	hasImage, hasService := f.hasImageCriteria(), f.hasServiceCriteria()
+	imageMatch := hasImage && f.MatchesImage(service.Image)
+	serviceMatch := hasService && f.matchesService(service)
+
+	if f.Logic == MatchLogicAnd {
+		return (!hasImage || imageMatch) && (!hasService || serviceMatch)
+	}
+	return imageMatch || serviceMatch
+}
+
+// MatchesSource reports whether the service is deployed from one of f.Sources, or
+// from an image if no sources are set.
+func (f *ServiceFilter) MatchesSource(service Service) bool {
+	if len(f.Sources) == 0 {
+		return service.Source() == SourceImage
+	}
+	for _, source := range f.Sources {
+		if service.Source() == source {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesImage reports whether the image is selected by the image criteria.
+func (f *ServiceFilter) MatchesImage(image string) bool {
+	if image == "" {
+		return false
+	}
+	if matchesPrefix(image, f.ImagePrefixes) {
+		return true
+	}
+
+	ref := parseImageRef(image)
+	for i := range f.Matchers {
+		if f.Matchers[i].matchesRef(ref) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *ServiceFilter) matchesService(service Service) bool {
+	for _, id := range f.ServiceIDs {
+		if service.ID == id {
+			return true
+		}
+	}
+	return matchesAnyGlob(service.Name, f.ServiceNames)
+}
+
+func (f *ServiceFilter) hasImageCriteria() bool {
+	return len(f.ImagePrefixes) > 0 || len(f.Matchers) > 0
+}
+
+func (f *ServiceFilter) hasServiceCriteria() bool {
+	return len(f.ServiceIDs) > 0 || len(f.ServiceNames) > 0
+}
+
+func matchesAnyGlob(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import "testing"
+
+func TestServiceFilter_Matches(t *testing.T) {
+	api := Service{ID: "11111111-1111-1111-1111-111111111111", Name: "api", Image: "ghcr.io/returnearly/api:v1"}
+	worker := Service{ID: "22222222-2222-2222-2222-222222222222", Name: "worker-email", Image: "ghcr.io/returnearly/worker:v1"}
+	legacy := Service{ID: "33333333-3333-3333-3333-333333333333", Name: "legacy", Image: "docker.io/legacy-app:v1"}
+	docs := Service{ID: "44444444-4444-4444-4444-444444444444", Name: "docs", Repo: "returnearly/docs", Branch: "main"}
+	pinned := Service{ID: "55555555-5555-5555-5555-555555555555", Name: "api-pinned", Image: "ghcr.io/returnearly/api@sha256:abc"}
+	apiLegacy := Service{ID: "66666666-6666-6666-6666-666666666666", Name: "api-legacy", Image: "ghcr.io/returnearly/api-legacy:v1"}
+
+	tests := []struct {
+		name     string
+		filter   ServiceFilter
+		expected []string
+	}{
+		{
+			name:     "image prefix",
+			filter:   ServiceFilter{ImagePrefixes: []string{"docker.io/legacy"}},
+			expected: []string{"legacy"},
+		},
+		{
+			name:     "repository matcher matches digests but not longer names",
+			filter:   ServiceFilter{Matchers: []ImageMatcher{{Repository: "ghcr.io/returnearly/api"}}},
+			expected: []string{"api", "api-pinned"},
+		},
+		{
+			name:     "image prefix matches the raw image",
+			filter:   ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api@"}},
+			expected: []string{"api-pinned"},
+		},
+		{
+			name:     "service id",
+			filter:   ServiceFilter{ServiceIDs: []string{worker.ID}},
+			expected: []string{"worker-email"},
+		},
+		{
+			name:     "service name glob",
+			filter:   ServiceFilter{ServiceNames: []string{"worker-*"}},
+			expected: []string{"worker-email"},
+		},
+		{
+			name: "or combines image and service criteria",
+			filter: ServiceFilter{
+				ImagePrefixes: []string{"docker.io/legacy"},
+				ServiceNames:  []string{"api"},
+			},
+			expected: []string{"api", "legacy"},
+		},
+		{
+			name: "and requires both image and service criteria",
+			filter: ServiceFilter{
+				ImagePrefixes: []string{"ghcr.io/returnearly/"},
+				ServiceNames:  []string{"worker-*"},
+				Logic:         MatchLogicAnd,
+			},
+			expected: []string{"worker-email"},
+		},
+		{
+			name: "exclusions win",
+			filter: ServiceFilter{
+				ImagePrefixes: []string{"ghcr.io/returnearly/"},
+				ExcludeNames:  []string{"worker-*"},
+			},
+			expected: []string{"api", "api-pinned", "api-legacy"},
+		},
+		{
+			name:     "repo services are skipped by default",
+			filter:   ServiceFilter{ServiceNames: []string{"*"}},
+			expected: []string{"api", "legacy", "worker-email", "api-pinned", "api-legacy"},
+		},
+		{
+			name:     "repo source",
+			filter:   ServiceFilter{ServiceNames: []string{"*"}, Sources: []string{SourceRepo}},
+			expected: []string{"docs"},
+		},
+		{
+			name: "image criteria never match repo services",
+			filter: ServiceFilter{
+				Matchers: []ImageMatcher{{Glob: "*"}},
+				Sources:  []string{SourceImage, SourceRepo},
+			},
+			expected: []string{"api", "legacy", "worker-email", "api-pinned", "api-legacy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); err != nil {
+				t.Fatalf("Validate() returned error: %v", err)
+			}
+
+			var matched []string
+			for _, service := range []Service{api, legacy, worker, docs, pinned, apiLegacy} {
+				if tt.filter.Matches(service) {
+					matched = append(matched, service.Name)
+				}
+			}
+
+			if len(matched) != len(tt.expected) {
+				t.Fatalf("Matched %v, expected %v", matched, tt.expected)
+			}
+			for i := range matched {
+				if matched[i] != tt.expected[i] {
+					t.Errorf("Matched %v, expected %v", matched, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceFilter_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ServiceFilter
+	}{
+		{"empty", ServiceFilter{}},
+		{"exclusions only", ServiceFilter{ExcludeNames: []string{"api"}}},
+		{"invalid logic", ServiceFilter{ServiceNames: []string{"api"}, Logic: "xor"}},
+		{"invalid service id", ServiceFilter{ServiceIDs: []string{"not-a-uuid"}}},
+		{"invalid name glob", ServiceFilter{ServiceNames: []string{"["}}},
+		{"invalid source", ServiceFilter{ServiceNames: []string{"api"}, Sources: []string{"dockerfile"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// freezeLookahead bounds the search for the end of a recurring freeze.
+const freezeLookahead = 8 * 24 * time.Hour
+
+// FreezeWindow blocks updates to some environments while it is active. A window is
+// either recurring, given by Cron, or a one-off date range from Start to End.
+type FreezeWindow struct {
+	Name           string   `json:"name"`
+	EnvironmentIDs []string `json:"environment_ids,omitempty"`
+	Targets        []string `json:"targets,omitempty"`
+
+	// Timezone is an IANA name such as "Europe/Berlin" (default UTC). Cron
+	// expressions and dates without an offset are interpreted in it.
+	Timezone string `json:"timezone,omitempty"`
+
+	// Cron is a five-field cron expression; every minute it matches is frozen,
+	// e.g. "* 15-23 * * 5" for Friday afternoons.
+	Cron string `json:"cron,omitempty"`
+
+	// Start and End bound a one-off freeze, as RFC 3339 timestamps or as
+	// "2006-01-02" or "2006-01-02T15:04" in Timezone. End is exclusive.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+
+	// OverrideBy lists the API key identities allowed to override the freeze.
+	// When empty, any identified caller may override it.
+	OverrideBy []string `json:"override_by,omitempty"`
+
+	location     *time.Location
+	schedule     *cronSchedule
+	start, end   time.Time
+	environments map[string]bool
+}
+
+// Validate parses the window's schedule and resolves its targets.
+func (f *FreezeWindow) Validate(cfg *Config) error {
+	if f.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+
+	location, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return fmt.Errorf("freeze %s: invalid timezone %q: %w", f.Name, f.Timezone, err)
+	}
+	f.location = location
+
+	hasCron, hasRange := f.Cron != "", f.Start != "" || f.End != ""
+	switch {
+	case hasCron == hasRange:
+		return fmt.Errorf("freeze %s: exactly one of cron or start/end must be set", f.Name)
+	case hasCron:
+		if f.schedule, err = parseCron(f.Cron); err != nil {
+			return fmt.Errorf("freeze %s: %w", f.Name, err)
+		}
+	default:
+		if f.start, err = parseFreezeTime(f.Start, location); err != nil {
+			return fmt.Errorf("freeze %s: invalid start: %w", f.Name, err)
+		}
+		if f.end, err = parseFreezeTime(f.End, location); err != nil {
+			return fmt.Errorf("freeze %s: invalid end: %w", f.Name, err)
+		}
+		if !f.end.After(f.start) {
+			return fmt.Errorf("freeze %s: end must be after start", f.Name)
+		}
+	}
+
+	f.environments = make(map[string]bool)
+	for _, environmentID := range f.EnvironmentIDs {
+		if _, err := uuid.Parse(environmentID); err != nil {
+			return fmt.Errorf("freeze %s: invalid environment_ids entry %q: must be a valid UUID", f.Name, environmentID)
+		}
+		f.environments[environmentID] = true
+	}
+	for _, name := range f.Targets {
+		target, ok := cfg.Target(name)
+		if !ok {
+			return fmt.Errorf("freeze %s: unknown target %s", f.Name, name)
+		}
+		f.environments[target.EnvironmentID] = true
+	}
+	if len(f.environments) == 0 {
+		return fmt.Errorf("freeze %s: environment_ids or targets must be provided", f.Name)
+	}
+
+	return nil
+}
+
+func parseFreezeTime(value string, location *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date or RFC 3339 timestamp", value)
+}
+
+// Active reports whether the window is in effect at t.
+func (f *FreezeWindow) Active(t time.Time) bool {
+	if f.schedule != nil {
+		return f.schedule.matches(t.In(f.location))
+	}
+	return !t.Before(f.start) && t.Before(f.end)
+}
+
+// Until returns when a window active at t ends. For recurring windows that stay
+// active beyond the lookahead it returns the zero time.
+func (f *FreezeWindow) Until(t time.Time) time.Time {
+	if f.schedule == nil {
+		return f.end
+	}
+	next := t.Truncate(time.Minute)
+	for limit := t.Add(freezeLookahead); next.Before(limit); next = next.Add(time.Minute) {
+		if !f.schedule.matches(next.In(f.location)) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// CanOverride reports whether identity may override the window.
+func (f *FreezeWindow) CanOverride(identity string) bool {
+	if identity == "" {
+		return false
+	}
+	if len(f.OverrideBy) == 0 {
+		return true
+	}
+	for _, allowed := range f.OverrideBy {
+		if allowed == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveFreeze returns the first freeze window covering environmentID at t.
+func (c *Config) ActiveFreeze(environmentID string, t time.Time) (*FreezeWindow, bool) {
+	for i := range c.Freezes {
+		freeze := &c.Freezes[i]
+		if freeze.environments[environmentID] && freeze.Active(t) {
+			return freeze, true
+		}
+	}
+	return nil, false
+}
+
+// FrozenError is returned when an update falls into a freeze window.
+type FrozenError struct {
+	Freeze        *FreezeWindow
+	EnvironmentID string
+	Until         time.Time
+}
+
+func (e *FrozenError) Error() string {
+	msg := fmt.Sprintf("environment %s is frozen by freeze window %s", e.EnvironmentID, e.Freeze.Name)
+	if !e.Until.IsZero() {
+		msg += " until " + e.Until.UTC().Format(time.RFC3339)
+	}
+	return msg
+}
+
+// checkFreezes returns a *FrozenError for the first of environmentIDs that is frozen at t.
+func (c *Config) checkFreezes(environmentIDs []string, t time.Time) error {
+	for _, environmentID := range environmentIDs {
+		if freeze, ok := c.ActiveFreeze(environmentID, t); ok {
+			return &FrozenError{Freeze: freeze, EnvironmentID: environmentID, Until: freeze.Until(t)}
+		}
+	}
+	return nil
+}
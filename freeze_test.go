@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const freezeEnvironmentID = "550e8400-e29b-41d4-a716-446655440009"
+
+func freezeConfig(t *testing.T, freezes ...FreezeWindow) *Config {
+	t.Helper()
+	cfg := &Config{Freezes: freezes}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+	return cfg
+}
+
+func TestFreezeWindow_Active(t *testing.T) {
+	cfg := freezeConfig(t,
+		FreezeWindow{
+			Name:           "friday-afternoon",
+			EnvironmentIDs: []string{freezeEnvironmentID},
+			Timezone:       "Europe/Berlin",
+			Cron:           "* 15-23 * * 5",
+		},
+		FreezeWindow{
+			Name:           "holidays",
+			EnvironmentIDs: []string{freezeEnvironmentID},
+			Timezone:       "Europe/Berlin",
+			Start:          "2024-12-23",
+			End:            "2025-01-02",
+		},
+	)
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected string
+		until    time.Time
+	}{
+		// 13:00 UTC is 15:00 in Berlin (CEST)
+		{"friday afternoon in Berlin", time.Date(2024, 5, 3, 13, 0, 0, 0, time.UTC), "friday-afternoon", time.Date(2024, 5, 3, 22, 0, 0, 0, time.UTC)},
+		{"friday lunchtime in Berlin", time.Date(2024, 5, 3, 12, 59, 0, 0, time.UTC), "", time.Time{}},
+		{"holidays", time.Date(2024, 12, 23, 0, 0, 0, 0, time.UTC), "holidays", time.Date(2025, 1, 1, 23, 0, 0, 0, time.UTC)},
+		{"after holidays", time.Date(2025, 1, 1, 23, 0, 0, 0, time.UTC), "", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			freeze, ok := cfg.ActiveFreeze(freezeEnvironmentID, tt.at)
+			if tt.expected == "" {
+				if ok {
+					t.Errorf("Expected no freeze, got %s", freeze.Name)
+				}
+				return
+			}
+			if !ok || freeze.Name != tt.expected {
+				t.Fatalf("Expected freeze %s, got %v", tt.expected, freeze)
+			}
+			if until := freeze.Until(tt.at); !until.Equal(tt.until) {
+				t.Errorf("Expected freeze until %s, got %s", tt.until, until)
+			}
+		})
+	}
+
+	if _, ok := cfg.ActiveFreeze("550e8400-e29b-41d4-a716-446655440001", time.Date(2024, 12, 24, 0, 0, 0, 0, time.UTC)); ok {
+		t.Error("Expected other environments not to be frozen")
+	}
+}
+
+func TestFreezeWindow_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		freeze FreezeWindow
+	}{
+		{"no schedule", FreezeWindow{Name: "x", EnvironmentIDs: []string{freezeEnvironmentID}}},
+		{"cron and range", FreezeWindow{Name: "x", EnvironmentIDs: []string{freezeEnvironmentID}, Cron: "* * * * *", Start: "2024-01-01", End: "2024-01-02"}},
+		{"end before start", FreezeWindow{Name: "x", EnvironmentIDs: []string{freezeEnvironmentID}, Start: "2024-01-02", End: "2024-01-01"}},
+		{"unknown timezone", FreezeWindow{Name: "x", EnvironmentIDs: []string{freezeEnvironmentID}, Cron: "* * * * *", Timezone: "Mars/Olympus"}},
+		{"no environments", FreezeWindow{Name: "x", Cron: "* * * * *"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Freezes: []FreezeWindow{tt.freeze}}
+			if err := cfg.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestHandleUpdate_Freeze(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiKey   string
+		reason   string
+		status   int
+		audited  bool
+		contains string
+	}{
+		{"no api key", "", "", http.StatusUnauthorized, false, "API key"},
+		{"refused", "release-key", "", http.StatusForbidden, false, "freeze_override_reason"},
+		{"override not allowed for identity", "ci-key", "hotfix", http.StatusForbidden, false, "may not override"},
+		{"authorized override", "release-key", "hotfix for INC-42", http.StatusOK, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+				switch operation {
+				case "Environment":
+					return environmentData([3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"}), nil
+				case "ServiceInstanceDeployV2":
+					return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+				}
+				return map[string]interface{}{}, nil
+			})
+
+			server := newTestServer(client, freezeConfig(t, FreezeWindow{
+				Name:           "always",
+				EnvironmentIDs: []string{freezeEnvironmentID},
+				Cron:           "* * * * *",
+				OverrideBy:     []string{"release-manager"},
+			}))
+			server.apiKeys = APIKeys{"release-manager": "release-key", "ci": "ci-key"}
+			var audit bytes.Buffer
+			server.audit = NewAuditLog(&audit)
+
+			body, _ := json.Marshal(UpdateRequest{
+				ProjectID:            "550e8400-e29b-41d4-a716-446655440000",
+				EnvironmentID:        freezeEnvironmentID,
+				ImagePrefixes:        []string{"ghcr.io/returnearly/api"},
+				NewVersion:           "v2",
+				FreezeOverrideReason: tt.reason,
+			})
+			req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(body))
+			if tt.apiKey != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+
+			server.handleUpdate(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("Expected response to contain %q, got %s", tt.contains, w.Body.String())
+			}
+			if updated := len(fake.operations()) > 0; updated != (tt.status == http.StatusOK) {
+				t.Errorf("Unexpected Railway calls %v", fake.operations())
+			}
+
+			if !tt.audited {
+				if audit.Len() != 0 {
+					t.Errorf("Expected no audit entry, got %s", audit.String())
+				}
+				return
+			}
+			var entry AuditEntry
+			if err := json.Unmarshal(audit.Bytes(), &entry); err != nil {
+				t.Fatalf("Failed to decode audit entry: %v", err)
+			}
+			if entry.Action != AuditFreezeOverride || entry.Identity != "release-manager" || entry.Reason != "hotfix for INC-42" || entry.Detail != "always" {
+				t.Errorf("Unexpected audit entry %+v", entry)
+			}
+		})
+	}
+}
+
+func TestTriggerRoutes_Frozen(t *testing.T) {
+	fake, client := registryFake(t)
+	routes := testRoutes(t)
+	cfg := freezeConfig(t, FreezeWindow{
+		Name:           "always",
+		EnvironmentIDs: []string{routes[0].EnvironmentID},
+		Cron:           "* * * * *",
+	})
+	cfg.Routes = routes
+
+	results := triggerRoutes(client, cfg, []string{"ghcr.io/returnearly/api"}, "v1.2.3")
+
+	if len(results) != 1 || results[0].Error != "" || !strings.Contains(results[0].Skipped, "frozen") {
+		t.Fatalf("Expected the route to be refused, got %+v", results)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
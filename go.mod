@@ -2,4 +2,10 @@ module github.com/returnearly/railway-image-updater
 
 go 1.24.9
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	github.com/vektah/gqlparser/v2 v2.5.31
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/agnivade/levenshtein v1.2.1 // indirect
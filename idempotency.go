@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultIdempotencyRetention is how long a response is replayed for a key.
+	defaultIdempotencyRetention = 24 * time.Hour
+
+	// defaultIdempotencyMaxKeys bounds the number of remembered keys. The oldest
+	// key is forgotten first.
+	defaultIdempotencyMaxKeys = 1000
+)
+
+// idempotencyState is the outcome of IdempotencyStore.Begin.
+type idempotencyState int
+
+const (
+	// idempotencyNew means the key was unknown and the caller must run the request
+	// and call Complete or Release.
+	idempotencyNew idempotencyState = iota
+	// idempotencyReplay means the same request already finished; its response is returned.
+	idempotencyReplay
+	// idempotencyInProgress means the same request is still running.
+	idempotencyInProgress
+	// idempotencyMismatch means the key was used for a different request.
+	idempotencyMismatch
+)
+
+type idempotencyEntry struct {
+	key         string
+	fingerprint string
+	createdAt   time.Time
+	completed   bool
+	status      int
+	body        []byte
+	element     *list.Element
+}
+
+// IdempotencyStore remembers the responses to requests sent with an Idempotency-Key
+// header for a retention window, holding at most maxKeys keys.
+type IdempotencyStore struct {
+	retention time.Duration
+	maxKeys   int
+	now       func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	order   *list.List // oldest first
+}
+
+func NewIdempotencyStore(retention time.Duration, maxKeys int) *IdempotencyStore {
+	return &IdempotencyStore{
+		retention: retention,
+		maxKeys:   maxKeys,
+		now:       time.Now,
+		entries:   make(map[string]*idempotencyEntry),
+		order:     list.New(),
+	}
+}
+
+// requestFingerprint hashes a JSON body after normalizing it, so retries that only
+// reorder keys or change whitespace count as the same payload.
+func requestFingerprint(body []byte) string {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err == nil {
+		if normalized, err := json.Marshal(value); err == nil {
+			body = normalized
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Begin claims key for a request with the given fingerprint. For a replay it also
+// returns the stored status and body.
+func (s *IdempotencyStore) Begin(key, fingerprint string) (idempotencyState, int, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict()
+
+	if entry, ok := s.entries[key]; ok {
+		switch {
+		case entry.fingerprint != fingerprint:
+			return idempotencyMismatch, 0, nil
+		case !entry.completed:
+			return idempotencyInProgress, 0, nil
+		default:
+			return idempotencyReplay, entry.status, entry.body
+		}
+	}
+
+	entry := &idempotencyEntry{key: key, fingerprint: fingerprint, createdAt: s.now()}
+	entry.element = s.order.PushBack(entry)
+	s.entries[key] = entry
+	s.evict()
+	return idempotencyNew, 0, nil
+}
+
+// Release forgets a key claimed with Begin without storing a response, so the
+// request can be retried under the same key.
+func (s *IdempotencyStore) Release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok && !entry.completed {
+		s.order.Remove(entry.element)
+		delete(s.entries, key)
+	}
+}
+
+// Complete stores the response for a key claimed with Begin.
+func (s *IdempotencyStore) Complete(key string, status int, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		entry.completed = true
+		entry.status = status
+		entry.body = body
+	}
+}
+
+// evict forgets expired keys and the oldest keys beyond maxKeys. The caller must
+// hold s.mu.
+func (s *IdempotencyStore) evict() {
+	cutoff := s.now().Add(-s.retention)
+	for front := s.order.Front(); front != nil; front = s.order.Front() {
+		entry := front.Value.(*idempotencyEntry)
+		if s.order.Len() <= s.maxKeys && entry.createdAt.After(cutoff) {
+			return
+		}
+		s.order.Remove(front)
+		delete(s.entries, entry.key)
+	}
+}
+
+// responseCapture passes a response through while keeping a copy of it.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(data []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.body.Write(data)
+	return c.ResponseWriter.Write(data)
+}
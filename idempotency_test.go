@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleUpdate_IdempotencyKey(t *testing.T) {
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData([3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"}), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+	server := newTestServer(client, nil)
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/update", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "ci-run-42")
+		w := httptest.NewRecorder()
+		server.handleUpdate(w, req)
+		return w
+	}
+
+	first := send(`{"project_id":"550e8400-e29b-41d4-a716-446655440000","environment_id":"550e8400-e29b-41d4-a716-446655440001","image_prefixes":["ghcr.io/returnearly/api"],"new_version":"v2"}`)
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, first.Code, first.Body.String())
+	}
+
+	// The same payload with reordered keys is replayed without touching Railway
+	retry := send(`{"new_version": "v2", "image_prefixes": ["ghcr.io/returnearly/api"],
+		"environment_id": "550e8400-e29b-41d4-a716-446655440001", "project_id": "550e8400-e29b-41d4-a716-446655440000"}`)
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected replayed response %q, got %d %q", first.Body.String(), retry.Code, retry.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Expected Idempotent-Replayed header")
+	}
+
+	deploys := 0
+	for _, op := range fake.operations() {
+		if op == "ServiceInstanceDeployV2" {
+			deploys++
+		}
+	}
+	if deploys != 1 {
+		t.Errorf("Expected 1 deployment, got %d", deploys)
+	}
+
+	// A different payload under the same key is rejected
+	changed := send(`{"project_id":"550e8400-e29b-41d4-a716-446655440000","environment_id":"550e8400-e29b-41d4-a716-446655440001","image_prefixes":["ghcr.io/returnearly/api"],"new_version":"v3"}`)
+	if changed.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, changed.Code)
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	store := NewIdempotencyStore(time.Hour, 2)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	if state, _, _ := store.Begin("a", "payload-a"); state != idempotencyNew {
+		t.Fatalf("Expected new key, got %v", state)
+	}
+	if state, _, _ := store.Begin("a", "payload-a"); state != idempotencyInProgress {
+		t.Errorf("Expected in-progress key, got %v", state)
+	}
+
+	store.Complete("a", http.StatusOK, []byte("done"))
+	state, status, body := store.Begin("a", "payload-a")
+	if state != idempotencyReplay || status != http.StatusOK || string(body) != "done" {
+		t.Errorf("Expected replay of 200 done, got %v %d %q", state, status, body)
+	}
+
+	// Keys beyond the bound push out the oldest
+	store.Begin("b", "payload-b")
+	store.Begin("c", "payload-c")
+	if state, _, _ := store.Begin("a", "payload-a"); state != idempotencyNew {
+		t.Errorf("Expected oldest key to be evicted, got %v", state)
+	}
+
+	// Keys are forgotten after the retention window
+	store.Complete("c", http.StatusOK, nil)
+	now = now.Add(2 * time.Hour)
+	if state, _, _ := store.Begin("c", "other-payload"); state != idempotencyNew {
+		t.Errorf("Expected expired key to be reusable, got %v", state)
+	}
+
+	// Released keys can be claimed again
+	store.Begin("d", "payload-d")
+	store.Release("d")
+	if state, _, _ := store.Begin("d", "payload-d"); state != idempotencyNew {
+		t.Errorf("Expected released key to be new, got %v", state)
+	}
+}
+
+func TestHandleUpdate_IdempotencyKeyRetriesErrors(t *testing.T) {
+	failing := true
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			if failing {
+				return nil, &GraphQLErrors{Errors: []GraphQLError{{Message: "Problem processing request"}}}
+			}
+			return environmentData([3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"}), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+	server := newTestServer(client, nil)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/update", strings.NewReader(`{"project_id":"550e8400-e29b-41d4-a716-446655440000","environment_id":"550e8400-e29b-41d4-a716-446655440001","image_prefixes":["ghcr.io/returnearly/api"],"new_version":"v2"}`))
+		req.Header.Set("Idempotency-Key", "ci-run-43")
+		w := httptest.NewRecorder()
+		server.handleUpdate(w, req)
+		return w
+	}
+
+	if first := send(); first.Code != http.StatusBadGateway {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusBadGateway, first.Code, first.Body.String())
+	}
+
+	// The retry after a transient failure runs again instead of replaying the error
+	failing = false
+	retry := send()
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("Expected the retry to run, got %d %q", retry.Code, retry.Body.String())
+	}
+	if ops := strings.Join(fake.operations(), ","); !strings.HasSuffix(ops, "ServiceInstanceDeployV2") {
+		t.Errorf("Expected the retry to deploy, got %s", ops)
+	}
+
+	if replay := send(); replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Expected the successful response to be replayed")
+	}
+}
+
+func TestHandleUpdate_BodyTooLarge(t *testing.T) {
+	client := NewRailwayClient("test-token", "", "")
+	body := `{"new_version":"` + strings.Repeat("v", maxUpdateBodySize) + `"}`
+	req := httptest.NewRequest(http.MethodPut, "/update", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).handleUpdate(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// ServiceInventory describes what a service in an environment is currently running.
+type ServiceInventory struct {
+	ServiceID              string `json:"service_id"`
+	ServiceName            string `json:"service_name"`
+	Source                 string `json:"source"`
+	Image                  string `json:"image"`
+	Repository             string `json:"repository"`
+	Tag                    string `json:"tag,omitempty"`
+	Digest                 string `json:"digest,omitempty"`
+	Repo                   string `json:"repo,omitempty"`
+	Branch                 string `json:"branch,omitempty"`
+	Commit                 string `json:"commit,omitempty"`
+	NumReplicas            int    `json:"num_replicas"`
+	LatestDeploymentID     string `json:"latest_deployment_id,omitempty"`
+	LatestDeploymentStatus string `json:"latest_deployment_status,omitempty"`
+}
+
+type ServicesResponse struct {
+	EnvironmentID string             `json:"environment_id"`
+	Services      []ServiceInventory `json:"services"`
+}
+
+// newServiceInventory parses the service's image into repository, tag and digest.
+// Repo services report the repo and branch they track instead.
+func newServiceInventory(service Service) ServiceInventory {
+	inventory := ServiceInventory{
+		ServiceID:              service.ID,
+		ServiceName:            service.Name,
+		Source:                 service.Source(),
+		Image:                  service.Image,
+		Repo:                   service.Repo,
+		Branch:                 service.Branch,
+		Commit:                 service.Commit,
+		NumReplicas:            service.NumReplicas,
+		LatestDeploymentID:     service.LatestDeploymentID,
+		LatestDeploymentStatus: service.LatestDeploymentStatus,
+	}
+	if service.Image != "" {
+		ref := parseImageRef(service.Image)
+		inventory.Repository, inventory.Tag, inventory.Digest = ref.Repository, ref.Tag, ref.Digest
+	}
+	return inventory
+}
+
+// listingSelector validates a filter for listing services and returns whether a
+// service is listed. Unlike updates, listings include repo services: without
+// sources both image and repo services are listed, and without image or service
+// criteria every service of those sources is.
+func listingSelector(filter ServiceFilter) (func(Service) bool, error) {
+	if len(filter.Sources) == 0 {
+		filter.Sources = []string{SourceImage, SourceRepo}
+	}
+	if !filter.hasImageCriteria() && !filter.hasServiceCriteria() {
+		if err := filter.validateSources(); err != nil {
+			return nil, err
+		}
+		if filter.Scope != nil {
+			return func(service Service) bool {
+				return filter.MatchesSource(service) && filter.Scope.Matches(service)
+			}, nil
+		}
+		return filter.MatchesSource, nil
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, err
+	}
+	return filter.Matches, nil
+}
+
+// filterFromQuery builds a service filter from query parameters named like the
+// /update fields. Matchers are given as repeated repository, glob and regex
+// parameters.
+func filterFromQuery(query url.Values) ServiceFilter {
+	filter := ServiceFilter{
+		ImagePrefixes: query["image_prefix"],
+		ServiceIDs:    query["service_id"],
+		ServiceNames:  query["service_name"],
+		ExcludeNames:  query["exclude_service_name"],
+		Logic:         query.Get("match_logic"),
+		Sources:       query["source"],
+	}
+	for _, repository := range query["repository"] {
+		filter.Matchers = append(filter.Matchers, ImageMatcher{Repository: repository})
+	}
+	for _, glob := range query["glob"] {
+		filter.Matchers = append(filter.Matchers, ImageMatcher{Glob: glob})
+	}
+	for _, regex := range query["regex"] {
+		filter.Matchers = append(filter.Matchers, ImageMatcher{Regex: regex})
+	}
+	return filter
+}
+
+// handleListServices serves GET /environments/{id}/services, listing the image and
+// repo services of an environment. Without filter parameters every service is listed.
+func (s *Server) handleListServices(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use GET"})
+		return
+	}
+
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	environmentID := r.PathValue("id")
+	if _, err := uuid.Parse(environmentID); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid environment_id: must be a valid UUID"})
+		return
+	}
+
+	listed, err := listingSelector(filterFromQuery(r.URL.Query()))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	services, err := s.client.GetServices(environmentID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to get services: %v", err)})
+		return
+	}
+
+	inventory := make([]ServiceInventory, 0, len(services))
+	for _, service := range services {
+		if listed(service) {
+			inventory = append(inventory, newServiceInventory(service))
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ServicesResponse{
+		EnvironmentID: environmentID,
+		Services:      inventory,
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// inventoryData is an environment with two image services, api and worker, and a
+// docs service deployed from the main branch of a repo.
+func inventoryData() map[string]interface{} {
+	data := environmentData(
+		[3]string{"svc-1", "api", "registry.example.com:5000/returnearly/api:v1.4.0"},
+		[3]string{"svc-2", "worker", "ghcr.io/returnearly/worker@sha256:abc"},
+	)
+	edges := data["environment"].(map[string]interface{})["serviceInstances"].(map[string]interface{})["edges"].([]interface{})
+	edges[0].(map[string]interface{})["node"].(map[string]interface{})["latestDeployment"] = map[string]interface{}{
+		"id":     "deploy-1",
+		"status": "SUCCESS",
+		"meta":   `{"serviceManifest":{"deploy":{"multiRegionConfig":{"us-west2":{"numReplicas":3}}}}}`,
+	}
+	edges = append(edges, map[string]interface{}{
+		"node": map[string]interface{}{
+			"serviceId":        "svc-3",
+			"serviceName":      "docs",
+			"source":           map[string]interface{}{"repo": "returnearly/docs"},
+			"latestDeployment": map[string]interface{}{"id": "deploy-3", "status": "SUCCESS", "meta": map[string]interface{}{"branch": "main"}},
+		},
+	})
+	data["environment"].(map[string]interface{})["serviceInstances"].(map[string]interface{})["edges"] = edges
+	return data
+}
+
+func inventoryFake(t *testing.T) (*fakeRailway, *RailwayClient) {
+	t.Helper()
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		return inventoryData(), nil
+	})
+}
+
+func TestHandleListServices(t *testing.T) {
+	_, client := inventoryFake(t)
+	req := httptest.NewRequest(http.MethodGet, "/environments/550e8400-e29b-41d4-a716-446655440001/services", nil)
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).routes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp ServicesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Services) != 3 {
+		t.Fatalf("Expected 3 services, got %d", len(resp.Services))
+	}
+
+	api := resp.Services[0]
+	if api.Repository != "registry.example.com:5000/returnearly/api" || api.Tag != "v1.4.0" {
+		t.Errorf("Unexpected repository/tag %s:%s", api.Repository, api.Tag)
+	}
+	if api.NumReplicas != 3 || api.LatestDeploymentStatus != "SUCCESS" {
+		t.Errorf("Expected 3 replicas and SUCCESS, got %d and %q", api.NumReplicas, api.LatestDeploymentStatus)
+	}
+
+	worker := resp.Services[1]
+	if worker.Digest != "sha256:abc" || worker.Tag != "" || worker.NumReplicas != 1 {
+		t.Errorf("Unexpected worker inventory %+v", worker)
+	}
+
+	docs := resp.Services[2]
+	if docs.Source != SourceRepo || docs.Repo != "returnearly/docs" || docs.Branch != "main" || docs.Image != "" || docs.Repository != "" {
+		t.Errorf("Unexpected docs inventory %+v", docs)
+	}
+	if api.Source != SourceImage || api.Repo != "" {
+		t.Errorf("Expected api to be an image service, got %+v", api)
+	}
+}
+
+func TestHandleListServices_Filters(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+		status   int
+	}{
+		{"glob", "?glob=worker", []string{"worker"}, http.StatusOK},
+		{"repository", "?repository=registry.example.com:5000/returnearly/api", []string{"api"}, http.StatusOK},
+		{"service name and exclude", "?service_name=*&exclude_service_name=api", []string{"worker", "docs"}, http.StatusOK},
+		{"repo source", "?source=repo", []string{"docs"}, http.StatusOK},
+		{"image source and service name", "?source=image&service_name=*", []string{"api", "worker"}, http.StatusOK},
+		{"invalid source", "?source=dockerfile", nil, http.StatusBadRequest},
+		{"invalid regex", "?regex=(", nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := inventoryFake(t)
+			req := httptest.NewRequest(http.MethodGet, "/environments/550e8400-e29b-41d4-a716-446655440001/services"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			newTestServer(client, nil).routes().ServeHTTP(w, req)
+
+			if w.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp ServicesResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			names := make([]string, 0, len(resp.Services))
+			for _, service := range resp.Services {
+				names = append(names, service.ServiceName)
+			}
+			if len(names) != len(tt.expected) || (len(names) > 0 && names[0] != tt.expected[0]) {
+				t.Errorf("Expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestHandleListServices_Unauthorized(t *testing.T) {
+	fake, client := inventoryFake(t)
+	server := newTestServer(client, nil)
+	server.apiKeys = APIKeys{"alice": "alice-key"}
+
+	w := sendAuthorized(server.routes(), http.MethodGet, "/environments/550e8400-e29b-41d4-a716-446655440001/services", "wrong-key", nil)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+
+	w = sendAuthorized(server.routes(), http.MethodGet, "/environments/550e8400-e29b-41d4-a716-446655440001/services", "alice-key", nil)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestHandleListServices_InvalidEnvironment(t *testing.T) {
+	fake, client := inventoryFake(t)
+	req := httptest.NewRequest(http.MethodGet, "/environments/not-a-uuid/services", nil)
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).routes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// defaultLockPollInterval is how often a queued update retries a held lock.
+const defaultLockPollInterval = 250 * time.Millisecond
+
+// LockHolder describes who holds the update lock of an environment.
+type LockHolder struct {
+	EnvironmentID string    `json:"environment_id"`
+	Owner         string    `json:"owner"`
+	Version       string    `json:"version,omitempty"`
+	AcquiredAt    time.Time `json:"acquired_at"`
+
+	// Token identifies this acquisition so only the holder can release it.
+	Token string `json:"-"`
+}
+
+// Locker stores environment locks. The default MemoryLocker serializes updates
+// within one process; running several replicas of the updater needs a shared
+// implementation, e.g. backed by Redis or a database. Shared implementations should
+// expire locks whose holder has died.
+type Locker interface {
+	// TryLock acquires the lock of holder.EnvironmentID if it is free. Otherwise it
+	// returns the current holder and false.
+	TryLock(holder LockHolder) (LockHolder, bool, error)
+
+	// Unlock releases the lock if it is still held by holder.
+	Unlock(holder LockHolder) error
+
+	// Holders returns every held lock.
+	Holders() ([]LockHolder, error)
+}
+
+// MemoryLocker is an in-process Locker.
+type MemoryLocker struct {
+	mu      sync.Mutex
+	holders map[string]LockHolder
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{holders: make(map[string]LockHolder)}
+}
+
+func (l *MemoryLocker) TryLock(holder LockHolder) (LockHolder, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.holders[holder.EnvironmentID]; ok {
+		return current, false, nil
+	}
+	l.holders[holder.EnvironmentID] = holder
+	return holder, true, nil
+}
+
+func (l *MemoryLocker) Unlock(holder LockHolder) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.holders[holder.EnvironmentID]; ok && current.Token == holder.Token {
+		delete(l.holders, holder.EnvironmentID)
+	}
+	return nil
+}
+
+func (l *MemoryLocker) Holders() ([]LockHolder, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	holders := make([]LockHolder, 0, len(l.holders))
+	for _, holder := range l.holders {
+		holders = append(holders, holder)
+	}
+	return holders, nil
+}
+
+// LockedError is returned when an environment is locked by another update.
+type LockedError struct {
+	Holder LockHolder
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("environment %s is locked by %s since %s", e.Holder.EnvironmentID, e.Holder.Owner, e.Holder.AcquiredAt.Format(time.RFC3339))
+}
+
+// EnvironmentLocks serializes updates per environment on top of a Locker.
+type EnvironmentLocks struct {
+	locker       Locker
+	pollInterval time.Duration
+}
+
+func NewEnvironmentLocks(locker Locker) *EnvironmentLocks {
+	return &EnvironmentLocks{locker: locker, pollInterval: defaultLockPollInterval}
+}
+
+// Acquire locks environmentID for owner. If the lock is held it retries for up to
+// wait, then returns a *LockedError. The returned function releases the lock.
+func (l *EnvironmentLocks) Acquire(environmentID, owner, version string, wait time.Duration) (release func(), err error) {
+	holder := LockHolder{
+		EnvironmentID: environmentID,
+		Owner:         owner,
+		Version:       version,
+		Token:         uuid.NewString(),
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		holder.AcquiredAt = time.Now().UTC()
+		current, ok, err := l.locker.TryLock(holder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock environment %s: %w", environmentID, err)
+		}
+		if ok {
+			return func() {
+				if err := l.locker.Unlock(holder); err != nil {
+					log.Printf("Failed to unlock environment %s: %v", environmentID, err)
+				}
+			}, nil
+		}
+		if !time.Now().Before(deadline) {
+			return nil, &LockedError{Holder: current}
+		}
+		time.Sleep(l.pollInterval)
+	}
+}
+
+type LocksResponse struct {
+	Locks []LockHolder `json:"locks"`
+}
+
+// handleLocks serves GET /locks, listing the environments currently being updated.
+func (s *Server) handleLocks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use GET"})
+		return
+	}
+
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	holders, err := s.client.locks.locker.Holders()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to list locks: %v", err)})
+		return
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		return holders[i].AcquiredAt.Before(holders[j].AcquiredAt)
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LocksResponse{Locks: holders})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEnvironmentLocks(t *testing.T) {
+	locks := NewEnvironmentLocks(NewMemoryLocker())
+	locks.pollInterval = time.Millisecond
+
+	release, err := locks.Acquire("env-1", "pipeline-a", "v1", 0)
+	if err != nil {
+		t.Fatalf("Acquire returned error: %v", err)
+	}
+
+	// Another environment is independent
+	releaseOther, err := locks.Acquire("env-2", "pipeline-b", "v1", 0)
+	if err != nil {
+		t.Fatalf("Expected env-2 to be free, got %v", err)
+	}
+	releaseOther()
+
+	_, err = locks.Acquire("env-1", "pipeline-b", "v2", 0)
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) || lockedErr.Holder.Owner != "pipeline-a" {
+		t.Fatalf("Expected LockedError held by pipeline-a, got %v", err)
+	}
+
+	// A queued acquire succeeds once the holder releases
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		release()
+	}()
+	releaseQueued, err := locks.Acquire("env-1", "pipeline-b", "v2", time.Second)
+	if err != nil {
+		t.Fatalf("Expected queued acquire to succeed, got %v", err)
+	}
+
+	// A stale release from the previous holder does not free the new holder's lock
+	release()
+	if _, err := locks.Acquire("env-1", "pipeline-c", "v3", 0); err == nil {
+		t.Error("Expected env-1 to still be locked")
+	}
+	releaseQueued()
+}
+
+func TestHandleUpdate_EnvironmentLocked(t *testing.T) {
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		return map[string]interface{}{}, nil
+	})
+	server := newTestServer(client, nil)
+
+	release, err := client.locks.Acquire("550e8400-e29b-41d4-a716-446655440001", "pipeline-a", "v1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	body, _ := json.Marshal(UpdateRequest{
+		ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+		ImagePrefixes: []string{"ghcr.io/returnearly/api"},
+		NewVersion:    "v2",
+	})
+	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	server.handleUpdate(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusConflict, w.Code, w.Body.String())
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+
+	// The holder is visible through /locks
+	req = httptest.NewRequest(http.MethodGet, "/locks", nil)
+	w = httptest.NewRecorder()
+	server.handleLocks(w, req)
+
+	var resp LocksResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Locks) != 1 || resp.Locks[0].Owner != "pipeline-a" || resp.Locks[0].Version != "v1" {
+		t.Errorf("Unexpected locks %+v", resp.Locks)
+	}
+}
+
+func TestHandleLocks_Unauthorized(t *testing.T) {
+	_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		return map[string]interface{}{}, nil
+	})
+	server := newTestServer(client, nil)
+	server.apiKeys = APIKeys{"alice": "alice-key"}
+
+	w := sendAuthorized(server.routes(), http.MethodGet, "/locks", "wrong-key", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w = sendAuthorized(server.routes(), http.MethodGet, "/locks", "alice-key", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
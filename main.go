@@ -2,50 +2,150 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// configReloadInterval is how often CONFIG_FILE is checked for changes.
+const configReloadInterval = 10 * time.Second
+
 type UpdateRequest struct {
-	ProjectID     string   `json:"project_id"`
-	EnvironmentID string   `json:"environment_id"`
-	ImagePrefixes []string `json:"image_prefixes"`
-	NewVersion    string   `json:"new_version"`
+	// Target names a configured target that supplies the project, environment,
+	// filters and policy, so callers only need to send the new version.
+	Target string `json:"target,omitempty"`
+
+	ProjectID           string         `json:"project_id"`
+	EnvironmentID       string         `json:"environment_id"`
+	ImagePrefixes       []string       `json:"image_prefixes"`
+	Matchers            []ImageMatcher `json:"matchers,omitempty"`
+	ServiceIDs          []string       `json:"service_ids,omitempty"`
+	ServiceNames        []string       `json:"service_names,omitempty"`
+	ExcludeServiceNames []string       `json:"exclude_service_names,omitempty"`
+	MatchLogic          string         `json:"match_logic,omitempty"`
+	NewVersion          string         `json:"new_version"`
+
+	// EnvironmentIDs promotes through several environments in order instead of
+	// updating EnvironmentID alone. Gate decides whether each stage must finish
+	// deploying successfully before the next one starts.
+	EnvironmentIDs     []string `json:"environment_ids,omitempty"`
+	Gate               string   `json:"gate,omitempty"`
+	GateTimeoutSeconds int      `json:"gate_timeout_seconds,omitempty"`
+
+	// Rollout updates matched services in canary batches instead of all at once.
+	Rollout *RolloutStrategy `json:"rollout,omitempty"`
+
+	// Variables are upserted or deleted before the matched services are deployed.
+	Variables *VariableChanges `json:"variables,omitempty"`
+
+	// Force redeploys services that already run the requested image.
+	Force bool `json:"force,omitempty"`
+
+	// FreezeOverrideReason overrides active freeze windows. It is only honoured
+	// for API key identities allowed to override the freeze and is audited.
+	FreezeOverrideReason string `json:"freeze_override_reason,omitempty"`
+
+	// ApplyAt schedules the update instead of applying it right away.
+	ApplyAt *time.Time `json:"apply_at,omitempty"`
+
+	// MaxDelaySeconds bounds how late after ApplyAt a scheduled update may still
+	// start, e.g. after downtime. A later update is missed instead of applied.
+	MaxDelaySeconds int `json:"max_delay_seconds,omitempty"`
+
+	// LockWaitSeconds queues the update for up to this long while another update
+	// of the environment is running. Without it the update is rejected with 409.
+	LockWaitSeconds int `json:"lock_wait_seconds,omitempty"`
+
+	// scope is the filter of the request's target, set when the target is applied.
+	scope *ServiceFilter
+}
+
+// redacted returns a copy of the request without secrets, for responses and listings.
+func (r UpdateRequest) redacted() UpdateRequest {
+	if r.Variables != nil {
+		r.Variables = r.Variables.redacted()
+	}
+	return r
 }
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error              string           `json:"error"`
+	UpdatedServices    []string         `json:"updated_services,omitempty"`
+	UnchangedServices  []string         `json:"unchanged_services,omitempty"`
+	FailedServices     []ServiceFailure `json:"failed_services,omitempty"`
+	RolledBackServices []string         `json:"rolled_back_services,omitempty"`
+	RailwayErrors      []GraphQLError   `json:"railway_errors,omitempty"`
 }
 
 type SuccessResponse struct {
-	Message         string   `json:"message"`
-	UpdatedServices []string `json:"updated_services"`
+	Message           string           `json:"message"`
+	UpdatedServices   []string         `json:"updated_services"`
+	UnchangedServices []string         `json:"unchanged_services,omitempty"`
+	FailedServices    []ServiceFailure `json:"failed_services,omitempty"`
+	MatchLogic        string           `json:"match_logic,omitempty"`
+}
+
+type PromotionResponse struct {
+	Message      string              `json:"message,omitempty"`
+	Error        string              `json:"error,omitempty"`
+	Environments []EnvironmentResult `json:"environments"`
+	MatchLogic   string              `json:"match_logic,omitempty"`
 }
 
 func main() {
-	token := os.Getenv("RAILWAY_API_TOKEN")
-	if token == "" {
-		log.Fatal("RAILWAY_API_TOKEN environment variable is required")
+	os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// serve runs the HTTP server until it fails.
+func serve(client *RailwayClient) error {
+	config := NewConfigStore(&Config{})
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		loaded, err := LoadConfigStore(path, client.ValidateConfig)
+		if err != nil {
+			return err
+		}
+		config = loaded
+		config.Watch(configReloadInterval)
+		cfg := config.Get()
+		log.Printf("Loaded %d target(s), %d route(s) and %d watcher(s) from %s", len(cfg.Targets), len(cfg.Routes), len(cfg.Watchers), path)
 	}
 
-	registryUser := os.Getenv("RAILWAY_DOCKER_REGISTRY_USER")
-	registryPass := os.Getenv("RAILWAY_DOCKER_REGISTRY_TOKEN")
+	server := NewServer(client, config, WebhookSecrets{
+		GitHub:       os.Getenv("GITHUB_WEBHOOK_SECRET"),
+		DockerHub:    os.Getenv("DOCKERHUB_WEBHOOK_TOKEN"),
+		Distribution: os.Getenv("DISTRIBUTION_WEBHOOK_TOKEN"),
+	})
 
-	client := NewRailwayClient(token, registryUser, registryPass)
+	apiKeys, err := parseAPIKeys(os.Getenv("API_KEYS"))
+	if err != nil {
+		return fmt.Errorf("invalid API_KEYS: %w", err)
+	}
+	server.apiKeys = apiKeys
 
-	http.HandleFunc("/update", func(w http.ResponseWriter, r *http.Request) {
-		handleUpdate(w, r, client)
-	})
+	audit, err := openAuditLog(os.Getenv("AUDIT_LOG_FILE"), nil)
+	if err != nil {
+		return err
+	}
+	server.audit = audit
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-	})
+	scheduler, err := LoadScheduler(os.Getenv("SCHEDULE_FILE"))
+	if err != nil {
+		return err
+	}
+	if pending := len(scheduler.List()); pending > 0 {
+		log.Printf("Loaded %d scheduled update(s)", pending)
+	}
+	server.scheduler = scheduler
+
+	server.drift.Watch()
+	server.watchers.Watch()
+	server.watchSchedule(scheduleCheckInterval)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -53,12 +153,13 @@ func main() {
 	}
 
 	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
-	}
+	return http.ListenAndServe(":"+port, server.routes())
 }
 
-func handleUpdate(w http.ResponseWriter, r *http.Request, client *RailwayClient) {
+// maxUpdateBodySize bounds the body of PUT /update, PUT /redeploy and service conversions.
+const maxUpdateBodySize = 1 << 20
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPut {
@@ -67,67 +168,358 @@ func handleUpdate(w http.ResponseWriter, r *http.Request, client *RailwayClient)
 		return
 	}
 
+	identity, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUpdateBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit)})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to read request body: %v", err)})
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		s.update(w, r, identity, body)
+		return
+	}
+
+	// Keys are scoped to the caller so identities cannot replay each other's responses
+	key = identity + "/" + key
+	state, status, stored := s.idempotency.Begin(key, requestFingerprint(body))
+	switch state {
+	case idempotencyReplay:
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(status)
+		w.Write(stored)
+		return
+	case idempotencyInProgress:
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "A request with this Idempotency-Key is still in progress"})
+		return
+	case idempotencyMismatch:
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Idempotency-Key was already used for a different request"})
+		return
+	}
+
+	// Only successful responses are replayed; errors such as a locked environment or
+	// a failing Railway API are worth retrying under the same key
+	capture := &responseCapture{ResponseWriter: w}
+	s.update(capture, r, identity, body)
+	if capture.status >= 200 && capture.status < 300 {
+		s.idempotency.Complete(key, capture.status, capture.body.Bytes())
+	} else {
+		s.idempotency.Release(key)
+	}
+}
+
+// update runs the update request in body and writes the response.
+func (s *Server) update(w http.ResponseWriter, r *http.Request, identity string, body []byte) {
 	var req UpdateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Invalid JSON: %v", err)})
 		return
 	}
 
-	// Validate UUIDs
-	if _, err := uuid.Parse(req.ProjectID); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid project_id: must be a valid UUID"})
+	original := req
+	cfg := s.config.Get()
+	opts, reqErr := validateUpdateRequest(&req, cfg)
+	if reqErr != nil {
+		w.WriteHeader(reqErr.Status)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: reqErr.Message})
 		return
 	}
+	opts.Owner = "PUT /update from " + r.RemoteAddr
+	if identity != "" {
+		opts.Owner = identity + " via PUT /update"
+	}
 
-	if _, err := uuid.Parse(req.EnvironmentID); err != nil {
+	if req.ApplyAt != nil && !req.ApplyAt.After(s.now()) {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid environment_id: must be a valid UUID"})
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "apply_at must be in the future"})
 		return
 	}
 
-	if len(req.ImagePrefixes) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "image_prefixes cannot be empty"})
+	if reqErr := enforceFreezes(cfg, &req, identity, req.applyTime(s.now()), s.audit); reqErr != nil {
+		w.WriteHeader(reqErr.Status)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: reqErr.Message})
 		return
 	}
 
-	if req.NewVersion == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "new_version cannot be empty"})
+	if cfg.RequiresApproval(req.environments()) {
+		s.requestApproval(w, original, req, opts, identity)
+		return
+	}
+
+	if req.ApplyAt != nil {
+		s.schedule(w, original, req, identity)
+		return
+	}
+
+	s.apply(w, req, opts)
+}
+
+// apply runs a validated update request and writes the response.
+func (s *Server) apply(w http.ResponseWriter, req UpdateRequest, opts UpdateOptions) {
+	if len(req.EnvironmentIDs) > 0 {
+		s.handlePromotion(w, req, opts)
 		return
 	}
 
 	// Get services and update matching ones
-	updatedServices, err := client.UpdateServices(req.EnvironmentID, req.ImagePrefixes, req.NewVersion)
+	result, err := s.client.UpdateServices(req.EnvironmentID, opts)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to update services: %v", err)})
+		w.WriteHeader(updateErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:              fmt.Sprintf("Failed to update services: %v", err),
+			UpdatedServices:    result.UpdatedNames(),
+			UnchangedServices:  result.Unchanged,
+			FailedServices:     result.Failed,
+			RolledBackServices: result.RolledBack,
+			RailwayErrors:      railwayErrors(err),
+		})
+		return
+	}
+
+	updatedServices := result.UpdatedNames()
+	if len(updatedServices) == 0 && len(result.Unchanged) > 0 {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SuccessResponse{
+			Message:           fmt.Sprintf("All %d matched service(s) already run %s", len(result.Unchanged), req.NewVersion),
+			UpdatedServices:   []string{},
+			UnchangedServices: result.Unchanged,
+			MatchLogic:        opts.Filter.Logic,
+		})
 		return
 	}
 
 	if len(updatedServices) == 0 {
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(SuccessResponse{
-			Message:         "No services matched the provided image prefixes",
+			Message:         "No services matched the provided filters",
 			UpdatedServices: []string{},
+			MatchLogic:      opts.Filter.Logic,
 		})
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(SuccessResponse{
-		Message:         fmt.Sprintf("Successfully updated %d service(s)", len(updatedServices)),
-		UpdatedServices: updatedServices,
+		Message:           fmt.Sprintf("Successfully updated %d service(s)", len(updatedServices)),
+		UpdatedServices:   updatedServices,
+		UnchangedServices: result.Unchanged,
+		FailedServices:    result.Failed,
+		MatchLogic:        opts.Filter.Logic,
 	})
 }
 
-func matchesPrefix(image string, prefixes []string) bool {
-	for _, prefix := range prefixes {
-		if strings.HasPrefix(image, prefix) {
-			return true
+// environments returns the environments the request updates, in order.
+func (req *UpdateRequest) environments() []string {
+	if len(req.EnvironmentIDs) > 0 {
+		return req.EnvironmentIDs
+	}
+	return []string{req.EnvironmentID}
+}
+
+// applyTime returns when the request takes effect: its apply_at time, or now.
+func (req *UpdateRequest) applyTime(now time.Time) time.Time {
+	if req.ApplyAt != nil {
+		return *req.ApplyAt
+	}
+	return now
+}
+
+// RequestError is an invalid update request together with the HTTP status it maps to.
+type RequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, args ...interface{}) *RequestError {
+	return &RequestError{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// validateUpdateRequest resolves the request's target against cfg, validates every
+// field and returns the options to pass to UpdateServices or PromoteServices. It is
+// shared by the /update endpoint and the update and plan commands.
+func validateUpdateRequest(req *UpdateRequest, cfg *Config) (UpdateOptions, *RequestError) {
+	if req.Target != "" {
+		target, ok := cfg.Target(req.Target)
+		if !ok {
+			return UpdateOptions{}, &RequestError{Status: http.StatusNotFound, Message: fmt.Sprintf("Unknown target %q", req.Target)}
+		}
+		if err := target.applyTo(req); err != nil {
+			return UpdateOptions{}, badRequest("%v", err)
+		}
+	}
+
+	// Validate UUIDs
+	if _, err := uuid.Parse(req.ProjectID); err != nil {
+		return UpdateOptions{}, badRequest("Invalid project_id: must be a valid UUID")
+	}
+
+	if len(req.EnvironmentIDs) > 0 {
+		if req.EnvironmentID != "" {
+			return UpdateOptions{}, badRequest("environment_id and environment_ids are mutually exclusive")
+		}
+		for _, environmentID := range req.EnvironmentIDs {
+			if _, err := uuid.Parse(environmentID); err != nil {
+				return UpdateOptions{}, badRequest("Invalid environment_ids entry %q: must be a valid UUID", environmentID)
+			}
+		}
+		switch req.Gate {
+		case "", GateDeploymentsSucceeded, GateNone:
+		default:
+			return UpdateOptions{}, badRequest("Invalid gate %q: must be %q or %q", req.Gate, GateDeploymentsSucceeded, GateNone)
+		}
+	} else if _, err := uuid.Parse(req.EnvironmentID); err != nil {
+		return UpdateOptions{}, badRequest("Invalid environment_id: must be a valid UUID")
+	}
+
+	filter := ServiceFilter{
+		ImagePrefixes: req.ImagePrefixes,
+		Matchers:      req.Matchers,
+		ServiceIDs:    req.ServiceIDs,
+		ServiceNames:  req.ServiceNames,
+		ExcludeNames:  req.ExcludeServiceNames,
+		Logic:         req.MatchLogic,
+		Scope:         req.scope,
+	}
+	if err := filter.Validate(); err != nil {
+		return UpdateOptions{}, badRequest("%v", err)
+	}
+
+	if req.NewVersion == "" {
+		return UpdateOptions{}, badRequest("new_version cannot be empty")
+	}
+
+	if req.Rollout != nil {
+		if err := req.Rollout.Validate(); err != nil {
+			return UpdateOptions{}, badRequest("%v", err)
+		}
+	}
+
+	if req.Variables != nil {
+		if err := req.Variables.Validate(); err != nil {
+			return UpdateOptions{}, badRequest("%v", err)
+		}
+	}
+
+	if req.LockWaitSeconds < 0 {
+		return UpdateOptions{}, badRequest("lock_wait_seconds cannot be negative")
+	}
+
+	if req.MaxDelaySeconds < 0 {
+		return UpdateOptions{}, badRequest("max_delay_seconds cannot be negative")
+	}
+
+	return UpdateOptions{
+		Filter:     filter,
+		NewVersion: req.NewVersion,
+		Rollout:    req.Rollout,
+		Variables:  req.Variables,
+		Force:      req.Force,
+		LockWait:   time.Duration(req.LockWaitSeconds) * time.Second,
+		ProjectID:  req.ProjectID,
+	}, nil
+}
+
+// enforceFreezes refuses an update while any of its environments is frozen, unless
+// the request carries an override reason and identity may override every active
+// freeze. Overrides are recorded in the audit log.
+func enforceFreezes(cfg *Config, req *UpdateRequest, identity string, now time.Time, audit *AuditLog) *RequestError {
+	var frozen []*FrozenError
+	for _, environmentID := range req.environments() {
+		var frozenErr *FrozenError
+		if errors.As(cfg.checkFreezes([]string{environmentID}, now), &frozenErr) {
+			frozen = append(frozen, frozenErr)
+		}
+	}
+	if len(frozen) == 0 {
+		return nil
+	}
+
+	for _, frozenErr := range frozen {
+		if req.FreezeOverrideReason == "" {
+			return &RequestError{Status: http.StatusForbidden, Message: fmt.Sprintf("Update refused: %v. Set freeze_override_reason to override", frozenErr)}
 		}
+		if !frozenErr.Freeze.CanOverride(identity) {
+			return &RequestError{Status: http.StatusForbidden, Message: fmt.Sprintf("Update refused: %v. This API key may not override it", frozenErr)}
+		}
+	}
+
+	for _, frozenErr := range frozen {
+		log.Printf("%s overrides freeze %s for environment %s: %s", identity, frozenErr.Freeze.Name, frozenErr.EnvironmentID, req.FreezeOverrideReason)
+		audit.Record(AuditEntry{
+			Action:        AuditFreezeOverride,
+			Identity:      identity,
+			EnvironmentID: frozenErr.EnvironmentID,
+			Version:       req.NewVersion,
+			Reason:        req.FreezeOverrideReason,
+			Detail:        frozenErr.Freeze.Name,
+		})
 	}
-	return false
+	return nil
+}
+
+// updateErrorStatus maps an UpdateServices or PromoteServices error to a status code.
+// Errors returned by the Railway API map to GraphQLErrors.Status.
+func updateErrorStatus(err error) int {
+	var lockedErr *LockedError
+	if errors.As(err, &lockedErr) {
+		return http.StatusConflict
+	}
+	var graphQLErrs *GraphQLErrors
+	if errors.As(err, &graphQLErrs) {
+		return graphQLErrs.Status()
+	}
+	return http.StatusInternalServerError
+}
+
+// railwayErrors returns the Railway API errors behind err, if any.
+func railwayErrors(err error) []GraphQLError {
+	var graphQLErrs *GraphQLErrors
+	if errors.As(err, &graphQLErrs) {
+		return graphQLErrs.Errors
+	}
+	return nil
+}
+
+func (s *Server) handlePromotion(w http.ResponseWriter, req UpdateRequest, update UpdateOptions) {
+	opts := PromotionOptions{
+		Gate:        req.Gate,
+		GateTimeout: time.Duration(req.GateTimeoutSeconds) * time.Second,
+	}
+
+	results, err := s.client.PromoteServices(req.EnvironmentIDs, update, opts)
+	if err != nil {
+		w.WriteHeader(updateErrorStatus(err))
+		json.NewEncoder(w).Encode(PromotionResponse{
+			Error:        fmt.Sprintf("Failed to promote services: %v", err),
+			Environments: results,
+			MatchLogic:   update.Filter.Logic,
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(PromotionResponse{
+		Message:      fmt.Sprintf("Successfully promoted through %d environment(s)", len(results)),
+		Environments: results,
+		MatchLogic:   update.Filter.Logic,
+	})
 }
@@ -8,12 +8,25 @@ import (
 	"testing"
 )
 
+// newTestServer returns a server for client with cfg (which may be nil) and every
+// webhook endpoint enabled with testWebhookSecret.
+func newTestServer(client *RailwayClient, cfg *Config) *Server {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	return NewServer(client, NewConfigStore(cfg), WebhookSecrets{
+		GitHub:       testWebhookSecret,
+		DockerHub:    testWebhookSecret,
+		Distribution: testWebhookSecret,
+	})
+}
+
 func TestHandleUpdate_MethodNotAllowed(t *testing.T) {
 	client := NewRailwayClient("test-token", "", "")
 	req := httptest.NewRequest(http.MethodGet, "/update", nil)
 	w := httptest.NewRecorder()
 
-	handleUpdate(w, req, client)
+	newTestServer(client, nil).handleUpdate(w, req)
 
 	if w.Code != http.StatusMethodNotAllowed {
 		t.Errorf("Expected status %d, got %d", http.StatusMethodNotAllowed, w.Code)
@@ -25,7 +38,7 @@ func TestHandleUpdate_InvalidJSON(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBufferString("invalid json"))
 	w := httptest.NewRecorder()
 
-	handleUpdate(w, req, client)
+	newTestServer(client, nil).handleUpdate(w, req)
 
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
@@ -53,7 +66,7 @@ func TestHandleUpdate_InvalidProjectID(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(jsonData))
 	w := httptest.NewRecorder()
 
-	handleUpdate(w, req, client)
+	newTestServer(client, nil).handleUpdate(w, req)
 
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
@@ -81,7 +94,7 @@ func TestHandleUpdate_InvalidEnvironmentID(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(jsonData))
 	w := httptest.NewRecorder()
 
-	handleUpdate(w, req, client)
+	newTestServer(client, nil).handleUpdate(w, req)
 
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
@@ -109,7 +122,7 @@ func TestHandleUpdate_EmptyImagePrefixes(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(jsonData))
 	w := httptest.NewRecorder()
 
-	handleUpdate(w, req, client)
+	newTestServer(client, nil).handleUpdate(w, req)
 
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
@@ -137,7 +150,7 @@ func TestHandleUpdate_EmptyNewVersion(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(jsonData))
 	w := httptest.NewRecorder()
 
-	handleUpdate(w, req, client)
+	newTestServer(client, nil).handleUpdate(w, req)
 
 	if w.Code != http.StatusBadRequest {
 		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
@@ -284,10 +297,7 @@ func TestHealthEndpoint(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/health", nil)
 	w := httptest.NewRecorder()
 
-	http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-	}).ServeHTTP(w, req)
+	handleHealth(w, req)
 
 	if w.Code != http.StatusOK {
 		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
@@ -302,3 +312,65 @@ func TestHealthEndpoint(t *testing.T) {
 		t.Errorf("Expected status 'ok', got '%s'", resp["status"])
 	}
 }
+
+func TestHandleUpdate_InvalidMatcher(t *testing.T) {
+	client := NewRailwayClient("test-token", "", "")
+	reqBody := UpdateRequest{
+		ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+		Matchers:      []ImageMatcher{{Regex: "("}},
+		NewVersion:    "v1.0.0",
+	}
+	jsonData, _ := json.Marshal(reqBody)
+	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(jsonData))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).handleUpdate(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestHandleUpdate_RailwayErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *GraphQLErrors
+		expected int
+	}{
+		{"unauthorized", &GraphQLErrors{Errors: []GraphQLError{{Message: "Not Authorized"}}}, http.StatusUnauthorized},
+		{"not found", &GraphQLErrors{Errors: []GraphQLError{{Message: "Environment not found", Extensions: map[string]interface{}{"code": "NOT_FOUND"}}}}, http.StatusNotFound},
+		{"rate limited", &GraphQLErrors{HTTPStatus: http.StatusTooManyRequests, Errors: []GraphQLError{{Message: "Too many requests"}}}, http.StatusTooManyRequests},
+		{"other", &GraphQLErrors{Errors: []GraphQLError{{Message: "Problem processing request"}}}, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+				return nil, tt.err
+			})
+
+			body, _ := json.Marshal(UpdateRequest{
+				ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+				EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+				ImagePrefixes: []string{"ghcr.io/returnearly/api"},
+				NewVersion:    "v2",
+			})
+			req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+
+			newTestServer(client, nil).handleUpdate(w, req)
+
+			if w.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(resp.RailwayErrors) != 1 || resp.RailwayErrors[0].Message != tt.err.Errors[0].Message {
+				t.Errorf("Expected the Railway errors in the response, got %+v", resp.RailwayErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ImageRef is a Docker image reference split into its repository, tag and digest.
+// The repository includes the registry host, e.g. "ghcr.io/returnearly/api".
+type ImageRef struct {
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// parseImageRef splits an image string such as "registry:5000/org/app:v1@sha256:..."
+// into repository, tag and digest. A colon is only treated as a tag separator when it
+// appears after the last slash, so registry ports are kept in the repository.
+func parseImageRef(image string) ImageRef {
+	var ref ImageRef
+
+	if i := strings.Index(image, "@"); i >= 0 {
+		ref.Digest = image[i+1:]
+		image = image[:i]
+	}
+
+	lastSlash := strings.LastIndex(image, "/")
+	if i := strings.LastIndex(image, ":"); i > lastSlash {
+		ref.Tag = image[i+1:]
+		image = image[:i]
+	}
+
+	ref.Repository = image
+	return ref
+}
+
+// WithTag returns the repository with the given tag, dropping any existing tag or digest.
+func (r ImageRef) WithTag(tag string) string {
+	return r.Repository + ":" + tag
+}
+
+// ImageMatcher selects images by repository name. Exactly one of Repository, Glob or
+// Regex must be set. Exclude lists globs that reject an otherwise matching repository.
+//
+// Globs use path.Match syntax. A glob without a slash is matched against the last
+// path segment of the repository ("*-migrations"), otherwise against the whole
+// repository ("ghcr.io/returnearly/*"). Regexes must match the whole repository.
+type ImageMatcher struct {
+	Repository string   `json:"repository,omitempty"`
+	Glob       string   `json:"glob,omitempty"`
+	Regex      string   `json:"regex,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+
+	re *regexp.Regexp
+	// tag, when set, only selects images on this tag that are not pinned to a
+	// digest, e.g. the services a digest watcher follows.
+	tag string
+}
+
+// Validate checks that the matcher is well formed and compiles its regex.
+func (m *ImageMatcher) Validate() error {
+	set := 0
+	for _, v := range []string{m.Repository, m.Glob, m.Regex} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of repository, glob or regex must be set")
+	}
+
+	if m.Glob != "" {
+		if _, err := path.Match(m.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %w", m.Glob, err)
+		}
+	}
+
+	if m.Regex != "" {
+		re, err := regexp.Compile("^(?:" + m.Regex + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid regex %q: %w", m.Regex, err)
+		}
+		m.re = re
+	}
+
+	for _, pattern := range m.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude glob %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether the repository is selected by the matcher. It only reads
+// the matcher, as matchers are shared by concurrent requests; a regex matcher must be
+// validated first, otherwise it matches nothing.
+func (m *ImageMatcher) Matches(repository string) bool {
+	matched := false
+	switch {
+	case m.Repository != "":
+		matched = repository == m.Repository
+	case m.Glob != "":
+		matched = matchGlob(m.Glob, repository)
+	case m.Regex != "":
+		matched = m.re != nil && m.re.MatchString(repository)
+	}
+
+	if !matched {
+		return false
+	}
+
+	for _, pattern := range m.Exclude {
+		if matchGlob(pattern, repository) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesRef reports whether the image's repository is selected by the matcher and,
+// when the matcher has a tag, the image runs that tag. An image without a tag or
+// digest runs latest.
+func (m *ImageMatcher) matchesRef(ref ImageRef) bool {
+	if m.tag != "" {
+		tag := ref.Tag
+		if tag == "" && ref.Digest == "" {
+			tag = "latest"
+		}
+		if tag != m.tag || ref.Digest != "" {
+			return false
+		}
+	}
+	return m.Matches(ref.Repository)
+}
+
+// matchGlob matches a glob against a repository. Patterns without a slash only
+// consider the last path segment, mirroring .gitignore semantics.
+func matchGlob(pattern, repository string) bool {
+	name := repository
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(repository)
+	}
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+func matchesPrefix(image string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package main
+
+import "testing"
+
+func TestParseImageRef(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected ImageRef
+	}{
+		{"myapp", ImageRef{Repository: "myapp"}},
+		{"myapp:v1.0.0", ImageRef{Repository: "myapp", Tag: "v1.0.0"}},
+		{"ghcr.io/returnearly/api:v2", ImageRef{Repository: "ghcr.io/returnearly/api", Tag: "v2"}},
+		{"localhost:5000/api", ImageRef{Repository: "localhost:5000/api"}},
+		{"localhost:5000/api:v3", ImageRef{Repository: "localhost:5000/api", Tag: "v3"}},
+		{"api@sha256:abc", ImageRef{Repository: "api", Digest: "sha256:abc"}},
+		{"api:v1@sha256:abc", ImageRef{Repository: "api", Tag: "v1", Digest: "sha256:abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			result := parseImageRef(tt.image)
+			if result != tt.expected {
+				t.Errorf("parseImageRef(%q) = %+v, expected %+v", tt.image, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImageMatcher(t *testing.T) {
+	tests := []struct {
+		name       string
+		matcher    ImageMatcher
+		repository string
+		expected   bool
+	}{
+		{
+			name:       "exact repository",
+			matcher:    ImageMatcher{Repository: "myapp"},
+			repository: "myapp",
+			expected:   true,
+		},
+		{
+			name:       "exact repository does not match longer name",
+			matcher:    ImageMatcher{Repository: "myapp"},
+			repository: "myapp-legacy",
+			expected:   false,
+		},
+		{
+			name:       "glob with slash matches whole repository",
+			matcher:    ImageMatcher{Glob: "ghcr.io/returnearly/*"},
+			repository: "ghcr.io/returnearly/api",
+			expected:   true,
+		},
+		{
+			name:       "glob with slash does not cross segments",
+			matcher:    ImageMatcher{Glob: "ghcr.io/*"},
+			repository: "ghcr.io/returnearly/api",
+			expected:   false,
+		},
+		{
+			name:       "glob without slash matches last segment",
+			matcher:    ImageMatcher{Glob: "api*"},
+			repository: "ghcr.io/returnearly/api-worker",
+			expected:   true,
+		},
+		{
+			name:       "exclusion rejects match",
+			matcher:    ImageMatcher{Glob: "ghcr.io/returnearly/*", Exclude: []string{"*-migrations"}},
+			repository: "ghcr.io/returnearly/api-migrations",
+			expected:   false,
+		},
+		{
+			name:       "exclusion keeps other matches",
+			matcher:    ImageMatcher{Glob: "ghcr.io/returnearly/*", Exclude: []string{"*-migrations"}},
+			repository: "ghcr.io/returnearly/api",
+			expected:   true,
+		},
+		{
+			name:       "regex is anchored",
+			matcher:    ImageMatcher{Regex: "ghcr.io/returnearly/(api|worker)"},
+			repository: "ghcr.io/returnearly/api-legacy",
+			expected:   false,
+		},
+		{
+			name:       "regex match",
+			matcher:    ImageMatcher{Regex: "ghcr.io/returnearly/(api|worker)"},
+			repository: "ghcr.io/returnearly/worker",
+			expected:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.matcher.Validate(); err != nil {
+				t.Fatalf("Validate() returned error: %v", err)
+			}
+			result := tt.matcher.Matches(tt.repository)
+			if result != tt.expected {
+				t.Errorf("Matches(%q) = %v, expected %v", tt.repository, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestImageMatcher_Unvalidated(t *testing.T) {
+	matcher := ImageMatcher{Regex: "ghcr.io/returnearly/.*"}
+
+	if matcher.Matches("ghcr.io/returnearly/api") || matcher.re != nil {
+		t.Errorf("Expected an unvalidated regex matcher to match nothing without compiling, got %+v", matcher)
+	}
+	if err := matcher.Validate(); err != nil {
+		t.Fatalf("Validate() returned error: %v", err)
+	}
+	if !matcher.Matches("ghcr.io/returnearly/api") {
+		t.Error("Expected the validated matcher to match")
+	}
+}
+
+func TestImageMatcher_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher ImageMatcher
+	}{
+		{"empty", ImageMatcher{}},
+		{"multiple kinds", ImageMatcher{Repository: "api", Glob: "api*"}},
+		{"bad regex", ImageMatcher{Regex: "("}},
+		{"bad glob", ImageMatcher{Glob: "["}},
+		{"bad exclude", ImageMatcher{Repository: "api", Exclude: []string{"["}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.matcher.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Notifier receives a summary after every update that changed or failed to change
+// services.
+type Notifier interface {
+	Notify(summary UpdateSummary) error
+}
+
+// UpdateSummary describes the outcome of an update in one environment.
+type UpdateSummary struct {
+	EnvironmentID      string           `json:"environment_id"`
+	Version            string           `json:"version"`
+	UpdatedServices    []string         `json:"updated_services"`
+	FailedServices     []ServiceFailure `json:"failed_services,omitempty"`
+	RolledBackServices []string         `json:"rolled_back_services,omitempty"`
+	Error              string           `json:"error,omitempty"`
+	DurationSeconds    float64          `json:"duration_seconds"`
+}
+
+func newUpdateSummary(result *UpdateResult, version string, err error, duration time.Duration) UpdateSummary {
+	summary := UpdateSummary{
+		EnvironmentID:      result.EnvironmentID,
+		Version:            version,
+		UpdatedServices:    result.UpdatedNames(),
+		FailedServices:     result.Failed,
+		RolledBackServices: result.RolledBack,
+		DurationSeconds:    duration.Round(time.Millisecond).Seconds(),
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	return summary
+}
+
+// Succeeded reports whether every matched service was updated.
+func (s UpdateSummary) Succeeded() bool {
+	return s.Error == "" && len(s.FailedServices) == 0
+}
+
+// Text renders the summary as a short human-readable message.
+func (s UpdateSummary) Text() string {
+	var b strings.Builder
+	if s.Succeeded() {
+		fmt.Fprintf(&b, "Updated %d service(s) to %s in environment %s", len(s.UpdatedServices), s.Version, s.EnvironmentID)
+	} else {
+		fmt.Fprintf(&b, "Update to %s failed in environment %s", s.Version, s.EnvironmentID)
+	}
+	fmt.Fprintf(&b, " (%.1fs)", s.DurationSeconds)
+
+	if len(s.UpdatedServices) > 0 {
+		fmt.Fprintf(&b, "\nUpdated: %s", strings.Join(s.UpdatedServices, ", "))
+	}
+	for _, failure := range s.FailedServices {
+		fmt.Fprintf(&b, "\nFailed: %s: %s", failure.ServiceName, failure.Error)
+	}
+	if len(s.RolledBackServices) > 0 {
+		fmt.Fprintf(&b, "\nRolled back: %s", strings.Join(s.RolledBackServices, ", "))
+	}
+	if s.Error != "" && len(s.FailedServices) == 0 {
+		fmt.Fprintf(&b, "\nError: %s", s.Error)
+	}
+	return b.String()
+}
+
+// retryPolicy controls how often a notification is attempted. The wait between
+// attempts starts at Backoff and doubles after every attempt.
+type retryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{Attempts: 3, Backoff: 500 * time.Millisecond}
+
+// postJSON posts payload to url, retrying network errors, 429 and 5xx responses.
+func postJSON(client *http.Client, url string, payload interface{}, retry retryPolicy) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	backoff := retry.Backoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := postOnce(client, url, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= retry.Attempts {
+			return fmt.Errorf("failed after %d attempt(s): %w", attempt, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func postOnce(client *http.Client, url string, body []byte) (retryable bool, err error) {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retryable, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// SlackNotifier posts summaries to a Slack incoming webhook.
+type SlackNotifier struct {
+	url        string
+	httpClient *http.Client
+	retry      retryPolicy
+}
+
+func NewSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		retry:      defaultRetryPolicy,
+	}
+}
+
+func (n *SlackNotifier) Notify(summary UpdateSummary) error {
+	icon := ":white_check_mark:"
+	if !summary.Succeeded() {
+		icon = ":x:"
+	}
+	return postJSON(n.httpClient, n.url, map[string]string{"text": icon + " " + summary.Text()}, n.retry)
+}
+
+// WebhookNotifier posts summaries as JSON to a generic webhook.
+type WebhookNotifier struct {
+	url        string
+	httpClient *http.Client
+	retry      retryPolicy
+}
+
+// WebhookNotification is the body posted by WebhookNotifier.
+type WebhookNotification struct {
+	Event     string `json:"event"`
+	Succeeded bool   `json:"succeeded"`
+	UpdateSummary
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		retry:      defaultRetryPolicy,
+	}
+}
+
+func (n *WebhookNotifier) Notify(summary UpdateSummary) error {
+	return postJSON(n.httpClient, n.url, WebhookNotification{
+		Event:         "update",
+		Succeeded:     summary.Succeeded(),
+		UpdateSummary: summary,
+	}, n.retry)
+}
+
+// MultiNotifier sends every summary to all of its notifiers.
+type MultiNotifier []Notifier
+
+func (m MultiNotifier) Notify(summary UpdateSummary) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(summary); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
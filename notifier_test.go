@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = retryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+// notificationStub records request bodies and answers with the given statuses in
+// order, then 200.
+type notificationStub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	bodies   []map[string]interface{}
+	statuses []int
+}
+
+func newNotificationStub(t *testing.T, statuses ...int) *notificationStub {
+	t.Helper()
+	stub := &notificationStub{statuses: statuses}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		stub.bodies = append(stub.bodies, body)
+		if len(stub.statuses) > 0 {
+			w.WriteHeader(stub.statuses[0])
+			stub.statuses = stub.statuses[1:]
+		}
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *notificationStub) requests() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.bodies...)
+}
+
+var failedSummary = UpdateSummary{
+	EnvironmentID:      "env-1",
+	Version:            "v1.2.3",
+	UpdatedServices:    []string{"api"},
+	FailedServices:     []ServiceFailure{{ServiceName: "worker", Error: "deployment deploy-2 finished with status FAILED"}},
+	RolledBackServices: []string{"api"},
+	Error:              "1 deployment(s) did not succeed",
+	DurationSeconds:    42,
+}
+
+func TestSlackNotifier_Retries(t *testing.T) {
+	stub := newNotificationStub(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	notifier := NewSlackNotifier(stub.URL)
+	notifier.retry = testRetryPolicy
+
+	if err := notifier.Notify(failedSummary); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	requests := stub.requests()
+	if len(requests) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", len(requests))
+	}
+	text, _ := requests[2]["text"].(string)
+	for _, expected := range []string{":x:", "v1.2.3", "env-1", "worker", "Rolled back: api", "42.0s"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in Slack message %q", expected, text)
+		}
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	stub := newNotificationStub(t)
+	notifier := NewWebhookNotifier(stub.URL)
+	notifier.retry = testRetryPolicy
+
+	if err := notifier.Notify(failedSummary); err != nil {
+		t.Fatalf("Notify returned error: %v", err)
+	}
+
+	requests := stub.requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(requests))
+	}
+	body := requests[0]
+	if body["event"] != "update" || body["succeeded"] != false || body["version"] != "v1.2.3" {
+		t.Errorf("Unexpected notification %v", body)
+	}
+	if failed, _ := body["failed_services"].([]interface{}); len(failed) != 1 {
+		t.Errorf("Expected 1 failed service, got %v", body["failed_services"])
+	}
+}
+
+func TestWebhookNotifier_GivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+	}{
+		{"client error is not retried", []int{http.StatusBadRequest}, 1},
+		{"server errors exhaust retries", []int{500, 502, 503}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newNotificationStub(t, tt.statuses...)
+			notifier := NewWebhookNotifier(stub.URL)
+			notifier.retry = testRetryPolicy
+
+			if err := notifier.Notify(failedSummary); err == nil {
+				t.Error("Expected Notify error")
+			}
+			if attempts := len(stub.requests()); attempts != tt.attempts {
+				t.Errorf("Expected %d attempt(s), got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+// recordingNotifier records summaries and returns err from Notify.
+type recordingNotifier struct {
+	summaries []UpdateSummary
+	err       error
+}
+
+func (n *recordingNotifier) Notify(summary UpdateSummary) error {
+	n.summaries = append(n.summaries, summary)
+	return n.err
+}
+
+func TestUpdateServices_Notifies(t *testing.T) {
+	_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData([3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"}), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+	notifier := &recordingNotifier{err: errors.New("slack is down")}
+	client.notifier = notifier
+
+	opts := UpdateOptions{Filter: ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api"}}, NewVersion: "v2"}
+	if _, err := client.UpdateServices("env-1", opts); err != nil {
+		t.Fatalf("UpdateServices returned error: %v", err)
+	}
+
+	// Nothing matched, so nothing is reported
+	opts.Filter.ImagePrefixes = []string{"ghcr.io/returnearly/web"}
+	if _, err := client.UpdateServices("env-1", opts); err != nil {
+		t.Fatalf("UpdateServices returned error: %v", err)
+	}
+
+	if len(notifier.summaries) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(notifier.summaries))
+	}
+	summary := notifier.summaries[0]
+	if !summary.Succeeded() || summary.Version != "v2" || len(summary.UpdatedServices) != 1 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// graphQLOperation is a named Railway GraphQL operation whose variables encode from V
+// and whose data decodes into R. Every operation the client sends is defined here and
+// listed in railwayOperations, so tests can validate them against the vendored
+// Railway schema in testdata.
+type graphQLOperation[V, R any] struct {
+	Name     string
+	Document string
+}
+
+// graphQLDefinition describes an operation for validation, with zero values of its
+// variables and response types.
+type graphQLDefinition interface {
+	definition() (name, document string, variables, response interface{})
+}
+
+func (op graphQLOperation[V, R]) definition() (string, string, interface{}, interface{}) {
+	var variables V
+	var response R
+	return op.Name, op.Document, variables, response
+}
+
+// execute sends op with variables, authorized by the token for the environment, and
+// decodes the response data.
+func execute[V, R any](c *RailwayClient, environmentID string, op graphQLOperation[V, R], variables V) (*R, error) {
+	token, err := c.tokenFor(environmentID)
+	if err != nil {
+		return nil, err
+	}
+	return executeWithToken(c, token, op, variables)
+}
+
+// executeWithToken sends op with variables, authorized by token, and decodes the
+// response data.
+func executeWithToken[V, R any](c *RailwayClient, token RailwayToken, op graphQLOperation[V, R], variables V) (*R, error) {
+	encoded, err := json.Marshal(variables)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s variables: %w", op.Name, err)
+	}
+	var vars map[string]interface{}
+	if err := json.Unmarshal(encoded, &vars); err != nil {
+		return nil, fmt.Errorf("failed to marshal %s variables: %w", op.Name, err)
+	}
+
+	data, err := c.doRequest(token, op.Name, op.Document, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	var result R
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse %s response: %w", op.Name, err)
+	}
+	return &result, nil
+}
+
+// railwayOperations lists every operation the client sends.
+var railwayOperations = []graphQLDefinition{
+	environmentServicesQuery,
+	environmentProjectQuery,
+	deploymentQuery,
+	serviceInstanceUpdateMutation,
+	serviceInstanceDeployV2Mutation,
+	serviceInstanceDeployMutation,
+	variableCollectionUpsertMutation,
+	variableDeleteMutation,
+}
+
+type environmentVariables struct {
+	EnvironmentID string `json:"environmentId"`
+}
+
+type deploymentNode struct {
+	ID     string          `json:"id"`
+	Status string          `json:"status"`
+	Meta   json.RawMessage `json:"meta"`
+}
+
+type serviceInstanceNode struct {
+	ID               string          `json:"id"`
+	ServiceID        string          `json:"serviceId"`
+	ServiceName      string          `json:"serviceName"`
+	LatestDeployment *deploymentNode `json:"latestDeployment"`
+	Source           struct {
+		Image string `json:"image"`
+		Repo  string `json:"repo"`
+	} `json:"source"`
+}
+
+type environmentServicesData struct {
+	Environment struct {
+		ID               string `json:"id"`
+		Name             string `json:"name"`
+		ProjectID        string `json:"projectId"`
+		ServiceInstances struct {
+			Edges []struct {
+				Node serviceInstanceNode `json:"node"`
+			} `json:"edges"`
+			PageInfo struct {
+				EndCursor       string `json:"endCursor"`
+				HasNextPage     bool   `json:"hasNextPage"`
+				HasPreviousPage bool   `json:"hasPreviousPage"`
+				StartCursor     string `json:"startCursor"`
+			} `json:"pageInfo"`
+		} `json:"serviceInstances"`
+	} `json:"environment"`
+}
+
+var environmentServicesQuery = graphQLOperation[environmentVariables, environmentServicesData]{
+	Name: "Environment",
+	Document: `
+		query Environment($environmentId: String!) {
+			environment(id: $environmentId) {
+				id
+				name
+				projectId
+				serviceInstances(after: null) {
+					edges {
+						node {
+							id
+							serviceId
+							serviceName
+							latestDeployment {
+								id
+								status
+								meta
+							}
+							source {
+								image
+								repo
+							}
+						}
+					}
+					pageInfo {
+						endCursor
+						hasNextPage
+						hasPreviousPage
+						startCursor
+					}
+				}
+			}
+		}
+	`,
+}
+
+type environmentProjectData struct {
+	Environment struct {
+		ProjectID string `json:"projectId"`
+	} `json:"environment"`
+}
+
+var environmentProjectQuery = graphQLOperation[environmentVariables, environmentProjectData]{
+	Name: "EnvironmentProject",
+	Document: `
+		query EnvironmentProject($environmentId: String!) {
+			environment(id: $environmentId) {
+				projectId
+			}
+		}
+	`,
+}
+
+type deploymentVariables struct {
+	ID string `json:"id"`
+}
+
+type deploymentData struct {
+	Deployment struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	} `json:"deployment"`
+}
+
+var deploymentQuery = graphQLOperation[deploymentVariables, deploymentData]{
+	Name: "Deployment",
+	Document: `
+		query Deployment($id: String!) {
+			deployment(id: $id) {
+				id
+				status
+			}
+		}
+	`,
+}
+
+// serviceSourceInput sets a service instance's source. Repo is sent as null to
+// clear it when converting a repo service to an image.
+type serviceSourceInput struct {
+	Image string          `json:"image,omitempty"`
+	Repo  json.RawMessage `json:"repo,omitempty"`
+}
+
+type registryCredentialsInput struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type serviceInstanceUpdateInput struct {
+	Source              serviceSourceInput        `json:"source"`
+	NumReplicas         int                       `json:"numReplicas"`
+	RegistryCredentials *registryCredentialsInput `json:"registryCredentials,omitempty"`
+}
+
+type serviceInstanceUpdateVariables struct {
+	EnvironmentID string                     `json:"environmentId"`
+	ServiceID     string                     `json:"serviceId"`
+	Input         serviceInstanceUpdateInput `json:"input"`
+}
+
+type serviceInstanceUpdateData struct {
+	ServiceInstanceUpdate bool `json:"serviceInstanceUpdate"`
+}
+
+var serviceInstanceUpdateMutation = graphQLOperation[serviceInstanceUpdateVariables, serviceInstanceUpdateData]{
+	Name: "ServiceInstanceUpdate",
+	Document: `
+		mutation ServiceInstanceUpdate($environmentId: String!, $serviceId: String!, $input: ServiceInstanceUpdateInput!) {
+			serviceInstanceUpdate(environmentId: $environmentId, serviceId: $serviceId, input: $input)
+		}
+	`,
+}
+
+// serviceInstanceDeployV2Variables deploy the service's current source, or CommitSHA
+// of a repo service.
+type serviceInstanceDeployV2Variables struct {
+	ServiceID     string `json:"serviceId"`
+	EnvironmentID string `json:"environmentId"`
+	CommitSHA     string `json:"commitSha,omitempty"`
+}
+
+type serviceInstanceDeployV2Data struct {
+	DeploymentID string `json:"serviceInstanceDeployV2"`
+}
+
+var serviceInstanceDeployV2Mutation = graphQLOperation[serviceInstanceDeployV2Variables, serviceInstanceDeployV2Data]{
+	Name: "ServiceInstanceDeployV2",
+	Document: `
+		mutation ServiceInstanceDeployV2($serviceId: String!, $environmentId: String!, $commitSha: String) {
+			serviceInstanceDeployV2(serviceId: $serviceId, environmentId: $environmentId, commitSha: $commitSha)
+		}
+	`,
+}
+
+type serviceInstanceDeployVariables struct {
+	ServiceID     string `json:"serviceId"`
+	EnvironmentID string `json:"environmentId"`
+	LatestCommit  bool   `json:"latestCommit"`
+}
+
+type serviceInstanceDeployData struct {
+	ServiceInstanceDeploy bool `json:"serviceInstanceDeploy"`
+}
+
+var serviceInstanceDeployMutation = graphQLOperation[serviceInstanceDeployVariables, serviceInstanceDeployData]{
+	Name: "ServiceInstanceDeploy",
+	Document: `
+		mutation ServiceInstanceDeploy($serviceId: String!, $environmentId: String!, $latestCommit: Boolean) {
+			serviceInstanceDeploy(serviceId: $serviceId, environmentId: $environmentId, latestCommit: $latestCommit)
+		}
+	`,
+}
+
+type variableCollectionUpsertInput struct {
+	ProjectID     string            `json:"projectId"`
+	EnvironmentID string            `json:"environmentId"`
+	ServiceID     string            `json:"serviceId,omitempty"`
+	Variables     map[string]string `json:"variables"`
+	SkipDeploys   bool              `json:"skipDeploys"`
+}
+
+type variableCollectionUpsertVariables struct {
+	Input variableCollectionUpsertInput `json:"input"`
+}
+
+type variableCollectionUpsertData struct {
+	VariableCollectionUpsert bool `json:"variableCollectionUpsert"`
+}
+
+var variableCollectionUpsertMutation = graphQLOperation[variableCollectionUpsertVariables, variableCollectionUpsertData]{
+	Name: "VariableCollectionUpsert",
+	Document: `
+		mutation VariableCollectionUpsert($input: VariableCollectionUpsertInput!) {
+			variableCollectionUpsert(input: $input)
+		}
+	`,
+}
+
+type variableDeleteInput struct {
+	ProjectID     string `json:"projectId"`
+	EnvironmentID string `json:"environmentId"`
+	ServiceID     string `json:"serviceId,omitempty"`
+	Name          string `json:"name"`
+}
+
+type variableDeleteVariables struct {
+	Input variableDeleteInput `json:"input"`
+}
+
+type variableDeleteData struct {
+	VariableDelete bool `json:"variableDelete"`
+}
+
+var variableDeleteMutation = graphQLOperation[variableDeleteVariables, variableDeleteData]{
+	Name: "VariableDelete",
+	Document: `
+		mutation VariableDelete($input: VariableDeleteInput!) {
+			variableDelete(input: $input)
+		}
+	`,
+}
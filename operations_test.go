@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const railwaySchemaFile = "testdata/railway-schema.graphql"
+
+func loadRailwaySchema(t *testing.T, edit func(string) string) *ast.Schema {
+	t.Helper()
+	data, err := os.ReadFile(railwaySchemaFile)
+	if err != nil {
+		t.Fatalf("Failed to read schema: %v", err)
+	}
+	input := string(data)
+	if edit != nil {
+		input = edit(input)
+	}
+	schema, err := gqlparser.LoadSchema(&ast.Source{Name: railwaySchemaFile, Input: input})
+	if err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+	return schema
+}
+
+// leafKinds are the Go kinds that built-in GraphQL scalars decode into. Custom
+// scalars such as DeploymentMeta may use any Go type.
+var leafKinds = map[string]reflect.Kind{
+	"String":  reflect.String,
+	"ID":      reflect.String,
+	"Int":     reflect.Int,
+	"Float":   reflect.Float64,
+	"Boolean": reflect.Bool,
+}
+
+// validateOperation checks an operation's document against schema, and that its
+// variables and response types match the document.
+func validateOperation(schema *ast.Schema, def graphQLDefinition) []string {
+	name, document, variables, response := def.definition()
+	doc, errs := gqlparser.LoadQuery(schema, document)
+	if len(errs) > 0 {
+		problems := make([]string, 0, len(errs))
+		for _, err := range errs {
+			problems = append(problems, err.Message)
+		}
+		return problems
+	}
+	if len(doc.Operations) != 1 || doc.Operations[0].Name != name {
+		return []string{fmt.Sprintf("document must define exactly the operation %s", name)}
+	}
+	op := doc.Operations[0]
+
+	var problems []string
+	fields := jsonFields(reflect.TypeOf(variables))
+	for _, variable := range op.VariableDefinitions {
+		goType, ok := fields[variable.Variable]
+		if !ok {
+			if variable.Type.NonNull {
+				problems = append(problems, fmt.Sprintf("required variable $%s is not encoded", variable.Variable))
+			}
+			continue
+		}
+		delete(fields, variable.Variable)
+		problems = append(problems, checkInput(schema, variable.Type, goType, "$"+variable.Variable)...)
+	}
+	for field := range fields {
+		problems = append(problems, fmt.Sprintf("variable $%s is not declared", field))
+	}
+
+	return append(problems, checkSelection(schema, op.SelectionSet, reflect.TypeOf(response), "data")...)
+}
+
+// checkInput checks that goType encodes into the input type typ.
+func checkInput(schema *ast.Schema, typ *ast.Type, goType reflect.Type, path string) []string {
+	goType = elemType(goType)
+	def := schema.Types[typ.Name()]
+	if def.Kind != ast.InputObject {
+		return checkLeaf(def, goType, path)
+	}
+	if goType.Kind() != reflect.Struct {
+		return []string{fmt.Sprintf("%s: input %s is encoded from %s", path, def.Name, goType)}
+	}
+
+	var problems []string
+	fields := jsonFields(goType)
+	for _, field := range def.Fields {
+		goField, ok := fields[field.Name]
+		if !ok {
+			if field.Type.NonNull && field.DefaultValue == nil {
+				problems = append(problems, fmt.Sprintf("%s: required field %s is not encoded", path, field.Name))
+			}
+			continue
+		}
+		delete(fields, field.Name)
+		problems = append(problems, checkInput(schema, field.Type, goField, path+"."+field.Name)...)
+	}
+	for field := range fields {
+		problems = append(problems, fmt.Sprintf("%s: %s is not a field of %s", path, field, def.Name))
+	}
+	return problems
+}
+
+// checkSelection checks that every field goType decodes is selected, with a matching type.
+func checkSelection(schema *ast.Schema, set ast.SelectionSet, goType reflect.Type, path string) []string {
+	goType = elemType(goType)
+	if goType.Kind() != reflect.Struct {
+		return []string{fmt.Sprintf("%s: selection is decoded into %s", path, goType)}
+	}
+
+	selected := make(map[string]*ast.Field)
+	for _, selection := range set {
+		if field, ok := selection.(*ast.Field); ok {
+			selected[field.Alias] = field
+		}
+	}
+
+	var problems []string
+	for name, goField := range jsonFields(goType) {
+		field, ok := selected[name]
+		fieldPath := path + "." + name
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is decoded but not selected", fieldPath))
+		case len(field.SelectionSet) > 0:
+			problems = append(problems, checkSelection(schema, field.SelectionSet, goField, fieldPath)...)
+		default:
+			problems = append(problems, checkLeaf(schema.Types[field.Definition.Type.Name()], elemType(goField), fieldPath)...)
+		}
+	}
+	return problems
+}
+
+func checkLeaf(def *ast.Definition, goType reflect.Type, path string) []string {
+	kind, builtin := leafKinds[def.Name]
+	if def.Kind == ast.Enum {
+		kind, builtin = reflect.String, true
+	}
+	// Raw JSON fits any leaf
+	if builtin && goType.Kind() != kind && goType.Kind() != reflect.Slice {
+		return []string{fmt.Sprintf("%s: %s does not fit in %s", path, def.Name, goType)}
+	}
+	return nil
+}
+
+// elemType unwraps pointers and slices, except raw JSON.
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer || (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+		t = t.Elem()
+	}
+	return t
+}
+
+// jsonFields returns the Go types of a struct's fields by JSON name.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func TestRailwayOperations(t *testing.T) {
+	schema := loadRailwaySchema(t, nil)
+	if data, err := os.ReadFile(railwaySchemaFile); err == nil && strings.HasPrefix(string(data), "# Subset") {
+		t.Log(railwaySchemaFile + " is the hand-written subset; run TestRailwaySchema with -update-schema to check against Railway's schema")
+	}
+
+	// Operation names identify requests in logs, errors and test fakes
+	seen := make(map[string]bool)
+	for _, def := range railwayOperations {
+		name, _, _, _ := def.definition()
+		if seen[name] {
+			t.Errorf("Operation name %s is used by more than one operation", name)
+		}
+		seen[name] = true
+		t.Run(name, func(t *testing.T) {
+			for _, problem := range validateOperation(schema, def) {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestRailwayOperations_SchemaChanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation graphQLDefinition
+		edit      func(string) string
+		expected  string
+	}{
+		{
+			name:      "removed field",
+			operation: environmentServicesQuery,
+			edit: func(schema string) string {
+				return strings.Replace(schema, "type ServiceSource {\n  image: String\n  repo: String\n}", "type ServiceSource {\n  image: String\n}", 1)
+			},
+			expected: `Cannot query field "repo"`,
+		},
+		{
+			name:      "renamed input field",
+			operation: serviceInstanceUpdateMutation,
+			edit: func(schema string) string {
+				return strings.Replace(schema, "  numReplicas: Int\n  region: String\n  registryCredentials", "  replicas: Int\n  region: String\n  registryCredentials", 1)
+			},
+			expected: "numReplicas is not a field of ServiceInstanceUpdateInput",
+		},
+		{
+			name:      "changed type",
+			operation: deploymentQuery,
+			edit: func(schema string) string {
+				return strings.Replace(schema, "  status: DeploymentStatus!", "  status: Int!", 1)
+			},
+			expected: "data.deployment.status: Int does not fit in string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := validateOperation(loadRailwaySchema(t, tt.edit), tt.operation)
+			if !strings.Contains(strings.Join(problems, "\n"), tt.expected) {
+				t.Errorf("Expected a problem containing %q, got %v", tt.expected, problems)
+			}
+		})
+	}
+}
+
+func TestRailwayBatchMutations(t *testing.T) {
+	schema := loadRailwaySchema(t, nil)
+
+	for _, def := range railwayBatchMutations {
+		name, document, variables := def.batchDefinition(3)
+		t.Run(name, func(t *testing.T) {
+			doc, errs := gqlparser.LoadQuery(schema, document)
+			for _, err := range errs {
+				t.Error(err.Message)
+			}
+			if len(errs) > 0 {
+				return
+			}
+
+			// Per-alias variables are the item's fields with the alias index appended
+			fields := jsonFields(reflect.TypeOf(variables))
+			for _, variable := range doc.Operations[0].VariableDefinitions {
+				field := strings.TrimRight(variable.Variable, "0123456789")
+				goType, ok := fields[field]
+				if !ok {
+					t.Errorf("variable $%s is not encoded", variable.Variable)
+					continue
+				}
+				for _, problem := range checkInput(schema, variable.Type, goType, "$"+variable.Variable) {
+					t.Error(problem)
+				}
+			}
+		})
+	}
+}
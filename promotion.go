@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Gates applied between promotion stages.
+const (
+	GateDeploymentsSucceeded = "deployments_succeeded"
+	GateNone                 = "none"
+)
+
+const defaultGateTimeout = 10 * time.Minute
+
+// Per-environment promotion statuses.
+const (
+	StageSucceeded = "succeeded" // updated and every deployment succeeded
+	StageDeployed  = "deployed"  // updated, deployments were not waited on
+	StageFailed    = "failed"
+	StageSkipped   = "skipped" // not attempted because an earlier stage failed
+)
+
+// EnvironmentResult reports what happened to one environment during a promotion.
+type EnvironmentResult struct {
+	EnvironmentID   string           `json:"environment_id"`
+	Status          string           `json:"status"`
+	UpdatedServices []string         `json:"updated_services"`
+	Unchanged       []string         `json:"unchanged_services,omitempty"`
+	FailedServices  []ServiceFailure `json:"failed_services,omitempty"`
+	RolledBack      []string         `json:"rolled_back_services,omitempty"`
+	Error           string           `json:"error,omitempty"`
+}
+
+// PromotionOptions controls how PromoteServices moves between environments.
+type PromotionOptions struct {
+	Gate        string
+	GateTimeout time.Duration
+}
+
+// PromoteServices runs UpdateServices for each environment in order. With the
+// deployments_succeeded gate, every stage except the last waits for its deployments
+// to succeed before the next stage starts. The first failure stops the promotion and
+// the remaining environments are reported as skipped.
+func (c *RailwayClient) PromoteServices(environmentIDs []string, update UpdateOptions, opts PromotionOptions) ([]EnvironmentResult, error) {
+	c = c.forProject(update.ProjectID)
+	if opts.Gate == "" {
+		opts.Gate = GateDeploymentsSucceeded
+	}
+	if opts.GateTimeout <= 0 {
+		opts.GateTimeout = defaultGateTimeout
+	}
+
+	results := make([]EnvironmentResult, 0, len(environmentIDs))
+	var promotionErr error
+
+	for i, environmentID := range environmentIDs {
+		if promotionErr != nil {
+			results = append(results, EnvironmentResult{
+				EnvironmentID:   environmentID,
+				Status:          StageSkipped,
+				UpdatedServices: []string{},
+			})
+			continue
+		}
+
+		log.Printf("Promotion stage %d/%d: environment %s", i+1, len(environmentIDs), environmentID)
+
+		result, err := c.UpdateServices(environmentID, update)
+		stage := EnvironmentResult{
+			EnvironmentID:   environmentID,
+			Status:          StageDeployed,
+			UpdatedServices: result.UpdatedNames(),
+			Unchanged:       result.Unchanged,
+			FailedServices:  result.Failed,
+			RolledBack:      result.RolledBack,
+		}
+
+		if err == nil && opts.Gate == GateDeploymentsSucceeded && i < len(environmentIDs)-1 {
+			if len(result.Failed) > 0 {
+				// A rollout that continued past failures already knows this stage is unhealthy
+				err = &DeploymentError{Failures: result.Failed}
+			} else {
+				err = c.WaitForDeployments(result.EnvironmentID, result.Updated, opts.GateTimeout)
+				var deployErr *DeploymentError
+				if errors.As(err, &deployErr) {
+					stage.FailedServices = append(stage.FailedServices, deployErr.Failures...)
+				}
+			}
+			if err == nil {
+				stage.Status = StageSucceeded
+			}
+		}
+
+		if err != nil {
+			stage.Status = StageFailed
+			stage.Error = err.Error()
+			promotionErr = fmt.Errorf("promotion stopped at environment %s: %w", environmentID, err)
+		}
+
+		results = append(results, stage)
+	}
+
+	return results, promotionErr
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func promotionFake(t *testing.T, finalStatus string) (*fakeRailway, *RailwayClient) {
+	var mu sync.Mutex
+	polls := 0
+
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData([3]string{"svc-" + variables["environmentId"].(string), "api", "ghcr.io/returnearly/api:v1"}), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		case "Deployment":
+			mu.Lock()
+			defer mu.Unlock()
+			polls++
+			status := "BUILDING"
+			if polls > 1 {
+				status = finalStatus
+			}
+			return map[string]interface{}{"deployment": map[string]interface{}{"id": "deploy-1", "status": status}}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+}
+
+func TestPromoteServices_GatePasses(t *testing.T) {
+	_, client := promotionFake(t, "SUCCESS")
+	update := UpdateOptions{
+		Filter:     ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api"}},
+		NewVersion: "v2",
+	}
+
+	results, err := client.PromoteServices([]string{"staging", "production"}, update, PromotionOptions{})
+	if err != nil {
+		t.Fatalf("PromoteServices returned error: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+	if results[0].Status != StageSucceeded {
+		t.Errorf("Expected staging status %q, got %q", StageSucceeded, results[0].Status)
+	}
+	if results[1].Status != StageDeployed {
+		t.Errorf("Expected production status %q, got %q", StageDeployed, results[1].Status)
+	}
+}
+
+func TestPromoteServices_GateStopsOnFailure(t *testing.T) {
+	fake, client := promotionFake(t, "FAILED")
+	update := UpdateOptions{
+		Filter:     ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api"}},
+		NewVersion: "v2",
+	}
+
+	results, err := client.PromoteServices([]string{"staging", "production"}, update, PromotionOptions{})
+	if err == nil {
+		t.Fatal("Expected promotion error")
+	}
+
+	if results[0].Status != StageFailed || results[0].Error == "" {
+		t.Errorf("Expected staging to fail with an error, got %+v", results[0])
+	}
+	if results[1].Status != StageSkipped {
+		t.Errorf("Expected production status %q, got %q", StageSkipped, results[1].Status)
+	}
+
+	for _, call := range fake.calls {
+		if call.Variables["environmentId"] == "production" {
+			t.Errorf("Expected production to be untouched, got %s call", call.Operation)
+		}
+	}
+}
+
+func TestPromoteServices_NoGate(t *testing.T) {
+	fake, client := promotionFake(t, "FAILED")
+	update := UpdateOptions{
+		Filter:     ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api"}},
+		NewVersion: "v2",
+	}
+
+	results, err := client.PromoteServices([]string{"staging", "production"}, update, PromotionOptions{Gate: GateNone})
+	if err != nil {
+		t.Fatalf("PromoteServices returned error: %v", err)
+	}
+
+	for _, result := range results {
+		if result.Status != StageDeployed {
+			t.Errorf("Expected status %q for %s, got %q", StageDeployed, result.EnvironmentID, result.Status)
+		}
+	}
+	for _, op := range fake.operations() {
+		if op == "Deployment" {
+			t.Error("Expected no deployment polling without a gate")
+		}
+	}
+}
@@ -8,49 +8,193 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const railwayAPIURL = "https://backboard.railway.app/graphql/v2"
 
+// defaultPollInterval is how often deployment status is polled while waiting.
+const defaultPollInterval = 5 * time.Second
+
 type RailwayClient struct {
-	token                  string
+	tokens                 *RailwayTokens
+	apiURL                 string
 	httpClient             *http.Client
 	registryCredentialUser string
 	registryCredentialPass string
+	pollInterval           time.Duration
+
+	// notifier, when set, receives a summary after every UpdateServices call that
+	// changed or failed to change services.
+	notifier Notifier
+
+	// locks serializes UpdateServices calls per environment.
+	locks *EnvironmentLocks
+
+	// batchSize is how many services' updates, and then deploys, are sent as one
+	// aliased mutation. 1 sends a mutation per service.
+	batchSize int
+
+	// projectID, when set, is the project of every environment the client is used
+	// for, so its token is chosen without looking the project up. See forProject.
+	projectID string
 }
 
 type GraphQLRequest struct {
-	Query     string                 `json:"query"`
-	Variables map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
 }
 
 type GraphQLResponse struct {
 	Data   json.RawMessage `json:"data"`
-	Errors []struct {
-		Message string `json:"message"`
-	} `json:"errors,omitempty"`
+	Errors []GraphQLError  `json:"errors,omitempty"`
+}
+
+// GraphQLError is one entry of a GraphQL response's errors. Path locates the field
+// that failed; Extensions carries details such as the error code.
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// Code returns the error's extensions.code, e.g. "UNAUTHENTICATED", or "".
+func (e GraphQLError) Code() string {
+	code, _ := e.Extensions["code"].(string)
+	return code
+}
+
+func (e GraphQLError) String() string {
+	s := e.Message
+	var details []string
+	if len(e.Path) > 0 {
+		parts := make([]string, 0, len(e.Path))
+		for _, part := range e.Path {
+			parts = append(parts, fmt.Sprint(part))
+		}
+		details = append(details, "path "+strings.Join(parts, "."))
+	}
+	if code := e.Code(); code != "" {
+		details = append(details, "code "+code)
+	}
+	if len(details) > 0 {
+		s += " (" + strings.Join(details, ", ") + ")"
+	}
+	return s
+}
+
+// GraphQLErrors is returned when Railway responds to an operation with errors. It
+// keeps every error, and the HTTP status if the response was not a 200.
+type GraphQLErrors struct {
+	Operation  string
+	HTTPStatus int
+	Errors     []GraphQLError
+}
+
+func (e *GraphQLErrors) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.String())
+	}
+	return fmt.Sprintf("GraphQL error in %s: %s", e.Operation, strings.Join(messages, "; "))
+}
+
+// Status maps the errors to the status code to respond with: 401 when the Railway
+// token was rejected, 403 when it lacks access, 429 when rate limited, 404 when a
+// resource does not exist and 502 otherwise. Extension codes decide; messages are
+// only consulted for errors without a code, as Railway omits it for some.
+func (e *GraphQLErrors) Status() int {
+	switch e.HTTPStatus {
+	case http.StatusUnauthorized, http.StatusTooManyRequests:
+		return e.HTTPStatus
+	}
+
+	statuses := make(map[int]bool)
+	for _, err := range e.Errors {
+		statuses[graphQLErrorStatus(err)] = true
+	}
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests, http.StatusNotFound} {
+		if statuses[status] {
+			return status
+		}
+	}
+	return http.StatusBadGateway
+}
+
+func graphQLErrorStatus(err GraphQLError) int {
+	if code := strings.ToUpper(err.Code()); code != "" {
+		switch code {
+		case "UNAUTHENTICATED", "UNAUTHORIZED":
+			return http.StatusUnauthorized
+		case "FORBIDDEN":
+			return http.StatusForbidden
+		case "RATE_LIMITED", "TOO_MANY_REQUESTS":
+			return http.StatusTooManyRequests
+		case "NOT_FOUND":
+			return http.StatusNotFound
+		}
+		return http.StatusBadGateway
+	}
+
+	message := strings.ToLower(err.Message)
+	switch {
+	case strings.Contains(message, "not authorized"), strings.Contains(message, "unauthenticated"):
+		return http.StatusUnauthorized
+	case strings.Contains(message, "rate limit"):
+		return http.StatusTooManyRequests
+	case strings.Contains(message, "not found"):
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
 }
 
 type Service struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Image       string `json:"image"`
-	NumReplicas int    `json:"numReplicas"`
+	ID                     string `json:"id"`
+	Name                   string `json:"name"`
+	Image                  string `json:"image"`
+	Repo                   string `json:"repo,omitempty"`
+	Branch                 string `json:"branch,omitempty"`
+	Commit                 string `json:"commit,omitempty"`
+	NumReplicas            int    `json:"numReplicas"`
+	LatestDeploymentID     string `json:"latestDeploymentId,omitempty"`
+	LatestDeploymentStatus string `json:"latestDeploymentStatus,omitempty"`
+}
+
+// Source returns what the service is deployed from: SourceImage, SourceRepo, or ""
+// for services without a source.
+func (s Service) Source() string {
+	switch {
+	case s.Image != "":
+		return SourceImage
+	case s.Repo != "":
+		return SourceRepo
+	}
+	return ""
 }
 
 func NewRailwayClient(token string, registryUser string, registryPass string) *RailwayClient {
 	return &RailwayClient{
-		token:                  token,
+		tokens:                 NewRailwayTokens(RailwayToken{Value: token, Type: TokenTypeTeam}),
+		apiURL:                 railwayAPIURL,
 		httpClient:             &http.Client{},
 		registryCredentialUser: registryUser,
 		registryCredentialPass: registryPass,
+		pollInterval:           defaultPollInterval,
+		locks:                  NewEnvironmentLocks(NewMemoryLocker()),
+		batchSize:              defaultBatchSize,
 	}
 }
 
-func (c *RailwayClient) doRequest(query string, variables map[string]interface{}) (json.RawMessage, error) {
+// doRequest sends a GraphQL request authorized by token and returns its data.
+// Operations are sent with execute, which encodes their typed variables and chooses
+// the token. When the response has errors, the partial data is returned along with
+// a *GraphQLErrors.
+func (c *RailwayClient) doRequest(token RailwayToken, operationName, query string, variables map[string]interface{}) (json.RawMessage, error) {
 	reqBody := GraphQLRequest{
-		Query:     query,
-		Variables: variables,
+		OperationName: operationName,
+		Query:         query,
+		Variables:     variables,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -58,16 +202,17 @@ func (c *RailwayClient) doRequest(query string, variables map[string]interface{}
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	// Debug logging
-	log.Printf("GraphQL Request: %s", string(jsonData))
+	// Debug logging, with secrets such as variable values redacted
+	logData, _ := json.Marshal(GraphQLRequest{OperationName: operationName, Query: query, Variables: redactVariables(variables)})
+	log.Printf("GraphQL Request: %s", string(logData))
 
-	req, err := http.NewRequest("POST", railwayAPIURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", c.apiURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	token.authorize(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -83,114 +228,93 @@ func (c *RailwayClient) doRequest(query string, variables map[string]interface{}
 	// Debug logging
 	log.Printf("GraphQL Response (Status %d): %s", resp.StatusCode, string(body))
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
-	}
-
 	var graphqlResp GraphQLResponse
 	if err := json.Unmarshal(body, &graphqlResp); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+		}
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
+	// Error statuses usually carry GraphQL errors too, e.g. a 429 when rate limited
 	if len(graphqlResp.Errors) > 0 {
-		return nil, fmt.Errorf("GraphQL error: %s", graphqlResp.Errors[0].Message)
+		return graphqlResp.Data, &GraphQLErrors{Operation: operationName, HTTPStatus: resp.StatusCode, Errors: graphqlResp.Errors}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
 	}
 
 	return graphqlResp.Data, nil
 }
 
 func (c *RailwayClient) GetServices(environmentID string) ([]Service, error) {
-	query := `
-		query Environment($environmentId: String!) {
-			environment(id: $environmentId) {
-				id
-				name
-				projectId
-				serviceInstances(after: null) {
-					edges {
-						node {
-							id
-							serviceId
-							serviceName
-							latestDeployment {
-								meta
-							}
-							source {
-								image
-								repo
-							}
-						}
-					}
-					pageInfo {
-						endCursor
-						hasNextPage
-						hasPreviousPage
-						startCursor
-					}
-				}
-			}
-		}
-	`
-
-	variables := map[string]interface{}{
-		"environmentId": environmentID,
-	}
-
-	data, err := c.doRequest(query, variables)
+	result, err := execute(c, environmentID, environmentServicesQuery, environmentVariables{EnvironmentID: environmentID})
 	if err != nil {
 		return nil, err
 	}
 
-	var result struct {
-		Environment struct {
-			ID               string `json:"id"`
-			Name             string `json:"name"`
-			ProjectID        string `json:"projectId"`
-			ServiceInstances struct {
-				Edges []struct {
-					Node struct {
-						ID               string `json:"id"`
-						ServiceID        string `json:"serviceId"`
-						ServiceName      string `json:"serviceName"`
-						LatestDeployment *struct {
-							Meta json.RawMessage `json:"meta"`
-						} `json:"latestDeployment"`
-						Source struct {
-							Image string `json:"image"`
-							Repo  string `json:"repo"`
-						} `json:"source"`
-					} `json:"node"`
-				} `json:"edges"`
-				PageInfo struct {
-					EndCursor       string `json:"endCursor"`
-					HasNextPage     bool   `json:"hasNextPage"`
-					HasPreviousPage bool   `json:"hasPreviousPage"`
-					StartCursor     string `json:"startCursor"`
-				} `json:"pageInfo"`
-			} `json:"serviceInstances"`
-		} `json:"environment"`
-	}
-
-	if err := json.Unmarshal(data, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse services: %w", err)
-	}
-
 	services := make([]Service, 0)
 	for _, edge := range result.Environment.ServiceInstances.Edges {
-		if edge.Node.Source.Image != "" {
-			replicas := resolveReplicaCount(edge.Node.ServiceName, edge.Node.LatestDeployment)
-			services = append(services, Service{
-				ID:          edge.Node.ServiceID,
-				Name:        edge.Node.ServiceName,
-				Image:       edge.Node.Source.Image,
-				NumReplicas: replicas,
-			})
+		service := Service{
+			ID:    edge.Node.ServiceID,
+			Name:  edge.Node.ServiceName,
+			Image: edge.Node.Source.Image,
+			Repo:  edge.Node.Source.Repo,
 		}
+
+		var meta *struct {
+			Meta json.RawMessage `json:"meta"`
+		}
+		if deployment := edge.Node.LatestDeployment; deployment != nil {
+			service.LatestDeploymentID = deployment.ID
+			service.LatestDeploymentStatus = deployment.Status
+			meta = &struct {
+				Meta json.RawMessage `json:"meta"`
+			}{Meta: deployment.Meta}
+			if service.Repo != "" {
+				service.Branch, service.Commit = deploymentCommit(deployment.Meta)
+			}
+		}
+		service.NumReplicas = resolveReplicaCount(edge.Node.ServiceName, meta)
+
+		services = append(services, service)
 	}
 
 	return services, nil
 }
 
+// decodeDeploymentMeta decodes a deployment's meta field, which can be either a JSON
+// object or a stringified JSON string (double-encoded).
+func decodeDeploymentMeta(raw json.RawMessage) (map[string]interface{}, error) {
+	var meta map[string]interface{}
+	err := json.Unmarshal(raw, &meta)
+	if err == nil {
+		return meta, nil
+	}
+
+	// meta might be a JSON string containing JSON — try double-decoding
+	var metaStr string
+	if strErr := json.Unmarshal(raw, &metaStr); strErr != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(metaStr), &meta); err != nil {
+		return nil, fmt.Errorf("failed to double-decode meta: %w", err)
+	}
+	return meta, nil
+}
+
+// deploymentCommit returns the git branch and commit a repo deployment was built
+// from, as recorded in its meta.
+func deploymentCommit(raw json.RawMessage) (branch, commit string) {
+	meta, err := decodeDeploymentMeta(raw)
+	if err != nil {
+		return "", ""
+	}
+	branch, _ = meta["branch"].(string)
+	commit, _ = meta["commitHash"].(string)
+	return branch, commit
+}
+
 // resolveReplicaCount extracts the replica count from the latest deployment meta.
 // It looks in meta.serviceManifest.deploy.multiRegionConfig for numReplicas values.
 // Falls back to 1 if no replica count is found.
@@ -204,20 +328,10 @@ func resolveReplicaCount(serviceName string, latestDeployment *struct {
 		return replicas
 	}
 
-	// The meta field can be either a JSON object or a stringified JSON string (double-encoded).
-	// Try direct unmarshal first, then handle the double-encoded case.
-	var meta map[string]interface{}
-	if err := json.Unmarshal(latestDeployment.Meta, &meta); err != nil {
-		// meta might be a JSON string containing JSON — try double-decoding
-		var metaStr string
-		if strErr := json.Unmarshal(latestDeployment.Meta, &metaStr); strErr != nil {
-			log.Printf("Failed to parse meta JSON for %s: %v", serviceName, err)
-			return replicas
-		}
-		if err2 := json.Unmarshal([]byte(metaStr), &meta); err2 != nil {
-			log.Printf("Failed to double-decode meta JSON for %s: %v", serviceName, err2)
-			return replicas
-		}
+	meta, err := decodeDeploymentMeta(latestDeployment.Meta)
+	if err != nil {
+		log.Printf("Failed to parse meta JSON for %s: %v", serviceName, err)
+		return replicas
 	}
 
 	// Navigate: meta.serviceManifest.deploy.multiRegionConfig.<region>.numReplicas
@@ -256,142 +370,383 @@ func resolveReplicaCount(serviceName string, latestDeployment *struct {
 	return replicas
 }
 
-func (c *RailwayClient) UpdateServiceImage(serviceID, environmentID, newImage string, numReplicas int) error {
-	// Step 1: Update the service instance image using ServiceInstanceUpdate
-	updateQuery := `
-		mutation ServiceInstanceUpdate($environmentId: String!, $serviceId: String!, $input: ServiceInstanceUpdateInput!) {
-			serviceInstanceUpdate(environmentId: $environmentId, serviceId: $serviceId, input: $input)
-		}
-	`
+// UpdateServiceImage points the service instance at newImage and deploys it.
+// It returns the ID of the triggered deployment.
+func (c *RailwayClient) UpdateServiceImage(serviceID, environmentID, newImage string, numReplicas int) (string, error) {
+	if err := c.updateServiceInstanceImage(serviceID, environmentID, newImage, numReplicas); err != nil {
+		return "", err
+	}
+	return c.deployServiceInstance(serviceID, environmentID)
+}
 
-	input := map[string]interface{}{
-		"source": map[string]interface{}{
-			"image": newImage,
-		},
-		"numReplicas": numReplicas,
+// updateServiceInstanceImage changes the image of a service instance without deploying it.
+func (c *RailwayClient) updateServiceInstanceImage(serviceID, environmentID, newImage string, numReplicas int) error {
+	return c.updateServiceInstanceSource(serviceID, environmentID, serviceSourceInput{Image: newImage}, numReplicas)
+}
+
+// updateServiceInstanceSource replaces a service instance's source, e.g. to switch a
+// repo service to an image by clearing its repo.
+func (c *RailwayClient) updateServiceInstanceSource(serviceID, environmentID string, source serviceSourceInput, numReplicas int) error {
+	_, err := execute(c, environmentID, serviceInstanceUpdateMutation, serviceInstanceUpdateVariables{
+		EnvironmentID: environmentID,
+		ServiceID:     serviceID,
+		Input:         c.serviceInstanceUpdateInput(source, numReplicas),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update service instance: %w", err)
+	}
+
+	return nil
+}
+
+// serviceInstanceUpdateInput builds the input that points a service instance at
+// source, with the configured registry credentials.
+func (c *RailwayClient) serviceInstanceUpdateInput(source serviceSourceInput, numReplicas int) serviceInstanceUpdateInput {
+	input := serviceInstanceUpdateInput{
+		Source:      source,
+		NumReplicas: numReplicas,
 	}
 
 	// Include registry credentials if configured
 	if c.registryCredentialUser != "" && c.registryCredentialPass != "" {
-		input["registryCredentials"] = map[string]interface{}{
-			"username": c.registryCredentialUser,
-			"password": c.registryCredentialPass,
+		input.RegistryCredentials = &registryCredentialsInput{
+			Username: c.registryCredentialUser,
+			Password: c.registryCredentialPass,
 		}
 	}
+	return input
+}
 
-	updateVariables := map[string]interface{}{
-		"environmentId": environmentID,
-		"serviceId":     serviceID,
-		"input":         input,
+// deployServiceInstance deploys a service instance using serviceInstanceDeployV2 and
+// returns the deployment ID.
+func (c *RailwayClient) deployServiceInstance(serviceID, environmentID string) (string, error) {
+	result, err := execute(c, environmentID, serviceInstanceDeployV2Mutation, serviceInstanceDeployV2Variables{
+		ServiceID:     serviceID,
+		EnvironmentID: environmentID,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to deploy service instance: %w", err)
 	}
 
-	_, err := c.doRequest(updateQuery, updateVariables)
+	return result.DeploymentID, nil
+}
+
+// GetDeploymentStatus returns the Railway status of a deployment, e.g. SUCCESS or FAILED.
+func (c *RailwayClient) GetDeploymentStatus(environmentID, deploymentID string) (string, error) {
+	result, err := execute(c, environmentID, deploymentQuery, deploymentVariables{ID: deploymentID})
 	if err != nil {
-		return fmt.Errorf("failed to update service instance: %w", err)
+		return "", err
 	}
 
-	// Step 2: Deploy the service using serviceInstanceDeploy
-	deployQuery := `
-		mutation ServiceInstanceDeploy($serviceId: String!, $environmentId: String!, $latestCommit: Boolean) {
-			serviceInstanceDeploy(serviceId: $serviceId, environmentId: $environmentId, latestCommit: $latestCommit)
-		}
-	`
+	return result.Deployment.Status, nil
+}
+
+// ServiceFailure attributes an error to a single service.
+type ServiceFailure struct {
+	ServiceName string `json:"service_name"`
+	Error       string `json:"error"`
+}
+
+// DeploymentError is returned by WaitForDeployments when one or more deployments
+// did not succeed.
+type DeploymentError struct {
+	Failures []ServiceFailure
+}
 
-	deployVariables := map[string]interface{}{
-		"serviceId":     serviceID,
-		"environmentId": environmentID,
-		"latestCommit":  false,
+func (e *DeploymentError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		msgs = append(msgs, failure.ServiceName+": "+failure.Error)
 	}
+	return fmt.Sprintf("%d deployment(s) did not succeed: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
 
-	_, err = c.doRequest(deployQuery, deployVariables)
-	if err != nil {
-		return fmt.Errorf("failed to deploy service instance: %w", err)
+// WaitForDeployments polls every update's deployment until each one has finished or
+// the timeout elapses. Failed, crashed and timed out deployments are collected into
+// a *DeploymentError.
+func (c *RailwayClient) WaitForDeployments(environmentID string, updates []ServiceUpdate, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	pending := make([]ServiceUpdate, 0, len(updates))
+	for _, update := range updates {
+		if update.DeploymentID != "" {
+			pending = append(pending, update)
+		}
 	}
 
-	return nil
-}
+	var failures []ServiceFailure
+	for len(pending) > 0 {
+		remaining := pending[:0]
+		for _, update := range pending {
+			status, err := c.GetDeploymentStatus(environmentID, update.DeploymentID)
+			if err != nil {
+				return fmt.Errorf("failed to get deployment status for %s: %w", update.ServiceName, err)
+			}
 
-func (c *RailwayClient) getProjectID(environmentID string) (string, error) {
-	query := `
-		query Environment($environmentId: String!) {
-			environment(id: $environmentId) {
-				projectId
+			switch status {
+			case "SUCCESS", "SLEEPING":
+				log.Printf("Deployment %s for %s finished with status %s", update.DeploymentID, update.ServiceName, status)
+			case "FAILED", "CRASHED", "REMOVED", "SKIPPED":
+				log.Printf("Deployment %s for %s finished with status %s", update.DeploymentID, update.ServiceName, status)
+				failures = append(failures, ServiceFailure{
+					ServiceName: update.ServiceName,
+					Error:       fmt.Sprintf("deployment %s finished with status %s", update.DeploymentID, status),
+				})
+			default:
+				remaining = append(remaining, update)
 			}
 		}
-	`
+		pending = remaining
+
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			for _, update := range pending {
+				failures = append(failures, ServiceFailure{
+					ServiceName: update.ServiceName,
+					Error:       fmt.Sprintf("timed out after %s waiting for deployment %s", timeout, update.DeploymentID),
+				})
+			}
+			break
+		}
+		time.Sleep(c.pollInterval)
+	}
+
+	if len(failures) > 0 {
+		return &DeploymentError{Failures: failures}
+	}
+	return nil
+}
 
-	variables := map[string]interface{}{
-		"environmentId": environmentID,
+func (c *RailwayClient) getProjectID(environmentID string) (string, error) {
+	if c.projectID != "" {
+		return c.projectID, nil
+	}
+	if len(c.tokens.Projects) > 0 {
+		return c.environmentProject(environmentID)
 	}
 
-	data, err := c.doRequest(query, variables)
+	result, err := execute(c, environmentID, environmentProjectQuery, environmentVariables{EnvironmentID: environmentID})
 	if err != nil {
 		return "", err
 	}
 
-	var result struct {
-		Environment struct {
-			ProjectID string `json:"projectId"`
-		} `json:"environment"`
+	return result.Environment.ProjectID, nil
+}
+
+// ServiceUpdate records a single service whose image was changed and redeployed.
+type ServiceUpdate struct {
+	ServiceID     string `json:"service_id"`
+	ServiceName   string `json:"service_name"`
+	PreviousImage string `json:"previous_image"`
+	NewImage      string `json:"new_image"`
+	DeploymentID  string `json:"deployment_id,omitempty"`
+	NumReplicas   int    `json:"-"`
+}
+
+// UpdateOptions describes which services UpdateServices changes and how.
+type UpdateOptions struct {
+	Filter     ServiceFilter
+	NewVersion string
+
+	// Rollout updates matched services in batches, waiting for each batch to deploy
+	// successfully. Without it every matched service is updated at once.
+	Rollout *RolloutStrategy
+
+	// Variables are applied before each matched service is deployed.
+	Variables *VariableChanges
+
+	// Force redeploys services that already run the new image. Without it they
+	// are reported as unchanged.
+	Force bool
+
+	// Owner describes the caller in the environment lock, e.g. "PUT /update".
+	// LockWait is how long to queue behind another update of the environment
+	// before failing with a *LockedError.
+	Owner    string
+	LockWait time.Duration
+
+	// ProjectID is the project of the environment, as given by the caller. Its
+	// project token is used directly; without it the project is looked up.
+	ProjectID string
+}
+
+// UpdateResult is the outcome of UpdateServices for one environment.
+type UpdateResult struct {
+	EnvironmentID string           `json:"environment_id"`
+	Updated       []ServiceUpdate  `json:"updated_services"`
+	Unchanged     []string         `json:"unchanged_services,omitempty"`
+	Failed        []ServiceFailure `json:"failed_services,omitempty"`
+	RolledBack    []string         `json:"rolled_back_services,omitempty"`
+}
+
+// UpdatedNames returns the names of the updated services in update order.
+func (r *UpdateResult) UpdatedNames() []string {
+	names := make([]string, 0, len(r.Updated))
+	for _, update := range r.Updated {
+		names = append(names, update.ServiceName)
 	}
+	return names
+}
 
-	if err := json.Unmarshal(data, &result); err != nil {
-		return "", fmt.Errorf("failed to parse project ID: %w", err)
+// UpdateServices updates every service in the environment selected by opts.Filter to
+// opts.NewVersion while holding the environment's lock. On error the returned result
+// still lists the services updated so far.
+func (c *RailwayClient) UpdateServices(environmentID string, opts UpdateOptions) (*UpdateResult, error) {
+	c = c.forProject(opts.ProjectID)
+	release, err := c.locks.Acquire(environmentID, opts.Owner, opts.NewVersion, opts.LockWait)
+	if err != nil {
+		return &UpdateResult{EnvironmentID: environmentID, Updated: make([]ServiceUpdate, 0)}, err
 	}
+	defer release()
 
-	return result.Environment.ProjectID, nil
+	start := time.Now()
+	result, err := c.updateServices(environmentID, opts)
+
+	if c.notifier != nil && (err != nil || len(result.Updated) > 0 || len(result.Failed) > 0) {
+		summary := newUpdateSummary(result, opts.NewVersion, err, time.Since(start))
+		if notifyErr := c.notifier.Notify(summary); notifyErr != nil {
+			log.Printf("Failed to send update notification: %v", notifyErr)
+		}
+	}
+
+	return result, err
 }
 
-func (c *RailwayClient) UpdateServices(environmentID string, imagePrefixes []string, newVersion string) ([]string, error) {
+func (c *RailwayClient) updateServices(environmentID string, opts UpdateOptions) (*UpdateResult, error) {
+	result := &UpdateResult{
+		EnvironmentID: environmentID,
+		Updated:       make([]ServiceUpdate, 0),
+	}
+
 	services, err := c.GetServices(environmentID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get services: %w", err)
+		return result, fmt.Errorf("failed to get services: %w", err)
 	}
 
-	updatedServices := make([]string, 0)
-
+	matched := make([]Service, 0)
 	for _, service := range services {
-		// Check if service image matches any of the prefixes
-		matched := false
-		var imagePrefix string
-		for _, prefix := range imagePrefixes {
-			if strings.HasPrefix(service.Image, prefix) {
-				matched = true
-				imagePrefix = prefix
-				break
-			}
+		if !opts.Filter.Matches(service) {
+			continue
 		}
+		if opts.unchanged(service) {
+			log.Printf("Service %s already runs %s, skipping", service.Name, service.Image)
+			result.Unchanged = append(result.Unchanged, service.Name)
+			continue
+		}
+		matched = append(matched, service)
+	}
 
-		if !matched {
+	if len(matched) > 0 && opts.Variables != nil {
+		if err := c.prepareVariables(environmentID, matched, &opts); err != nil {
+			return result, err
+		}
+	}
+
+	if opts.Rollout != nil {
+		return result, c.rollOut(environmentID, matched, opts, result)
+	}
+
+	updates, failures, err := c.updateServiceBatch(environmentID, matched, opts)
+	result.Updated = append(result.Updated, updates...)
+	result.Failed = append(result.Failed, failures...)
+	return result, err
+}
+
+// unchanged reports whether the service already runs the image it would be updated
+// to. Services with variable changes always need a deployment to pick them up.
+func (opts UpdateOptions) unchanged(service Service) bool {
+	if opts.Force || opts.Variables != nil {
+		return false
+	}
+	return service.Image == parseImageRef(service.Image).WithTag(opts.NewVersion)
+}
+
+// PlanUpdate returns the updates UpdateServices would make with opts without
+// changing anything. Unchanged services are left out.
+func (c *RailwayClient) PlanUpdate(environmentID string, opts UpdateOptions) ([]ServiceUpdate, error) {
+	services, err := c.forProject(opts.ProjectID).GetServices(environmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get services: %w", err)
+	}
+
+	planned := make([]ServiceUpdate, 0)
+	for _, service := range services {
+		if !opts.Filter.Matches(service) || opts.unchanged(service) {
 			continue
 		}
+		planned = append(planned, ServiceUpdate{
+			ServiceID:     service.ID,
+			ServiceName:   service.Name,
+			PreviousImage: service.Image,
+			NewImage:      parseImageRef(service.Image).WithTag(opts.NewVersion),
+			NumReplicas:   service.NumReplicas,
+		})
+	}
 
-		// Extract the image name without tag
-		imageParts := strings.Split(service.Image, ":")
-		var newImage string
-		if len(imageParts) > 1 {
-			// Has a tag, replace it
-			newImage = imageParts[0] + ":" + newVersion
-		} else {
-			// No tag, add it
-			newImage = service.Image + ":" + newVersion
+	return planned, nil
+}
+
+// prepareVariables checks that every per-service variable set targets a matched
+// service, resolves the project ID and applies the shared variables.
+func (c *RailwayClient) prepareVariables(environmentID string, matched []Service, opts *UpdateOptions) error {
+	for name := range opts.Variables.Services {
+		found := false
+		for _, service := range matched {
+			if service.Name == name {
+				found = true
+				break
+			}
 		}
+		if !found {
+			return fmt.Errorf("variables for service %s: service is not selected by the filter", name)
+		}
+	}
 
-		// Ensure we're still using the same prefix (in case the image has registry path)
-		if !strings.HasPrefix(newImage, imagePrefix) {
-			// Try with the prefix directly
-			newImage = imagePrefix + ":" + newVersion
+	if opts.ProjectID == "" {
+		projectID, err := c.getProjectID(environmentID)
+		if err != nil {
+			return fmt.Errorf("failed to get project ID: %w", err)
 		}
+		opts.ProjectID = projectID
+	}
+
+	if err := c.applyVariables(opts.ProjectID, environmentID, "", opts.Variables.Shared); err != nil {
+		return fmt.Errorf("failed to apply shared variables: %w", err)
+	}
+
+	return nil
+}
 
-		log.Printf("Updating service %s from %s to %s (replicas=%d)", service.Name, service.Image, newImage, service.NumReplicas)
+// updateService moves a single service to opts.NewVersion, applies its variable
+// changes and triggers a single deployment.
+func (c *RailwayClient) updateService(environmentID string, service Service, opts UpdateOptions) (ServiceUpdate, error) {
+	// Replace the tag (or digest) on the parsed repository so registry ports survive
+	newImage := parseImageRef(service.Image).WithTag(opts.NewVersion)
 
-		// Update the service and trigger deployment
-		if err := c.UpdateServiceImage(service.ID, environmentID, newImage, service.NumReplicas); err != nil {
-			return updatedServices, fmt.Errorf("failed to update service %s: %w", service.Name, err)
+	log.Printf("Updating service %s from %s to %s (replicas=%d)", service.Name, service.Image, newImage, service.NumReplicas)
+
+	if err := c.updateServiceInstanceImage(service.ID, environmentID, newImage, service.NumReplicas); err != nil {
+		return ServiceUpdate{}, fmt.Errorf("failed to update service %s: %w", service.Name, err)
+	}
+
+	if opts.Variables != nil {
+		if err := c.applyVariables(opts.ProjectID, environmentID, service.ID, opts.Variables.Services[service.Name]); err != nil {
+			return ServiceUpdate{}, fmt.Errorf("failed to update variables for service %s: %w", service.Name, err)
 		}
+	}
 
-		updatedServices = append(updatedServices, service.Name)
+	deploymentID, err := c.deployServiceInstance(service.ID, environmentID)
+	if err != nil {
+		return ServiceUpdate{}, fmt.Errorf("failed to update service %s: %w", service.Name, err)
 	}
 
-	return updatedServices, nil
+	return ServiceUpdate{
+		ServiceID:     service.ID,
+		ServiceName:   service.Name,
+		PreviousImage: service.Image,
+		NewImage:      newImage,
+		DeploymentID:  deploymentID,
+		NumReplicas:   service.NumReplicas,
+	}, nil
 }
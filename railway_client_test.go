@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var operationNamePattern = regexp.MustCompile(`(?:query|mutation)\s+(\w+)`)
+
+// fakeRailway is a stub of the Railway GraphQL API. Each call is routed to handle by
+// operation name and the returned value is encoded as the response data.
+type fakeRailway struct {
+	mu     sync.Mutex
+	calls  []fakeCall
+	handle func(operation string, variables map[string]interface{}) (interface{}, error)
+}
+
+type fakeCall struct {
+	Operation string
+	Variables map[string]interface{}
+}
+
+func newFakeRailway(t *testing.T, handle func(operation string, variables map[string]interface{}) (interface{}, error)) (*fakeRailway, *RailwayClient) {
+	t.Helper()
+
+	fake := &fakeRailway{handle: handle}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GraphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		operation := ""
+		if m := operationNamePattern.FindStringSubmatch(req.Query); m != nil {
+			operation = m[1]
+		}
+
+		fake.mu.Lock()
+		fake.calls = append(fake.calls, fakeCall{Operation: operation, Variables: req.Variables})
+		fake.mu.Unlock()
+
+		// A *GraphQLErrors is sent as is, with its HTTP status
+		data, err := fake.handle(operation, req.Variables)
+		resp := map[string]interface{}{"data": data}
+		var graphQLErrs *GraphQLErrors
+		switch {
+		case errors.As(err, &graphQLErrs):
+			resp["errors"] = graphQLErrs.Errors
+			if graphQLErrs.HTTPStatus != 0 {
+				w.WriteHeader(graphQLErrs.HTTPStatus)
+			}
+		case err != nil:
+			resp["errors"] = []map[string]interface{}{{"message": err.Error()}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewRailwayClient("test-token", "", "")
+	client.apiURL = server.URL
+	client.pollInterval = time.Millisecond
+	return fake, client
+}
+
+// operations returns the operation names received so far, in order.
+func (f *fakeRailway) operations() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ops := make([]string, 0, len(f.calls))
+	for _, call := range f.calls {
+		ops = append(ops, call.Operation)
+	}
+	return ops
+}
+
+// environmentData builds a GetServices response for the given service ID/name/image triples.
+func environmentData(services ...[3]string) map[string]interface{} {
+	edges := make([]interface{}, 0, len(services))
+	for _, s := range services {
+		edges = append(edges, map[string]interface{}{
+			"node": map[string]interface{}{
+				"serviceId":   s[0],
+				"serviceName": s[1],
+				"source":      map[string]interface{}{"image": s[2]},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"environment": map[string]interface{}{
+			"projectId":        "project-1",
+			"serviceInstances": map[string]interface{}{"edges": edges},
+		},
+	}
+}
+
+func TestUpdateServices(t *testing.T) {
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData(
+				[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-2", "web", "ghcr.io/returnearly/web:v1"},
+			), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-" + variables["serviceId"].(string)}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+
+	filter := ServiceFilter{Matchers: []ImageMatcher{{Repository: "ghcr.io/returnearly/api"}}}
+	if err := filter.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.UpdateServices("env-1", UpdateOptions{Filter: filter, NewVersion: "v2"})
+	if err != nil {
+		t.Fatalf("UpdateServices returned error: %v", err)
+	}
+
+	if len(result.Updated) != 1 {
+		t.Fatalf("Expected 1 updated service, got %d", len(result.Updated))
+	}
+	update := result.Updated[0]
+	if update.NewImage != "ghcr.io/returnearly/api:v2" || update.DeploymentID != "deploy-svc-1" {
+		t.Errorf("Unexpected update %+v", update)
+	}
+
+	expected := []string{"Environment", "ServiceInstanceUpdate", "ServiceInstanceDeployV2"}
+	if ops := fake.operations(); len(ops) != len(expected) {
+		t.Errorf("Expected operations %v, got %v", expected, ops)
+	}
+}
+
+func TestUpdateServices_SkipsUnchanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      UpdateOptions
+		updated   []string
+		unchanged []string
+	}{
+		{"already on version", UpdateOptions{}, []string{"web"}, []string{"api"}},
+		{"force", UpdateOptions{Force: true}, []string{"api", "web"}, nil},
+		{"variable changes", UpdateOptions{Variables: &VariableChanges{}}, []string{"api", "web"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+				switch operation {
+				case "Environment":
+					return environmentData(
+						[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v2"},
+						[3]string{"svc-2", "web", "ghcr.io/returnearly/web:v1"},
+					), nil
+				case "ServiceInstanceDeployV2":
+					return map[string]interface{}{"serviceInstanceDeployV2": "deploy-" + variables["serviceId"].(string)}, nil
+				}
+				return map[string]interface{}{}, nil
+			})
+
+			opts := tt.opts
+			opts.Filter = ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/"}}
+			opts.NewVersion = "v2"
+
+			result, err := client.UpdateServices("env-1", opts)
+			if err != nil {
+				t.Fatalf("UpdateServices returned error: %v", err)
+			}
+			if updated := result.UpdatedNames(); strings.Join(updated, ",") != strings.Join(tt.updated, ",") {
+				t.Errorf("Expected updated %v, got %v", tt.updated, updated)
+			}
+			if strings.Join(result.Unchanged, ",") != strings.Join(tt.unchanged, ",") {
+				t.Errorf("Expected unchanged %v, got %v", tt.unchanged, result.Unchanged)
+			}
+		})
+	}
+}
+
+func TestDoRequest_GraphQLErrors(t *testing.T) {
+	_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		return nil, &GraphQLErrors{Errors: []GraphQLError{
+			{Message: "Environment not found", Path: []interface{}{"environment"}, Extensions: map[string]interface{}{"code": "NOT_FOUND"}},
+			{Message: "Problem processing request", Path: []interface{}{"environment", "serviceInstances", 0}},
+		}}
+	})
+
+	_, err := client.GetServices("env-1")
+	var graphQLErrs *GraphQLErrors
+	if !errors.As(err, &graphQLErrs) {
+		t.Fatalf("Expected *GraphQLErrors, got %v", err)
+	}
+	if graphQLErrs.Operation != "Environment" || len(graphQLErrs.Errors) != 2 {
+		t.Fatalf("Expected both errors of Environment, got %+v", graphQLErrs)
+	}
+	if code := graphQLErrs.Errors[0].Code(); code != "NOT_FOUND" {
+		t.Errorf("Expected code NOT_FOUND, got %q", code)
+	}
+	expected := "GraphQL error in Environment: Environment not found (path environment, code NOT_FOUND); Problem processing request (path environment.serviceInstances.0)"
+	if err.Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestGraphQLErrors_Status(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      GraphQLErrors
+		expected int
+	}{
+		{"unauthenticated code", GraphQLErrors{Errors: []GraphQLError{{Message: "Bad token", Extensions: map[string]interface{}{"code": "UNAUTHENTICATED"}}}}, http.StatusUnauthorized},
+		{"not authorized message", GraphQLErrors{Errors: []GraphQLError{{Message: "Not Authorized"}}}, http.StatusUnauthorized},
+		{"forbidden", GraphQLErrors{Errors: []GraphQLError{{Message: "No access", Extensions: map[string]interface{}{"code": "FORBIDDEN"}}}}, http.StatusForbidden},
+		{"rate limited code", GraphQLErrors{Errors: []GraphQLError{{Message: "Slow down", Extensions: map[string]interface{}{"code": "RATE_LIMITED"}}}}, http.StatusTooManyRequests},
+		{"rate limited status", GraphQLErrors{HTTPStatus: http.StatusTooManyRequests, Errors: []GraphQLError{{Message: "Slow down"}}}, http.StatusTooManyRequests},
+		{"not found", GraphQLErrors{Errors: []GraphQLError{{Message: "Service not found"}}}, http.StatusNotFound},
+		{"auth wins over not found", GraphQLErrors{Errors: []GraphQLError{{Message: "Service not found"}, {Message: "Not Authorized"}}}, http.StatusUnauthorized},
+		{"other code", GraphQLErrors{Errors: []GraphQLError{{Message: "Service not found", Extensions: map[string]interface{}{"code": "INTERNAL_SERVER_ERROR"}}}}, http.StatusBadGateway},
+		{"unknown", GraphQLErrors{Errors: []GraphQLError{{Message: "Problem processing request"}}}, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := tt.err.Status(); status != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, status)
+			}
+		})
+	}
+}
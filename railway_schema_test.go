@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+var updateSchema = flag.Bool("update-schema", false, "refresh "+railwaySchemaFile+" from the Railway API")
+
+const introspectionQuery = `
+	query IntrospectionQuery {
+		__schema {
+			queryType { name }
+			mutationType { name }
+			subscriptionType { name }
+			types { ...FullType }
+		}
+	}
+
+	fragment FullType on __Type {
+		kind
+		name
+		fields(includeDeprecated: true) {
+			name
+			args { ...InputValue }
+			type { ...TypeRef }
+		}
+		inputFields { ...InputValue }
+		interfaces { ...TypeRef }
+		enumValues(includeDeprecated: true) { name }
+		possibleTypes { ...TypeRef }
+	}
+
+	fragment InputValue on __InputValue {
+		name
+		type { ...TypeRef }
+		defaultValue
+	}
+
+	fragment TypeRef on __Type {
+		kind
+		name
+		ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } }
+	}
+`
+
+type introspectionTypeRef struct {
+	Kind   string                `json:"kind"`
+	Name   string                `json:"name"`
+	OfType *introspectionTypeRef `json:"ofType"`
+}
+
+func (r *introspectionTypeRef) String() string {
+	switch r.Kind {
+	case "NON_NULL":
+		return r.OfType.String() + "!"
+	case "LIST":
+		return "[" + r.OfType.String() + "]"
+	}
+	return r.Name
+}
+
+type introspectionInputValue struct {
+	Name         string               `json:"name"`
+	Type         introspectionTypeRef `json:"type"`
+	DefaultValue *string              `json:"defaultValue"`
+}
+
+func (v introspectionInputValue) String() string {
+	s := v.Name + ": " + v.Type.String()
+	if v.DefaultValue != nil {
+		s += " = " + *v.DefaultValue
+	}
+	return s
+}
+
+type introspectionType struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Fields []struct {
+		Name string                    `json:"name"`
+		Args []introspectionInputValue `json:"args"`
+		Type introspectionTypeRef      `json:"type"`
+	} `json:"fields"`
+	InputFields   []introspectionInputValue `json:"inputFields"`
+	Interfaces    []introspectionTypeRef    `json:"interfaces"`
+	EnumValues    []struct{ Name string }   `json:"enumValues"`
+	PossibleTypes []introspectionTypeRef    `json:"possibleTypes"`
+}
+
+type introspectionSchema struct {
+	QueryType        *struct{ Name string } `json:"queryType"`
+	MutationType     *struct{ Name string } `json:"mutationType"`
+	SubscriptionType *struct{ Name string } `json:"subscriptionType"`
+	Types            []introspectionType    `json:"types"`
+}
+
+// printSchema renders an introspected schema as SDL. Types, fields and enum values are
+// sorted by name so refreshes diff cleanly. Built-in scalars and introspection types
+// are left out, as gqlparser predeclares them.
+func printSchema(schema introspectionSchema) string {
+	var b strings.Builder
+	b.WriteString("# Railway public API schema (" + railwayAPIURL + "), generated by\n")
+	b.WriteString("# RAILWAY_API_TOKEN=... go test -run TestRailwaySchema -update-schema\n")
+
+	roots := []string{}
+	for _, root := range []struct {
+		operation string
+		typ       *struct{ Name string }
+	}{{"query", schema.QueryType}, {"mutation", schema.MutationType}, {"subscription", schema.SubscriptionType}} {
+		if root.typ != nil {
+			roots = append(roots, fmt.Sprintf("  %s: %s\n", root.operation, root.typ.Name))
+		}
+	}
+	b.WriteString("\nschema {\n" + strings.Join(roots, "") + "}\n")
+
+	sort.Slice(schema.Types, func(i, j int) bool { return schema.Types[i].Name < schema.Types[j].Name })
+	for _, typ := range schema.Types {
+		sort.Slice(typ.Fields, func(i, j int) bool { return typ.Fields[i].Name < typ.Fields[j].Name })
+		sort.Slice(typ.InputFields, func(i, j int) bool { return typ.InputFields[i].Name < typ.InputFields[j].Name })
+		sort.Slice(typ.EnumValues, func(i, j int) bool { return typ.EnumValues[i].Name < typ.EnumValues[j].Name })
+		if _, builtin := leafKinds[typ.Name]; builtin || strings.HasPrefix(typ.Name, "__") {
+			continue
+		}
+		b.WriteString("\n")
+		switch typ.Kind {
+		case "SCALAR":
+			fmt.Fprintf(&b, "scalar %s\n", typ.Name)
+		case "ENUM":
+			fmt.Fprintf(&b, "enum %s {\n", typ.Name)
+			for _, value := range typ.EnumValues {
+				fmt.Fprintf(&b, "  %s\n", value.Name)
+			}
+			b.WriteString("}\n")
+		case "UNION":
+			members := make([]string, 0, len(typ.PossibleTypes))
+			for _, member := range typ.PossibleTypes {
+				members = append(members, member.Name)
+			}
+			fmt.Fprintf(&b, "union %s = %s\n", typ.Name, strings.Join(members, " | "))
+		case "INPUT_OBJECT":
+			fmt.Fprintf(&b, "input %s {\n", typ.Name)
+			for _, field := range typ.InputFields {
+				fmt.Fprintf(&b, "  %s\n", field)
+			}
+			b.WriteString("}\n")
+		case "OBJECT", "INTERFACE":
+			keyword := "type"
+			if typ.Kind == "INTERFACE" {
+				keyword = "interface"
+			}
+			fmt.Fprintf(&b, "%s %s", keyword, typ.Name)
+			if len(typ.Interfaces) > 0 {
+				names := make([]string, 0, len(typ.Interfaces))
+				for _, iface := range typ.Interfaces {
+					names = append(names, iface.Name)
+				}
+				fmt.Fprintf(&b, " implements %s", strings.Join(names, " & "))
+			}
+			b.WriteString(" {\n")
+			for _, field := range typ.Fields {
+				args := ""
+				if len(field.Args) > 0 {
+					params := make([]string, 0, len(field.Args))
+					for _, arg := range field.Args {
+						params = append(params, arg.String())
+					}
+					args = "(" + strings.Join(params, ", ") + ")"
+				}
+				fmt.Fprintf(&b, "  %s%s: %s\n", field.Name, args, field.Type.String())
+			}
+			b.WriteString("}\n")
+		}
+	}
+	return b.String()
+}
+
+// TestRailwaySchema refreshes the vendored schema from an introspection query when
+// run with -update-schema.
+func TestRailwaySchema(t *testing.T) {
+	if !*updateSchema {
+		t.Skip("run with -update-schema to refresh " + railwaySchemaFile)
+	}
+
+	body, err := json.Marshal(GraphQLRequest{Query: introspectionQuery})
+	if err != nil {
+		t.Fatalf("Failed to marshal introspection query: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, railwayAPIURL, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("RAILWAY_API_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to introspect the Railway API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Data struct {
+			Schema introspectionSchema `json:"__schema"`
+		} `json:"data"`
+		Errors []GraphQLError `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode introspection response (status %d): %v", resp.StatusCode, err)
+	}
+	if len(result.Errors) > 0 || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Introspection failed with status %d: %v", resp.StatusCode, result.Errors)
+	}
+
+	sdl := printSchema(result.Data.Schema)
+	if _, err := gqlparser.LoadSchema(&ast.Source{Name: railwaySchemaFile, Input: sdl}); err != nil {
+		t.Fatalf("Introspected schema does not load: %v", err)
+	}
+	if err := os.WriteFile(railwaySchemaFile, []byte(sdl), 0o644); err != nil {
+		t.Fatalf("Failed to write schema: %v", err)
+	}
+	t.Logf("Wrote %s", railwaySchemaFile)
+}
+
+func TestPrintSchema(t *testing.T) {
+	var schema introspectionSchema
+	err := json.Unmarshal([]byte(`{
+		"queryType": {"name": "Query"},
+		"mutationType": {"name": "Mutation"},
+		"types": [
+			{"kind": "OBJECT", "name": "Query", "fields": [
+				{"name": "environment", "args": [{"name": "id", "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "String"}}}],
+				 "type": {"kind": "NON_NULL", "ofType": {"kind": "OBJECT", "name": "Environment"}}}
+			]},
+			{"kind": "OBJECT", "name": "Mutation", "fields": [
+				{"name": "deploy", "args": [
+					{"name": "input", "type": {"kind": "INPUT_OBJECT", "name": "DeployInput"}},
+					{"name": "retries", "type": {"kind": "SCALAR", "name": "Int"}, "defaultValue": "3"}
+				], "type": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "Boolean"}}}
+			]},
+			{"kind": "INTERFACE", "name": "Node", "fields": [{"name": "id", "args": [], "type": {"kind": "SCALAR", "name": "ID"}}]},
+			{"kind": "OBJECT", "name": "Environment", "interfaces": [{"kind": "INTERFACE", "name": "Node"}], "fields": [
+				{"name": "id", "args": [], "type": {"kind": "SCALAR", "name": "ID"}},
+				{"name": "status", "args": [], "type": {"kind": "ENUM", "name": "Status"}},
+				{"name": "tags", "args": [], "type": {"kind": "LIST", "ofType": {"kind": "NON_NULL", "ofType": {"kind": "SCALAR", "name": "String"}}}}
+			]},
+			{"kind": "ENUM", "name": "Status", "enumValues": [{"name": "ACTIVE"}, {"name": "DELETED"}]},
+			{"kind": "INPUT_OBJECT", "name": "DeployInput", "inputFields": [{"name": "meta", "type": {"kind": "SCALAR", "name": "JSON"}}]},
+			{"kind": "SCALAR", "name": "JSON"},
+			{"kind": "UNION", "name": "Result", "possibleTypes": [{"kind": "OBJECT", "name": "Environment"}]},
+			{"kind": "SCALAR", "name": "String"},
+			{"kind": "OBJECT", "name": "__Type", "fields": []}
+		]
+	}`), &schema)
+	if err != nil {
+		t.Fatalf("Failed to decode schema: %v", err)
+	}
+
+	sdl := printSchema(schema)
+	loaded, err := gqlparser.LoadSchema(&ast.Source{Name: "printed.graphql", Input: sdl})
+	if err != nil {
+		t.Fatalf("Printed schema does not load: %v\n%s", err, sdl)
+	}
+
+	for _, expected := range []string{
+		"deploy(input: DeployInput, retries: Int = 3): Boolean!",
+		"type Environment implements Node {",
+		"tags: [String!]",
+		"union Result = Environment",
+	} {
+		if !strings.Contains(sdl, expected) {
+			t.Errorf("Expected the schema to contain %q, got:\n%s", expected, sdl)
+		}
+	}
+	if field := loaded.Query.Fields.ForName("environment"); field == nil || field.Type.String() != "Environment!" {
+		t.Errorf("Expected Query.environment to return Environment!, got %v", field)
+	}
+	if strings.Contains(sdl, "__Type") || strings.Contains(sdl, "scalar String") {
+		t.Errorf("Expected built-in types to be left out, got:\n%s", sdl)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Railway token types. Account and team tokens are sent as "Authorization: Bearer";
+// project tokens, which are scoped to one project environment, as
+// "Project-Access-Token".
+const (
+	TokenTypeAccount = "account"
+	TokenTypeTeam    = "team"
+	TokenTypeProject = "project"
+)
+
+// RailwayToken is a Railway API token and its type.
+type RailwayToken struct {
+	Value string
+	Type  string
+}
+
+// validateTokenType checks that typ is a known token type.
+func validateTokenType(typ string) error {
+	switch typ {
+	case TokenTypeAccount, TokenTypeTeam, TokenTypeProject:
+		return nil
+	}
+	return fmt.Errorf("unknown token type %q: must be %s, %s or %s", typ, TokenTypeAccount, TokenTypeTeam, TokenTypeProject)
+}
+
+// authorize sets the header that carries the token.
+func (t RailwayToken) authorize(req *http.Request) {
+	if t.Type == TokenTypeProject {
+		req.Header.Set("Project-Access-Token", t.Value)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+t.Value)
+}
+
+// RailwayTokens holds the default token and the project tokens the client chooses
+// from. Requests in an environment whose project has a token use it; the others use
+// the default token.
+type RailwayTokens struct {
+	Default  RailwayToken
+	Projects map[string]string
+
+	mu sync.Mutex
+	// environments caches the project of each environment resolved so far.
+	environments map[string]string
+}
+
+// NewRailwayTokens returns tokens with the given default token and no project tokens.
+func NewRailwayTokens(defaultToken RailwayToken) *RailwayTokens {
+	return &RailwayTokens{
+		Default:      defaultToken,
+		Projects:     make(map[string]string),
+		environments: make(map[string]string),
+	}
+}
+
+// parseProjectTokens parses comma-separated projectID:token pairs.
+func parseProjectTokens(spec string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// Entries are not echoed in errors since they contain tokens
+		projectID, token, ok := strings.Cut(pair, ":")
+		if !ok || token == "" {
+			return nil, fmt.Errorf("invalid project token entry: expected project_id:token")
+		}
+		if _, err := uuid.Parse(projectID); err != nil {
+			return nil, fmt.Errorf("invalid project token entry: project_id must be a valid UUID")
+		}
+		if _, exists := tokens[projectID]; exists {
+			return nil, fmt.Errorf("duplicate project token for project %s", projectID)
+		}
+		tokens[projectID] = token
+	}
+	return tokens, nil
+}
+
+// forProject returns a client for environments of projectID, whose requests use
+// that project's token without looking up the project of each environment.
+func (c *RailwayClient) forProject(projectID string) *RailwayClient {
+	if projectID == "" || projectID == c.projectID {
+		return c
+	}
+	scoped := *c
+	scoped.projectID = projectID
+	return &scoped
+}
+
+// tokenFor returns the token for requests in the environment. Requests that are not
+// tied to an environment use the default token. Unless the client is for a known
+// project, the environment's project is looked up to find its token.
+func (c *RailwayClient) tokenFor(environmentID string) (RailwayToken, error) {
+	tokens := c.tokens
+	if environmentID != "" && len(tokens.Projects) > 0 {
+		projectID := c.projectID
+		if projectID == "" {
+			var err error
+			if projectID, err = c.environmentProject(environmentID); err != nil {
+				return RailwayToken{}, err
+			}
+		}
+		if token, ok := tokens.Projects[projectID]; ok {
+			return RailwayToken{Value: token, Type: TokenTypeProject}, nil
+		}
+	}
+
+	if tokens.Default.Value == "" {
+		if environmentID == "" {
+			return RailwayToken{}, fmt.Errorf("no default Railway token configured")
+		}
+		return RailwayToken{}, fmt.Errorf("no Railway token configured for environment %s", environmentID)
+	}
+	return tokens.Default, nil
+}
+
+// environmentProject returns the project of the environment. It is looked up with
+// the default token or, without one, with each project token in turn, since a
+// project token can only see its own project's environments.
+func (c *RailwayClient) environmentProject(environmentID string) (string, error) {
+	tokens := c.tokens
+	tokens.mu.Lock()
+	projectID, ok := tokens.environments[environmentID]
+	tokens.mu.Unlock()
+	if ok {
+		return projectID, nil
+	}
+
+	candidates := []RailwayToken{tokens.Default}
+	if tokens.Default.Value == "" {
+		candidates = candidates[:0]
+		projectIDs := make([]string, 0, len(tokens.Projects))
+		for id := range tokens.Projects {
+			projectIDs = append(projectIDs, id)
+		}
+		sort.Strings(projectIDs)
+		for _, id := range projectIDs {
+			candidates = append(candidates, RailwayToken{Value: tokens.Projects[id], Type: TokenTypeProject})
+		}
+	}
+
+	var lastErr error
+	for _, token := range candidates {
+		result, err := executeWithToken(c, token, environmentProjectQuery, environmentVariables{EnvironmentID: environmentID})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		projectID = result.Environment.ProjectID
+		log.Printf("Environment %s belongs to project %s", environmentID, projectID)
+
+		tokens.mu.Lock()
+		tokens.environments[environmentID] = projectID
+		tokens.mu.Unlock()
+		return projectID, nil
+	}
+	return "", fmt.Errorf("failed to resolve the project of environment %s: %w", environmentID, lastErr)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testProjectA = "550e8400-e29b-41d4-a716-44665544000a"
+	testProjectB = "550e8400-e29b-41d4-a716-44665544000b"
+	testProjectC = "550e8400-e29b-41d4-a716-44665544000c"
+)
+
+// tokenFake serves environments env-a, env-b and env-c of projects A, B and C. The
+// team token sees every environment; a project token only sees its project's.
+type tokenFake struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTokenFake(t *testing.T, tokens *RailwayTokens) (*tokenFake, *RailwayClient) {
+	t.Helper()
+	projects := map[string]string{"env-a": testProjectA, "env-b": testProjectB, "env-c": testProjectC}
+	projectTokens := map[string]string{"token-a": testProjectA, "token-b": testProjectB}
+
+	fake := &tokenFake{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GraphQLRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		credential := r.Header.Get("Authorization")
+		if token := r.Header.Get("Project-Access-Token"); token != "" {
+			credential = "Project-Access-Token " + token
+		}
+		environmentID, _ := req.Variables["environmentId"].(string)
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, environmentID+" "+credential)
+		fake.mu.Unlock()
+
+		projectID := projects[environmentID]
+		allowed := credential == "Bearer team-token"
+		if token, ok := strings.CutPrefix(credential, "Project-Access-Token "); ok {
+			allowed = projectTokens[token] == projectID
+		}
+		if !allowed {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data":   nil,
+				"errors": []map[string]interface{}{{"message": "Not Authorized"}},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"environment": map[string]interface{}{
+					"projectId":        projectID,
+					"serviceInstances": map[string]interface{}{"edges": []interface{}{}},
+				},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	client := NewRailwayClient("", "", "")
+	client.apiURL = server.URL
+	client.tokens = tokens
+	return fake, client
+}
+
+func TestRailwayTokens_Headers(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    RailwayToken
+		expected string
+	}{
+		{"team token", RailwayToken{Value: "team-token", Type: TokenTypeTeam}, "env-a Bearer team-token"},
+		{"account token", RailwayToken{Value: "team-token", Type: TokenTypeAccount}, "env-a Bearer team-token"},
+		{"project token", RailwayToken{Value: "token-a", Type: TokenTypeProject}, "env-a Project-Access-Token token-a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := newTokenFake(t, NewRailwayTokens(tt.token))
+
+			if _, err := client.GetServices("env-a"); err != nil {
+				t.Fatalf("GetServices returned error: %v", err)
+			}
+			if len(fake.requests) != 1 || fake.requests[0] != tt.expected {
+				t.Errorf("Expected request %q, got %v", tt.expected, fake.requests)
+			}
+		})
+	}
+}
+
+func TestRailwayTokens_ProjectTokens(t *testing.T) {
+	tests := []struct {
+		name         string
+		defaultToken RailwayToken
+		environment  string
+		expected     []string
+		expectError  bool
+	}{
+		{
+			name:        "project tokens only",
+			environment: "env-b",
+			expected: []string{
+				"env-b Project-Access-Token token-a",
+				"env-b Project-Access-Token token-b",
+				"env-b Project-Access-Token token-b",
+				"env-b Project-Access-Token token-b",
+			},
+		},
+		{
+			name:         "team token resolves the project",
+			defaultToken: RailwayToken{Value: "team-token", Type: TokenTypeTeam},
+			environment:  "env-a",
+			expected: []string{
+				"env-a Bearer team-token",
+				"env-a Project-Access-Token token-a",
+				"env-a Project-Access-Token token-a",
+			},
+		},
+		{
+			name:         "project without a token",
+			defaultToken: RailwayToken{Value: "team-token", Type: TokenTypeTeam},
+			environment:  "env-c",
+			expected: []string{
+				"env-c Bearer team-token",
+				"env-c Bearer team-token",
+				"env-c Bearer team-token",
+			},
+		},
+		{
+			name:        "no token for the project",
+			environment: "env-c",
+			expected: []string{
+				"env-c Project-Access-Token token-a",
+				"env-c Project-Access-Token token-b",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := NewRailwayTokens(tt.defaultToken)
+			tokens.Projects = map[string]string{testProjectA: "token-a", testProjectB: "token-b"}
+			fake, client := newTokenFake(t, tokens)
+
+			// The second call reuses the resolved project
+			for i := 0; i < 2; i++ {
+				_, err := client.GetServices(tt.environment)
+				if (err != nil) != tt.expectError {
+					t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+				}
+				if tt.expectError {
+					break
+				}
+			}
+
+			if strings.Join(fake.requests, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("Expected requests:\n%s\ngot:\n%s", strings.Join(tt.expected, "\n"), strings.Join(fake.requests, "\n"))
+			}
+		})
+	}
+}
+
+func TestRailwayTokens_RequestProject(t *testing.T) {
+	tests := []struct {
+		name         string
+		defaultToken RailwayToken
+		projectID    string
+		environment  string
+		expected     string
+	}{
+		{"project token", RailwayToken{}, testProjectB, "env-b", "env-b Project-Access-Token token-b"},
+		{"project without a token", RailwayToken{Value: "team-token", Type: TokenTypeTeam}, testProjectC, "env-c", "env-c Bearer team-token"},
+		// The environment is not probed with the other projects' tokens
+		{"environment of another project", RailwayToken{}, testProjectA, "env-b", "env-b Project-Access-Token token-a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := NewRailwayTokens(tt.defaultToken)
+			tokens.Projects = map[string]string{testProjectA: "token-a", testProjectB: "token-b"}
+			fake, client := newTokenFake(t, tokens)
+
+			client.UpdateServices(tt.environment, UpdateOptions{
+				Filter:     ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api"}},
+				NewVersion: "v2",
+				ProjectID:  tt.projectID,
+			})
+
+			if len(fake.requests) != 1 || fake.requests[0] != tt.expected {
+				t.Errorf("Expected request %q, got %v", tt.expected, fake.requests)
+			}
+		})
+	}
+}
+
+func TestParseProjectTokens(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        string
+		expected    int
+		expectError bool
+	}{
+		{"empty", "", 0, false},
+		{"two projects", testProjectA + ":token-a, " + testProjectB + ":token-b", 2, false},
+		{"missing token", testProjectA + ":", 0, true},
+		{"invalid project", "project:token-a", 0, true},
+		{"duplicate project", testProjectA + ":token-a," + testProjectA + ":token-b", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := parseProjectTokens(tt.spec)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("Expected error %v, got %v", tt.expectError, err)
+			}
+			if !tt.expectError && len(tokens) != tt.expected {
+				t.Errorf("Expected %d tokens, got %v", tt.expected, tokens)
+			}
+		})
+	}
+}
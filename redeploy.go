@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// commitSHAPattern matches full git commit SHAs. Railway deploys commitSha as given,
+// so abbreviated SHAs are refused rather than resolved.
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// RepoFilter selects repo services by the repo and branch they track. Repos,
+// Branches and ServiceNames are globs; every group that is provided must match.
+// ExcludeNames always wins.
+type RepoFilter struct {
+	Repos        []string
+	Branches     []string
+	ServiceNames []string
+	ExcludeNames []string
+}
+
+// Validate checks that the filter selects something and that all globs are valid.
+func (f *RepoFilter) Validate() error {
+	if len(f.Repos) == 0 && len(f.ServiceNames) == 0 {
+		return fmt.Errorf("repos or service_names must be provided")
+	}
+	for _, patterns := range [][]string{f.Repos, f.Branches, f.ServiceNames, f.ExcludeNames} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid glob %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the service is a repo service selected by the filter.
+func (f *RepoFilter) Matches(service Service) bool {
+	if service.Source() != SourceRepo || matchesAnyGlob(service.Name, f.ExcludeNames) {
+		return false
+	}
+	for _, group := range []struct {
+		value    string
+		patterns []string
+	}{
+		{service.Repo, f.Repos},
+		{service.Branch, f.Branches},
+		{service.Name, f.ServiceNames},
+	} {
+		if len(group.patterns) > 0 && !matchesAnyGlob(group.value, group.patterns) {
+			return false
+		}
+	}
+	return true
+}
+
+// RedeployOptions describes which repo services RedeployServices deploys and at
+// which commit.
+type RedeployOptions struct {
+	Filter RepoFilter
+
+	// CommitSHA deploys this commit. When empty, the latest commit of each
+	// service's branch is deployed.
+	CommitSHA string
+
+	// Force redeploys services already running CommitSHA.
+	Force bool
+
+	// Owner and LockWait are passed to the environment lock, as for UpdateOptions.
+	Owner    string
+	LockWait time.Duration
+}
+
+// revision returns the commit the options deploy, for logs and notifications.
+func (opts RedeployOptions) revision() string {
+	if opts.CommitSHA == "" {
+		return "latest commit"
+	}
+	return opts.CommitSHA
+}
+
+// unchanged reports whether the service already runs opts.CommitSHA.
+func (opts RedeployOptions) unchanged(service Service) bool {
+	if opts.Force || opts.CommitSHA == "" || service.Commit == "" {
+		return false
+	}
+	return service.Commit == opts.CommitSHA
+}
+
+// RedeployServices deploys every repo service in the environment selected by
+// opts.Filter at opts.CommitSHA, or at the latest commit, while holding the
+// environment's lock. Results are reported like UpdateServices, with images given
+// as repo@commit.
+func (c *RailwayClient) RedeployServices(environmentID string, opts RedeployOptions) (*UpdateResult, error) {
+	release, err := c.locks.Acquire(environmentID, opts.Owner, opts.revision(), opts.LockWait)
+	if err != nil {
+		return &UpdateResult{EnvironmentID: environmentID, Updated: make([]ServiceUpdate, 0)}, err
+	}
+	defer release()
+
+	start := time.Now()
+	result, err := c.redeployServices(environmentID, opts)
+
+	if c.notifier != nil && (err != nil || len(result.Updated) > 0) {
+		summary := newUpdateSummary(result, opts.revision(), err, time.Since(start))
+		if notifyErr := c.notifier.Notify(summary); notifyErr != nil {
+			log.Printf("Failed to send update notification: %v", notifyErr)
+		}
+	}
+
+	return result, err
+}
+
+func (c *RailwayClient) redeployServices(environmentID string, opts RedeployOptions) (*UpdateResult, error) {
+	result := &UpdateResult{
+		EnvironmentID: environmentID,
+		Updated:       make([]ServiceUpdate, 0),
+	}
+
+	services, err := c.GetServices(environmentID)
+	if err != nil {
+		return result, fmt.Errorf("failed to get services: %w", err)
+	}
+
+	for _, service := range services {
+		if !opts.Filter.Matches(service) {
+			continue
+		}
+		if opts.unchanged(service) {
+			log.Printf("Service %s already runs commit %s, skipping", service.Name, service.Commit)
+			result.Unchanged = append(result.Unchanged, service.Name)
+			continue
+		}
+
+		previous := service.Commit
+		if previous == "" {
+			previous = service.Branch
+		}
+		next := opts.CommitSHA
+		if next == "" {
+			next = service.Branch
+		}
+		log.Printf("Redeploying service %s from %s at %s", service.Name, service.Repo, opts.revision())
+
+		deploymentID, err := c.deployServiceInstanceCommit(service.ID, environmentID, opts.CommitSHA)
+		if err != nil {
+			result.Failed = append(result.Failed, ServiceFailure{ServiceName: service.Name, Error: err.Error()})
+			return result, fmt.Errorf("failed to redeploy service %s: %w", service.Name, err)
+		}
+		result.Updated = append(result.Updated, ServiceUpdate{
+			ServiceID:     service.ID,
+			ServiceName:   service.Name,
+			PreviousImage: service.Repo + "@" + previous,
+			NewImage:      service.Repo + "@" + next,
+			DeploymentID:  deploymentID,
+		})
+	}
+
+	return result, nil
+}
+
+// deployServiceInstanceCommit deploys a repo service instance at commitSHA and returns
+// the deployment ID. Without a commit it deploys the latest commit of the service's
+// branch; Railway does not return a deployment ID then.
+func (c *RailwayClient) deployServiceInstanceCommit(serviceID, environmentID, commitSHA string) (string, error) {
+	if commitSHA == "" {
+		_, err := execute(c, environmentID, serviceInstanceDeployMutation, serviceInstanceDeployVariables{
+			ServiceID:     serviceID,
+			EnvironmentID: environmentID,
+			LatestCommit:  true,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to deploy service instance: %w", err)
+		}
+		return "", nil
+	}
+
+	result, err := execute(c, environmentID, serviceInstanceDeployV2Mutation, serviceInstanceDeployV2Variables{
+		ServiceID:     serviceID,
+		EnvironmentID: environmentID,
+		CommitSHA:     commitSHA,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to deploy service instance: %w", err)
+	}
+	return result.DeploymentID, nil
+}
+
+// RedeployRequest is the body of PUT /redeploy.
+type RedeployRequest struct {
+	EnvironmentID       string   `json:"environment_id"`
+	Repos               []string `json:"repos,omitempty"`
+	Branches            []string `json:"branches,omitempty"`
+	ServiceNames        []string `json:"service_names,omitempty"`
+	ExcludeServiceNames []string `json:"exclude_service_names,omitempty"`
+
+	// Exactly one of CommitSHA and LatestCommit must be set.
+	CommitSHA    string `json:"commit_sha,omitempty"`
+	LatestCommit bool   `json:"latest_commit,omitempty"`
+
+	Force                bool   `json:"force,omitempty"`
+	FreezeOverrideReason string `json:"freeze_override_reason,omitempty"`
+	LockWaitSeconds      int    `json:"lock_wait_seconds,omitempty"`
+}
+
+// validateRedeployRequest checks req and converts it to RedeployOptions.
+func validateRedeployRequest(req *RedeployRequest) (RedeployOptions, *RequestError) {
+	if _, err := uuid.Parse(req.EnvironmentID); err != nil {
+		return RedeployOptions{}, badRequest("Invalid environment_id: must be a valid UUID")
+	}
+
+	req.CommitSHA = strings.ToLower(req.CommitSHA)
+	switch {
+	case req.CommitSHA == "" && !req.LatestCommit:
+		return RedeployOptions{}, badRequest("commit_sha or latest_commit must be provided")
+	case req.CommitSHA != "" && req.LatestCommit:
+		return RedeployOptions{}, badRequest("commit_sha and latest_commit cannot be combined")
+	case req.CommitSHA != "" && !commitSHAPattern.MatchString(req.CommitSHA):
+		return RedeployOptions{}, badRequest("Invalid commit_sha %q: must be a full commit SHA of 40 hexadecimal characters", req.CommitSHA)
+	}
+
+	if req.LockWaitSeconds < 0 {
+		return RedeployOptions{}, badRequest("lock_wait_seconds cannot be negative")
+	}
+
+	opts := RedeployOptions{
+		Filter: RepoFilter{
+			Repos:        req.Repos,
+			Branches:     req.Branches,
+			ServiceNames: req.ServiceNames,
+			ExcludeNames: req.ExcludeServiceNames,
+		},
+		CommitSHA: req.CommitSHA,
+		Force:     req.Force,
+		LockWait:  time.Duration(req.LockWaitSeconds) * time.Second,
+	}
+	if err := opts.Filter.Validate(); err != nil {
+		return RedeployOptions{}, badRequest("%v", err)
+	}
+	return opts, nil
+}
+
+// handleRedeploy serves PUT /redeploy, the counterpart of /update for services built
+// from a repo. Redeploys respect freeze windows but cannot be approved, so they are
+// refused in environments requiring approval.
+func (s *Server) handleRedeploy(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use PUT"})
+		return
+	}
+
+	identity, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	var req RedeployRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateBodySize)).Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit)})
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Invalid JSON: %v", err)})
+		return
+	}
+
+	// Approval is checked before freezes so a refused redeploy does not audit a freeze override
+	opts, reqErr := validateRedeployRequest(&req)
+	if reqErr == nil && s.config.Get().RequiresApproval([]string{req.EnvironmentID}) {
+		reqErr = &RequestError{Status: http.StatusForbidden, Message: "Services in environments that require approval cannot be redeployed"}
+	}
+	if reqErr == nil {
+		reqErr = enforceFreezes(s.config.Get(), &UpdateRequest{
+			EnvironmentID:        req.EnvironmentID,
+			NewVersion:           opts.revision(),
+			FreezeOverrideReason: req.FreezeOverrideReason,
+		}, identity, s.now(), s.audit)
+	}
+	if reqErr != nil {
+		w.WriteHeader(reqErr.Status)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: reqErr.Message})
+		return
+	}
+	opts.Owner = "PUT /redeploy from " + r.RemoteAddr
+	if identity != "" {
+		opts.Owner = identity + " via PUT /redeploy"
+	}
+
+	result, err := s.client.RedeployServices(req.EnvironmentID, opts)
+	if err != nil {
+		w.WriteHeader(updateErrorStatus(err))
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error:             fmt.Sprintf("Failed to redeploy services: %v", err),
+			UpdatedServices:   result.UpdatedNames(),
+			UnchangedServices: result.Unchanged,
+			FailedServices:    result.Failed,
+			RailwayErrors:     railwayErrors(err),
+		})
+		return
+	}
+
+	updatedServices := result.UpdatedNames()
+	message := fmt.Sprintf("Successfully redeployed %d service(s) at %s", len(updatedServices), opts.revision())
+	switch {
+	case len(updatedServices) == 0 && len(result.Unchanged) > 0:
+		message = fmt.Sprintf("All %d matched service(s) already run commit %s", len(result.Unchanged), opts.CommitSHA)
+	case len(updatedServices) == 0:
+		message = "No repo services matched the provided filters"
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SuccessResponse{
+		Message:           message,
+		UpdatedServices:   updatedServices,
+		UnchangedServices: result.Unchanged,
+	})
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// redeployFake serves an environment with an image service and two repo services,
+// docs on main and site on release.
+func redeployFake(t *testing.T) (*fakeRailway, *RailwayClient) {
+	t.Helper()
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			data := environmentData([3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"})
+			instances := data["environment"].(map[string]interface{})["serviceInstances"].(map[string]interface{})
+			for _, repo := range [][4]string{
+				{"svc-2", "docs", "returnearly/docs", `{"branch":"main","commitHash":"abc1234def567800000000000000000000000000"}`},
+				{"svc-3", "site", "returnearly/site", `{"branch":"release","commitHash":"9999999aaaaaaa00000000000000000000000000"}`},
+			} {
+				instances["edges"] = append(instances["edges"].([]interface{}), map[string]interface{}{
+					"node": map[string]interface{}{
+						"serviceId":        repo[0],
+						"serviceName":      repo[1],
+						"source":           map[string]interface{}{"repo": repo[2]},
+						"latestDeployment": map[string]interface{}{"id": "deploy-" + repo[0], "status": "SUCCESS", "meta": repo[3]},
+					},
+				})
+			}
+			return data, nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-" + variables["commitSha"].(string)}, nil
+		case "ServiceInstanceDeploy":
+			return map[string]interface{}{"serviceInstanceDeploy": true}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+}
+
+func TestRepoFilter_Matches(t *testing.T) {
+	docs := Service{Name: "docs", Repo: "returnearly/docs", Branch: "main"}
+	site := Service{Name: "site", Repo: "returnearly/site", Branch: "release"}
+	api := Service{Name: "api", Image: "ghcr.io/returnearly/api:v1"}
+
+	tests := []struct {
+		name     string
+		filter   RepoFilter
+		expected []string
+	}{
+		{"repo glob", RepoFilter{Repos: []string{"returnearly/*"}}, []string{"docs", "site"}},
+		{"repo and branch", RepoFilter{Repos: []string{"returnearly/*"}, Branches: []string{"main"}}, []string{"docs"}},
+		{"service names skip image services", RepoFilter{ServiceNames: []string{"*"}}, []string{"docs", "site"}},
+		{"exclusions win", RepoFilter{ServiceNames: []string{"*"}, ExcludeNames: []string{"docs"}}, []string{"site"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); err != nil {
+				t.Fatalf("Validate() returned error: %v", err)
+			}
+			var matched []string
+			for _, service := range []Service{api, docs, site} {
+				if tt.filter.Matches(service) {
+					matched = append(matched, service.Name)
+				}
+			}
+			if strings.Join(matched, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Matched %v, expected %v", matched, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRedeployServices_Commit(t *testing.T) {
+	fake, client := redeployFake(t)
+
+	result, err := client.RedeployServices("env-1", RedeployOptions{
+		Filter:    RepoFilter{Repos: []string{"returnearly/*"}},
+		CommitSHA: "abc1234def567800000000000000000000000000",
+	})
+	if err != nil {
+		t.Fatalf("RedeployServices returned error: %v", err)
+	}
+
+	if len(result.Unchanged) != 1 || result.Unchanged[0] != "docs" {
+		t.Errorf("Expected docs to already run the commit, got %v", result.Unchanged)
+	}
+	if len(result.Updated) != 1 {
+		t.Fatalf("Expected 1 redeployed service, got %+v", result.Updated)
+	}
+	site := result.Updated[0]
+	if site.ServiceName != "site" || site.PreviousImage != "returnearly/site@9999999aaaaaaa00000000000000000000000000" || site.NewImage != "returnearly/site@abc1234def567800000000000000000000000000" || site.DeploymentID != "deploy-abc1234def567800000000000000000000000000" {
+		t.Errorf("Unexpected redeploy %+v", site)
+	}
+
+	ops := fake.operations()
+	if len(ops) != 2 || ops[1] != "ServiceInstanceDeployV2" {
+		t.Errorf("Expected a single ServiceInstanceDeployV2, got %v", ops)
+	}
+}
+
+func TestHandleRedeploy_LatestCommit(t *testing.T) {
+	fake, client := redeployFake(t)
+	body := `{"environment_id":"550e8400-e29b-41d4-a716-446655440001","repos":["returnearly/docs"],"latest_commit":true}`
+	req := httptest.NewRequest(http.MethodPut, "/redeploy", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).routes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp SuccessResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.UpdatedServices) != 1 || resp.UpdatedServices[0] != "docs" {
+		t.Errorf("Expected docs to be redeployed, got %v", resp.UpdatedServices)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	last := fake.calls[len(fake.calls)-1]
+	if last.Operation != "ServiceInstanceDeploy" || last.Variables["latestCommit"] != true || last.Variables["serviceId"] != "svc-2" {
+		t.Errorf("Expected docs to be deployed at its latest commit, got %+v", last)
+	}
+}
+
+func TestHandleRedeploy_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no commit", `{"environment_id":"550e8400-e29b-41d4-a716-446655440001","repos":["*"]}`},
+		{"commit and latest", `{"environment_id":"550e8400-e29b-41d4-a716-446655440001","repos":["*"],"commit_sha":"abc1234def567800000000000000000000000000","latest_commit":true}`},
+		{"invalid commit", `{"environment_id":"550e8400-e29b-41d4-a716-446655440001","repos":["*"],"commit_sha":"main"}`},
+		{"short commit", `{"environment_id":"550e8400-e29b-41d4-a716-446655440001","repos":["*"],"commit_sha":"abc1234"}`},
+		{"no filter", `{"environment_id":"550e8400-e29b-41d4-a716-446655440001","latest_commit":true}`},
+		{"invalid environment", `{"environment_id":"env","repos":["*"],"latest_commit":true}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := redeployFake(t)
+			req := httptest.NewRequest(http.MethodPut, "/redeploy", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			newTestServer(client, nil).routes().ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+			if ops := fake.operations(); len(ops) != 0 {
+				t.Errorf("Expected no Railway calls, got %v", ops)
+			}
+		})
+	}
+}
+
+func TestHandleRedeploy_BodyTooLarge(t *testing.T) {
+	fake, client := redeployFake(t)
+	body := `{"environment_id":"` + strings.Repeat("x", maxUpdateBodySize) + `"}`
+	req := httptest.NewRequest(http.MethodPut, "/redeploy", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).routes().ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestHandleRedeploy_RequiresApproval(t *testing.T) {
+	fake, client := redeployFake(t)
+	cfg := &Config{
+		Freezes:   []FreezeWindow{{Name: "always", EnvironmentIDs: []string{approvalEnvironmentID}, Cron: "* * * * *"}},
+		Approvals: &ApprovalConfig{EnvironmentIDs: []string{approvalEnvironmentID}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+	server := newTestServer(client, cfg)
+	server.apiKeys = APIKeys{"alice": "alice-key"}
+	var audit bytes.Buffer
+	server.audit = NewAuditLog(&audit)
+
+	body := RedeployRequest{EnvironmentID: approvalEnvironmentID, Repos: []string{"returnearly/docs"}, LatestCommit: true, FreezeOverrideReason: "hotfix"}
+	w := sendAuthorized(server.routes(), http.MethodPut, "/redeploy", "alice-key", body)
+
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "approval") {
+		t.Errorf("Expected status %d for approval, got %d: %s", http.StatusForbidden, w.Code, w.Body.String())
+	}
+	if audit.Len() != 0 {
+		t.Errorf("Expected no freeze override to be audited, got %s", audit.String())
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// manifestMediaTypes are accepted when resolving a tag's digest, so multi-platform
+// images report the digest of their index rather than of one platform's manifest.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// RegistryRepository locates a repository on an OCI Distribution registry.
+type RegistryRepository struct {
+	// Host is the registry API host, e.g. "ghcr.io" or "registry-1.docker.io".
+	Host string
+	// Name is the repository path on the registry, e.g. "returnearly/api".
+	Name string
+	// Insecure talks to the registry over plain HTTP.
+	Insecure bool
+
+	Username string
+	Password string
+}
+
+// parseRegistryRepository splits an image repository such as "ghcr.io/returnearly/api"
+// into registry host and name. Repositories without a registry host, e.g.
+// "returnearly/web" or "nginx", are on Docker Hub.
+func parseRegistryRepository(repository string) RegistryRepository {
+	host, name, ok := strings.Cut(repository, "/")
+	if !ok || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		host, name = "docker.io", repository
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		host = "registry-1.docker.io"
+		if !strings.Contains(name, "/") {
+			name = "library/" + name
+		}
+	}
+	return RegistryRepository{Host: host, Name: name}
+}
+
+func (r RegistryRepository) url(path string) string {
+	scheme := "https"
+	if r.Insecure {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + "/v2/" + r.Name + path
+}
+
+// RegistryClient reads tags and manifests through the OCI Distribution API. It
+// answers bearer token challenges, as sent by ghcr.io and Docker Hub, with
+// anonymous or basic-authenticated token requests and caches the tokens.
+type RegistryClient struct {
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string // by realm, service and scope
+}
+
+func NewRegistryClient() *RegistryClient {
+	return &RegistryClient{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		tokens:     make(map[string]string),
+	}
+}
+
+// Tags lists every tag of the repository, following pagination links.
+func (c *RegistryClient) Tags(repo RegistryRepository) ([]string, error) {
+	var tags []string
+	next := repo.url("/tags/list?n=1000")
+	for next != "" {
+		req, err := http.NewRequest(http.MethodGet, next, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := c.do(req, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tags of %s: %w", repo.Name, err)
+		}
+		tags = append(tags, page.Tags...)
+
+		next, err = nextPage(req.URL, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+// nextPage resolves the rel="next" link of a paginated response against the request URL.
+func nextPage(base *url.URL, link string) (string, error) {
+	for _, part := range strings.Split(link, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(part), ";")
+		if !ok || !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		ref, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return "", fmt.Errorf("invalid Link header %q: %w", link, err)
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	return "", nil
+}
+
+// Digest returns the content digest of the manifest a tag points to.
+func (c *RegistryClient) Digest(repo RegistryRepository, tag string) (string, error) {
+	req, err := http.NewRequest(http.MethodHead, repo.url("/manifests/"+tag), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	resp, err := c.do(req, repo)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry returned no digest for %s:%s", repo.Name, tag)
+	}
+	return digest, nil
+}
+
+// do sends req, authenticating once if the registry challenges it. Responses other
+// than 200 are returned as errors.
+func (c *RegistryClient) do(req *http.Request, repo RegistryRepository) (*http.Response, error) {
+	key := ""
+	if token, ok := c.cachedToken(req.URL.Host + "/" + repo.Name); ok {
+		key = req.URL.Host + "/" + repo.Name
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query registry %s: %w", repo.Host, err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		if err := c.authorize(req, repo, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = c.httpClient.Do(req); err != nil {
+			return nil, fmt.Errorf("failed to query registry %s: %w", repo.Host, err)
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if key != "" && resp.StatusCode == http.StatusUnauthorized {
+			c.forgetToken(key)
+		}
+		return nil, fmt.Errorf("registry %s returned %d for %s: %s", repo.Host, resp.StatusCode, req.URL.Path, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// authorize sets the Authorization header of req in answer to a WWW-Authenticate challenge.
+func (c *RegistryClient) authorize(req *http.Request, repo RegistryRepository, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if repo.Username == "" {
+			return fmt.Errorf("registry %s requires credentials", repo.Host)
+		}
+		req.SetBasicAuth(repo.Username, repo.Password)
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("registry %s sent unsupported challenge %q", repo.Host, challenge)
+	}
+
+	values := parseChallengeParams(params)
+	if values["realm"] == "" {
+		return fmt.Errorf("registry %s sent a bearer challenge without realm", repo.Host)
+	}
+	tokenURL, err := url.Parse(values["realm"])
+	if err != nil {
+		return fmt.Errorf("invalid token realm %q: %w", values["realm"], err)
+	}
+	query := tokenURL.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = "repository:" + repo.Name + ":pull"
+	}
+	query.Set("scope", scope)
+	tokenURL.RawQuery = query.Encode()
+
+	tokenReq, err := http.NewRequest(http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %w", err)
+	}
+	if repo.Username != "" {
+		tokenReq.SetBasicAuth(repo.Username, repo.Password)
+	}
+
+	resp, err := c.httpClient.Do(tokenReq)
+	if err != nil {
+		return fmt.Errorf("failed to get registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry token endpoint returned %d", resp.StatusCode)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return fmt.Errorf("registry token endpoint returned no token")
+	}
+
+	c.mu.Lock()
+	c.tokens[req.URL.Host+"/"+repo.Name] = token.Token
+	c.mu.Unlock()
+
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	return nil
+}
+
+func (c *RegistryClient) cachedToken(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	token, ok := c.tokens[key]
+	return token, ok
+}
+
+func (c *RegistryClient) forgetToken(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tokens, key)
+}
+
+// parseChallengeParams parses the comma-separated key="value" parameters of a
+// WWW-Authenticate header.
+func parseChallengeParams(params string) map[string]string {
+	values := make(map[string]string)
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(params, "=")
+		key = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(key), ",")))
+
+		if strings.HasPrefix(params, `"`) {
+			end := strings.Index(params[1:], `"`)
+			if end < 0 {
+				value, params = params[1:], ""
+			} else {
+				value, params = params[1:end+1], params[end+2:]
+			}
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		values[key] = value
+	}
+	return values
+}
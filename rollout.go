@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// RolloutStrategy updates matched services in batches. The first batch acts as the
+// canary: each batch must finish deploying successfully before the next one starts.
+type RolloutStrategy struct {
+	// BatchSize is the number of services per batch. BatchPercent sizes batches as a
+	// percentage of the matched services instead. Defaults to one service per batch.
+	BatchSize    int `json:"batch_size,omitempty"`
+	BatchPercent int `json:"batch_percent,omitempty"`
+
+	// PauseSeconds is how long to wait after a healthy batch before starting the next.
+	PauseSeconds int `json:"pause_seconds,omitempty"`
+
+	// HealthTimeoutSeconds bounds the wait for a batch's deployments (default 600).
+	HealthTimeoutSeconds int `json:"health_timeout_seconds,omitempty"`
+
+	// AbortOnFailure stops the rollout at the first unhealthy batch (default true).
+	// When false, failures are recorded, the rest of the rollout continues and the
+	// rollout returns an error at the end.
+	AbortOnFailure *bool `json:"abort_on_failure,omitempty"`
+
+	// RollbackOnFailure restores the previous image on every service updated by this
+	// rollout, including the failed batch, when the rollout aborts. Variable changes
+	// are not reverted.
+	RollbackOnFailure bool `json:"rollback_on_failure,omitempty"`
+}
+
+// Validate checks that the strategy's sizes and durations are usable.
+func (s *RolloutStrategy) Validate() error {
+	if s.BatchSize < 0 || s.PauseSeconds < 0 || s.HealthTimeoutSeconds < 0 {
+		return fmt.Errorf("rollout batch_size, pause_seconds and health_timeout_seconds cannot be negative")
+	}
+	if s.BatchPercent < 0 || s.BatchPercent > 100 {
+		return fmt.Errorf("rollout batch_percent must be between 1 and 100")
+	}
+	if s.BatchSize > 0 && s.BatchPercent > 0 {
+		return fmt.Errorf("rollout batch_size and batch_percent are mutually exclusive")
+	}
+	if s.RollbackOnFailure && !s.abortOnFailure() {
+		return fmt.Errorf("rollout rollback_on_failure requires abort_on_failure")
+	}
+	return nil
+}
+
+func (s *RolloutStrategy) abortOnFailure() bool {
+	return s.AbortOnFailure == nil || *s.AbortOnFailure
+}
+
+// batchSize returns the number of services per batch for total matched services.
+func (s *RolloutStrategy) batchSize(total int) int {
+	size := s.BatchSize
+	if s.BatchPercent > 0 {
+		size = (total*s.BatchPercent + 99) / 100
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+func (s *RolloutStrategy) healthTimeout() time.Duration {
+	if s.HealthTimeoutSeconds > 0 {
+		return time.Duration(s.HealthTimeoutSeconds) * time.Second
+	}
+	return defaultGateTimeout
+}
+
+// rollOut applies opts.Rollout to the matched services, recording progress in result.
+func (c *RailwayClient) rollOut(environmentID string, services []Service, opts UpdateOptions, result *UpdateResult) error {
+	strategy := opts.Rollout
+	size := strategy.batchSize(len(services))
+	var failed []error
+
+	for start := 0; start < len(services); start += size {
+		end := min(start+size, len(services))
+		log.Printf("Rollout batch %d-%d of %d in environment %s", start+1, end, len(services), environmentID)
+
+		batch, failures, err := c.updateServiceBatch(environmentID, services[start:end], opts)
+		result.Updated = append(result.Updated, batch...)
+		result.Failed = append(result.Failed, failures...)
+
+		// Services that failed to update are skipped; the rest of the batch must
+		// still deploy before the rollout moves on
+		if err == nil || (!strategy.abortOnFailure() && len(batch) > 0) {
+			if waitErr := c.WaitForDeployments(environmentID, batch, strategy.healthTimeout()); waitErr != nil {
+				var deployErr *DeploymentError
+				if errors.As(waitErr, &deployErr) {
+					result.Failed = append(result.Failed, deployErr.Failures...)
+				}
+				err = errors.Join(err, waitErr)
+			}
+		}
+
+		if err != nil {
+			if strategy.abortOnFailure() {
+				if strategy.RollbackOnFailure {
+					c.rollBack(environmentID, result)
+				}
+				return fmt.Errorf("rollout aborted at batch starting with service %d: %w", start+1, err)
+			}
+			log.Printf("Rollout batch %d-%d failed, continuing: %v", start+1, end, err)
+			failed = append(failed, err)
+		}
+
+		if end < len(services) && strategy.PauseSeconds > 0 {
+			time.Sleep(time.Duration(strategy.PauseSeconds) * time.Second)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("rollout finished with %d failed service(s): %w", len(result.Failed), errors.Join(failed...))
+	}
+	return nil
+}
+
+// rollBack restores the previous image of every service in result.Updated. Failures
+// are logged and the service is left out of result.RolledBack.
+func (c *RailwayClient) rollBack(environmentID string, result *UpdateResult) {
+	for _, update := range result.Updated {
+		log.Printf("Rolling back service %s from %s to %s", update.ServiceName, update.NewImage, update.PreviousImage)
+		if _, err := c.UpdateServiceImage(update.ServiceID, environmentID, update.PreviousImage, update.NumReplicas); err != nil {
+			log.Printf("Failed to roll back service %s: %v", update.ServiceName, err)
+			continue
+		}
+		result.RolledBack = append(result.RolledBack, update.ServiceName)
+	}
+}
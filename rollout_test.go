@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRolloutStrategy_BatchSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy RolloutStrategy
+		total    int
+		expected int
+	}{
+		{"default", RolloutStrategy{}, 12, 1},
+		{"fixed size", RolloutStrategy{BatchSize: 3}, 12, 3},
+		{"percent rounds up", RolloutStrategy{BatchPercent: 10}, 12, 2},
+		{"percent of few services", RolloutStrategy{BatchPercent: 25}, 2, 1},
+		{"all at once", RolloutStrategy{BatchPercent: 100}, 12, 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.strategy.batchSize(tt.total); result != tt.expected {
+				t.Errorf("batchSize(%d) = %d, expected %d", tt.total, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRolloutStrategy_Validate(t *testing.T) {
+	noAbort := false
+	tests := []struct {
+		name     string
+		strategy RolloutStrategy
+	}{
+		{"negative size", RolloutStrategy{BatchSize: -1}},
+		{"percent over 100", RolloutStrategy{BatchPercent: 101}},
+		{"size and percent", RolloutStrategy{BatchSize: 1, BatchPercent: 10}},
+		{"rollback without abort", RolloutStrategy{AbortOnFailure: &noAbort, RollbackOnFailure: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.strategy.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+// rolloutFake serves three services whose deployments finish with the given statuses.
+func rolloutFake(t *testing.T, statuses map[string]string) (*fakeRailway, *RailwayClient) {
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData(
+				[3]string{"svc-1", "api-1", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-2", "api-2", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-3", "api-3", "ghcr.io/returnearly/api:v1"},
+			), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-" + variables["serviceId"].(string)}, nil
+		case "Deployment":
+			id := variables["id"].(string)
+			status, ok := statuses[strings.TrimPrefix(id, "deploy-")]
+			if !ok {
+				status = "SUCCESS"
+			}
+			return map[string]interface{}{"deployment": map[string]interface{}{"id": id, "status": status}}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+}
+
+func rolloutOptions(strategy RolloutStrategy) UpdateOptions {
+	return UpdateOptions{
+		Filter:     ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api"}},
+		NewVersion: "v2",
+		Rollout:    &strategy,
+	}
+}
+
+func TestUpdateServices_RolloutSucceeds(t *testing.T) {
+	fake, client := rolloutFake(t, nil)
+
+	result, err := client.UpdateServices("env-1", rolloutOptions(RolloutStrategy{BatchSize: 2}))
+	if err != nil {
+		t.Fatalf("UpdateServices returned error: %v", err)
+	}
+
+	if len(result.Updated) != 3 {
+		t.Errorf("Expected 3 updated services, got %d", len(result.Updated))
+	}
+
+	// The canary batch must be healthy before the second batch is touched
+	ops := strings.Join(fake.operations(), ",")
+	expected := "Environment," +
+		"ServiceInstanceUpdate,ServiceInstanceDeployV2,ServiceInstanceUpdate,ServiceInstanceDeployV2,Deployment,Deployment," +
+		"ServiceInstanceUpdate,ServiceInstanceDeployV2,Deployment"
+	if ops != expected {
+		t.Errorf("Expected operations %s, got %s", expected, ops)
+	}
+}
+
+func TestUpdateServices_RolloutAbortsAndRollsBack(t *testing.T) {
+	fake, client := rolloutFake(t, map[string]string{"svc-2": "CRASHED"})
+
+	result, err := client.UpdateServices("env-1", rolloutOptions(RolloutStrategy{RollbackOnFailure: true}))
+	if err == nil {
+		t.Fatal("Expected rollout error")
+	}
+
+	if names := strings.Join(result.UpdatedNames(), ","); names != "api-1,api-2" {
+		t.Errorf("Expected api-1 and api-2 to be updated, got %s", names)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].ServiceName != "api-2" {
+		t.Errorf("Expected api-2 to be reported as failed, got %+v", result.Failed)
+	}
+	if rolledBack := strings.Join(result.RolledBack, ","); rolledBack != "api-1,api-2" {
+		t.Errorf("Expected api-1 and api-2 to be rolled back, got %s", rolledBack)
+	}
+
+	lastImage := ""
+	for _, call := range fake.calls {
+		if call.Variables["serviceId"] == "svc-3" {
+			t.Errorf("Expected svc-3 to be untouched, got %s call", call.Operation)
+		}
+		if call.Operation == "ServiceInstanceUpdate" && call.Variables["serviceId"] == "svc-1" {
+			input := call.Variables["input"].(map[string]interface{})
+			lastImage = input["source"].(map[string]interface{})["image"].(string)
+		}
+	}
+	if lastImage != "ghcr.io/returnearly/api:v1" {
+		t.Errorf("Expected svc-1 to be restored to v1, last image was %q", lastImage)
+	}
+}
+
+func TestUpdateServices_RolloutContinuesOnFailure(t *testing.T) {
+	_, client := rolloutFake(t, map[string]string{"svc-1": "FAILED"})
+	noAbort := false
+
+	result, err := client.UpdateServices("env-1", rolloutOptions(RolloutStrategy{AbortOnFailure: &noAbort}))
+	if err == nil || !strings.Contains(err.Error(), "rollout finished with 1 failed service(s)") {
+		t.Errorf("Expected the rollout to report the failure, got %v", err)
+	}
+
+	if len(result.Updated) != 3 {
+		t.Errorf("Expected 3 updated services, got %d", len(result.Updated))
+	}
+	if len(result.Failed) != 1 || result.Failed[0].ServiceName != "api-1" {
+		t.Errorf("Expected api-1 to be reported as failed, got %+v", result.Failed)
+	}
+	if len(result.RolledBack) != 0 {
+		t.Errorf("Expected no rollback, got %v", result.RolledBack)
+	}
+}
+
+func TestUpdateServices_RolloutContinuesAfterUpdateError(t *testing.T) {
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData(
+				[3]string{"svc-1", "api-1", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-2", "api-2", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-3", "api-3", "ghcr.io/returnearly/api:v1"},
+			), nil
+		case "ServiceInstanceUpdate":
+			if variables["serviceId"] == "svc-2" {
+				return nil, fmt.Errorf("Service not found")
+			}
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-" + variables["serviceId"].(string)}, nil
+		case "Deployment":
+			return map[string]interface{}{"deployment": map[string]interface{}{"id": variables["id"], "status": "SUCCESS"}}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+	noAbort := false
+
+	result, err := client.UpdateServices("env-1", rolloutOptions(RolloutStrategy{BatchSize: 3, AbortOnFailure: &noAbort}))
+	if err == nil {
+		t.Error("Expected the rollout to report the failure")
+	}
+
+	if names := strings.Join(result.UpdatedNames(), ","); names != "api-1,api-3" {
+		t.Errorf("Expected api-1 and api-3 to be updated, got %s", names)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].ServiceName != "api-2" || !strings.Contains(result.Failed[0].Error, "Service not found") {
+		t.Errorf("Expected api-2 to be reported as failed, got %+v", result.Failed)
+	}
+
+	// The services that were updated are still checked before the rollout ends
+	if ops := strings.Count(strings.Join(fake.operations(), ","), "Deployment"); ops != 2 {
+		t.Errorf("Expected 2 deployment checks, got %d", ops)
+	}
+}
+
+func TestHandleUpdate_RolloutFailuresAreNotOK(t *testing.T) {
+	_, client := rolloutFake(t, map[string]string{"svc-1": "FAILED"})
+	noAbort := false
+	body, _ := json.Marshal(UpdateRequest{
+		ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+		ImagePrefixes: []string{"ghcr.io/returnearly/api"},
+		NewVersion:    "v2",
+		Rollout:       &RolloutStrategy{AbortOnFailure: &noAbort},
+	})
+	req := httptest.NewRequest(http.MethodPut, "/update", bytes.NewBuffer(body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, nil).handleUpdate(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusInternalServerError, w.Code, w.Body.String())
+	}
+	var resp ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.UpdatedServices) != 3 || len(resp.FailedServices) != 1 || resp.FailedServices[0].ServiceName != "api-1" {
+		t.Errorf("Expected 3 updated services and api-1 failed, got %+v", resp)
+	}
+}
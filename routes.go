@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Route maps a pushed image repository to an environment. When a registry reports a
+// new tag for a repository selected by Repository, every service in the environment
+// running that repository is updated to the tag. Target may name a configured target
+// instead of setting ProjectID and EnvironmentID; only services the target's filters
+// select are then updated.
+type Route struct {
+	Name          string       `json:"name"`
+	Repository    ImageMatcher `json:"repository"`
+	TagPattern    string       `json:"tag_pattern,omitempty"`
+	Target        string       `json:"target,omitempty"`
+	ProjectID     string       `json:"project_id"`
+	EnvironmentID string       `json:"environment_id"`
+
+	// MutableTags lists tags that are re-pushed with new images, e.g. "latest".
+	// Services already running a pushed mutable tag are redeployed.
+	MutableTags []string `json:"mutable_tags,omitempty"`
+
+	tagRe *regexp.Regexp
+	// scope is the filter of the route's target, set when the target is resolved.
+	scope *ServiceFilter
+}
+
+// Validate checks the route's IDs, repository matcher and tag pattern.
+func (r *Route) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if _, err := uuid.Parse(r.ProjectID); err != nil {
+		return fmt.Errorf("route %s: invalid project_id: must be a valid UUID", r.Name)
+	}
+	if _, err := uuid.Parse(r.EnvironmentID); err != nil {
+		return fmt.Errorf("route %s: invalid environment_id: must be a valid UUID", r.Name)
+	}
+	if err := r.Repository.Validate(); err != nil {
+		return fmt.Errorf("route %s: invalid repository: %w", r.Name, err)
+	}
+	if r.TagPattern != "" {
+		re, err := regexp.Compile(r.TagPattern)
+		if err != nil {
+			return fmt.Errorf("route %s: invalid tag_pattern: %w", r.Name, err)
+		}
+		r.tagRe = re
+	}
+	return nil
+}
+
+// Matches reports whether a push of repository:tag should trigger the route. Any of
+// the repository's names may match, see dockerHubRepositoryNames.
+func (r *Route) Matches(repositories []string, tag string) bool {
+	if r.tagRe != nil && !r.tagRe.MatchString(tag) {
+		return false
+	}
+	for _, repository := range repositories {
+		if r.Repository.Matches(repository) {
+			return true
+		}
+	}
+	return false
+}
+
+// mutable reports whether tag is one of the route's mutable tags.
+func (r *Route) mutable(tag string) bool {
+	for _, mutable := range r.MutableTags {
+		if mutable == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// routeLockWait is how long a webhook-triggered update queues behind another update
+// of the same environment. Registries rarely retry failed webhooks, so pushes wait
+// rather than being rejected.
+const routeLockWait = 5 * time.Minute
+
+// RouteResult reports the update triggered by one route.
+type RouteResult struct {
+	Route             string   `json:"route"`
+	Image             string   `json:"image"`
+	EnvironmentID     string   `json:"environment_id"`
+	UpdatedServices   []string `json:"updated_services"`
+	UnchangedServices []string `json:"unchanged_services,omitempty"`
+	Error             string   `json:"error,omitempty"`
+
+	// Skipped is why the route was not applied, e.g. a freeze window.
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// WebhookResponse is returned by the registry webhook endpoints.
+type WebhookResponse struct {
+	Message    string        `json:"message"`
+	Repository string        `json:"repository,omitempty"`
+	Tag        string        `json:"tag,omitempty"`
+	Routes     []RouteResult `json:"routes,omitempty"`
+}
+
+// triggerRoutes updates every environment whose route matches the pushed image.
+// repositories lists the names the pushed repository may be referenced by, canonical
+// name first. Services are selected by those names, so only services already running
+// the pushed image are changed. Services already running the pushed tag are skipped
+// as unchanged, unless the route lists the tag as mutable.
+func triggerRoutes(client *RailwayClient, cfg *Config, repositories []string, tag string) []RouteResult {
+	results := make([]RouteResult, 0)
+
+	matchers := make([]ImageMatcher, 0, len(repositories))
+	for _, repository := range repositories {
+		matchers = append(matchers, ImageMatcher{Repository: repository})
+	}
+
+	for i := range cfg.Routes {
+		route := &cfg.Routes[i]
+		if !route.Matches(repositories, tag) {
+			continue
+		}
+
+		// Pushes cannot carry an override or wait for an approval, so frozen and
+		// protected environments are never updated
+		err := cfg.checkFreezes([]string{route.EnvironmentID}, time.Now())
+		if err == nil {
+			err = cfg.checkApprovals([]string{route.EnvironmentID})
+		}
+		if err != nil {
+			log.Printf("Route %s skipped: %v", route.Name, err)
+			results = append(results, RouteResult{
+				Route:           route.Name,
+				Image:           repositories[0] + ":" + tag,
+				EnvironmentID:   route.EnvironmentID,
+				UpdatedServices: []string{},
+				Skipped:         err.Error(),
+			})
+			continue
+		}
+
+		log.Printf("Route %s matched push of %s:%s, updating environment %s", route.Name, repositories[0], tag, route.EnvironmentID)
+
+		opts := UpdateOptions{
+			Filter: ServiceFilter{
+				Matchers: matchers,
+				Logic:    MatchLogicOr,
+				Scope:    route.scope,
+			},
+			NewVersion: tag,
+			Force:      route.mutable(tag),
+			Owner:      "route " + route.Name,
+			LockWait:   routeLockWait,
+			ProjectID:  route.ProjectID,
+		}
+
+		result, err := client.UpdateServices(route.EnvironmentID, opts)
+		routeResult := RouteResult{
+			Route:             route.Name,
+			Image:             repositories[0] + ":" + tag,
+			EnvironmentID:     route.EnvironmentID,
+			UpdatedServices:   result.UpdatedNames(),
+			UnchangedServices: result.Unchanged,
+		}
+		if err != nil {
+			log.Printf("Route %s failed: %v", route.Name, err)
+			routeResult.Error = err.Error()
+		}
+		results = append(results, routeResult)
+	}
+
+	return results
+}
+
+// dockerHubRepositoryNames returns the names a Docker Hub repository may be referenced
+// by in a service image, e.g. "returnearly/web", "docker.io/returnearly/web" and
+// "index.docker.io/returnearly/web". Official images also match without "library/".
+func dockerHubRepositoryNames(repoName string) []string {
+	names := []string{repoName}
+	for _, host := range []string{"docker.io/", "index.docker.io/", "registry-1.docker.io/"} {
+		names = append(names, host+repoName)
+	}
+	if name, ok := strings.CutPrefix(repoName, "library/"); ok {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// scheduleCheckInterval is how often due scheduled updates are looked for.
+	scheduleCheckInterval = 5 * time.Second
+
+	// scheduledLockWait is how long a scheduled update queues behind another update
+	// of the same environment unless the request sets lock_wait_seconds.
+	scheduledLockWait = 5 * time.Minute
+
+	// defaultScheduleMaxDelay is how late a scheduled update may start unless the
+	// request sets max_delay_seconds.
+	defaultScheduleMaxDelay = 15 * time.Minute
+)
+
+// ScheduledUpdate is an /update request that is applied at ApplyAt.
+type ScheduledUpdate struct {
+	ID          string        `json:"id"`
+	ApplyAt     time.Time     `json:"apply_at"`
+	RequestedBy string        `json:"requested_by,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	Request     UpdateRequest `json:"request"`
+}
+
+// redacted returns a copy of the update with variable values redacted, for API
+// responses. The schedule file keeps the real values so the update can be applied.
+func (u *ScheduledUpdate) redacted() *ScheduledUpdate {
+	masked := *u
+	masked.Request = u.Request.redacted()
+	return &masked
+}
+
+// maxDelay returns how late after ApplyAt the update may still start.
+func (u *ScheduledUpdate) maxDelay() time.Duration {
+	if u.Request.MaxDelaySeconds > 0 {
+		return time.Duration(u.Request.MaxDelaySeconds) * time.Second
+	}
+	return defaultScheduleMaxDelay
+}
+
+// Scheduler holds scheduled updates until they are due. When it has a path, every
+// change is written to that JSON file so scheduled updates survive restarts.
+type Scheduler struct {
+	path string
+
+	mu      sync.Mutex
+	updates map[string]*ScheduledUpdate
+}
+
+// NewScheduler returns a scheduler that keeps scheduled updates in memory only.
+func NewScheduler() *Scheduler {
+	return &Scheduler{updates: make(map[string]*ScheduledUpdate)}
+}
+
+// LoadScheduler returns a scheduler persisted to path, loading the updates already
+// scheduled there. An empty path keeps updates in memory only.
+func LoadScheduler(path string) (*Scheduler, error) {
+	s := NewScheduler()
+	s.path = path
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %w", err)
+	}
+
+	var updates []*ScheduledUpdate
+	if err := json.Unmarshal(data, &updates); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule %s: %w", path, err)
+	}
+	for _, update := range updates {
+		s.updates[update.ID] = update
+	}
+	return s, nil
+}
+
+// save writes every scheduled update to the file atomically. The file holds variable
+// values, so only the owner may read it. The caller must hold s.mu.
+func (s *Scheduler) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write schedule: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write schedule: %w", err)
+	}
+	return nil
+}
+
+// sorted returns the scheduled updates by ApplyAt. The caller must hold s.mu.
+func (s *Scheduler) sorted() []*ScheduledUpdate {
+	updates := make([]*ScheduledUpdate, 0, len(s.updates))
+	for _, update := range s.updates {
+		updates = append(updates, update)
+	}
+	sort.Slice(updates, func(i, j int) bool {
+		return updates[i].ApplyAt.Before(updates[j].ApplyAt)
+	})
+	return updates
+}
+
+// Add schedules an update. Nothing is scheduled if it cannot be persisted.
+func (s *Scheduler) Add(update *ScheduledUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.updates[update.ID] = update
+	if err := s.save(); err != nil {
+		delete(s.updates, update.ID)
+		return err
+	}
+	return nil
+}
+
+// List returns the scheduled updates, earliest first.
+func (s *Scheduler) List() []*ScheduledUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+// Cancel removes the scheduled update with the given ID.
+func (s *Scheduler) Cancel(id string) (*ScheduledUpdate, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update, ok := s.updates[id]
+	if !ok {
+		return nil, false, nil
+	}
+	delete(s.updates, id)
+	if err := s.save(); err != nil {
+		s.updates[id] = update
+		return nil, false, err
+	}
+	return update, true, nil
+}
+
+// Due removes and returns the updates due at now, earliest first. They are removed
+// from the file before they run, so an update interrupted by a restart is not
+// applied twice.
+func (s *Scheduler) Due(now time.Time) ([]*ScheduledUpdate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*ScheduledUpdate
+	for _, update := range s.sorted() {
+		if update.ApplyAt.After(now) {
+			break
+		}
+		due = append(due, update)
+		delete(s.updates, update.ID)
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	if err := s.save(); err != nil {
+		for _, update := range due {
+			s.updates[update.ID] = update
+		}
+		return nil, err
+	}
+	return due, nil
+}
+
+// ScheduledUpdateResponse is returned when an update is scheduled or cancelled.
+type ScheduledUpdateResponse struct {
+	Message         string           `json:"message"`
+	ScheduledUpdate *ScheduledUpdate `json:"scheduled_update"`
+}
+
+// ScheduledUpdatesResponse is returned by GET /scheduled-updates.
+type ScheduledUpdatesResponse struct {
+	ScheduledUpdates []*ScheduledUpdate `json:"scheduled_updates"`
+}
+
+// schedule stores the validated update req for its apply_at time. original is the
+// request as it was sent, before its target was resolved, so it is validated again
+// against the configuration in effect when it runs.
+func (s *Server) schedule(w http.ResponseWriter, original, req UpdateRequest, identity string) {
+	update := &ScheduledUpdate{
+		ID:          uuid.NewString(),
+		ApplyAt:     original.ApplyAt.UTC(),
+		RequestedBy: identity,
+		CreatedAt:   s.now().UTC(),
+		Request:     original,
+	}
+	if err := s.scheduler.Add(update); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to schedule update: %v", err)})
+		return
+	}
+
+	log.Printf("Scheduled update %s to %s at %s", update.ID, req.NewVersion, update.ApplyAt.Format(time.RFC3339))
+	for _, environmentID := range req.environments() {
+		s.audit.Record(AuditEntry{
+			Action:        AuditScheduled,
+			Identity:      identity,
+			EnvironmentID: environmentID,
+			Version:       req.NewVersion,
+			Detail:        update.ID,
+		})
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ScheduledUpdateResponse{
+		Message:         fmt.Sprintf("Update scheduled for %s", update.ApplyAt.Format(time.RFC3339)),
+		ScheduledUpdate: update.redacted(),
+	})
+}
+
+// scheduledEnvironments returns the environments a scheduled update applies to,
+// resolving its target against the current configuration.
+func (s *Server) scheduledEnvironments(update *ScheduledUpdate) []string {
+	req := update.Request
+	if _, reqErr := validateUpdateRequest(&req, s.config.Get()); reqErr != nil {
+		// The request no longer resolves; record what it names itself
+		req = update.Request
+	}
+	return req.environments()
+}
+
+// runDueUpdates applies every scheduled update that is due. Each request is validated
+// again and checked against freeze windows as its requester; results are logged and
+// sent to the configured notifiers. Updates more than their max delay overdue, e.g.
+// after downtime, are missed and audited instead of applied.
+func (s *Server) runDueUpdates() {
+	now := s.now()
+	due, err := s.scheduler.Due(now)
+	if err != nil {
+		log.Printf("Failed to take due scheduled updates: %v", err)
+		return
+	}
+
+	for _, update := range due {
+		if late := now.Sub(update.ApplyAt); late > update.maxDelay() {
+			log.Printf("Scheduled update %s missed: it was due at %s, %s ago", update.ID, update.ApplyAt.Format(time.RFC3339), late.Round(time.Second))
+			for _, environmentID := range s.scheduledEnvironments(update) {
+				s.audit.Record(AuditEntry{
+					Action:        AuditScheduleMissed,
+					Identity:      update.RequestedBy,
+					EnvironmentID: environmentID,
+					Version:       update.Request.NewVersion,
+					Detail:        update.ID,
+				})
+			}
+			continue
+		}
+		if err := s.runScheduled(update); err != nil {
+			log.Printf("Scheduled update %s failed: %v", update.ID, err)
+			continue
+		}
+		log.Printf("Scheduled update %s to %s finished", update.ID, update.Request.NewVersion)
+	}
+}
+
+func (s *Server) runScheduled(update *ScheduledUpdate) error {
+	req := update.Request
+	req.ApplyAt = nil
+
+	cfg := s.config.Get()
+	opts, reqErr := validateUpdateRequest(&req, cfg)
+	if reqErr == nil {
+		reqErr = enforceFreezes(cfg, &req, update.RequestedBy, s.now(), s.audit)
+	}
+	if reqErr != nil {
+		return reqErr
+	}
+	opts.Owner = "scheduled update " + update.ID
+	if opts.LockWait == 0 {
+		opts.LockWait = scheduledLockWait
+	}
+
+	if len(req.EnvironmentIDs) > 0 {
+		_, err := s.client.PromoteServices(req.EnvironmentIDs, opts, PromotionOptions{
+			Gate:        req.Gate,
+			GateTimeout: time.Duration(req.GateTimeoutSeconds) * time.Second,
+		})
+		return err
+	}
+
+	result, err := s.client.UpdateServices(req.EnvironmentID, opts)
+	if err != nil {
+		return err
+	}
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("%d service(s) failed to update", len(result.Failed))
+	}
+	return nil
+}
+
+// watchSchedule runs due scheduled updates every interval. The returned function
+// stops watching.
+func (s *Server) watchSchedule(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			s.runDueUpdates()
+		}
+	}()
+
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// handleListScheduled serves GET /scheduled-updates.
+func (s *Server) handleListScheduled(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use GET"})
+		return
+	}
+
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	updates := s.scheduler.List()
+	for i, update := range updates {
+		updates[i] = update.redacted()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ScheduledUpdatesResponse{ScheduledUpdates: updates})
+}
+
+// handleCancelScheduled serves DELETE /scheduled-updates/{id}.
+func (s *Server) handleCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use DELETE"})
+		return
+	}
+
+	identity, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	update, ok, err := s.scheduler.Cancel(id)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to cancel scheduled update: %v", err)})
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("No scheduled update %s", id)})
+		return
+	}
+
+	log.Printf("Cancelled scheduled update %s", id)
+	for _, environmentID := range s.scheduledEnvironments(update) {
+		s.audit.Record(AuditEntry{
+			Action:        AuditScheduleCancelled,
+			Identity:      identity,
+			EnvironmentID: environmentID,
+			Version:       update.Request.NewVersion,
+			Detail:        id,
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ScheduledUpdateResponse{
+		Message:         fmt.Sprintf("Cancelled scheduled update %s", id),
+		ScheduledUpdate: update.redacted(),
+	})
+}
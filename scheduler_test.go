@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduler_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule.json")
+	at := time.Date(2026, 10, 20, 2, 0, 0, 0, time.UTC)
+
+	scheduler, err := LoadScheduler(path)
+	if err != nil {
+		t.Fatalf("LoadScheduler returned error: %v", err)
+	}
+	for _, update := range []*ScheduledUpdate{
+		{ID: "later", ApplyAt: at.Add(time.Hour)},
+		{ID: "first", ApplyAt: at},
+		{ID: "cancelled", ApplyAt: at},
+	} {
+		if err := scheduler.Add(update); err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+	}
+	if _, ok, err := scheduler.Cancel("cancelled"); !ok || err != nil {
+		t.Fatalf("Expected cancelled to be cancelled, got %v, %v", ok, err)
+	}
+
+	reloaded, err := LoadScheduler(path)
+	if err != nil {
+		t.Fatalf("LoadScheduler returned error: %v", err)
+	}
+	updates := reloaded.List()
+	if len(updates) != 2 || updates[0].ID != "first" || updates[1].ID != "later" {
+		t.Fatalf("Expected first and later to survive a reload, got %+v", updates)
+	}
+
+	due, err := reloaded.Due(at)
+	if err != nil {
+		t.Fatalf("Due returned error: %v", err)
+	}
+	if len(due) != 1 || due[0].ID != "first" {
+		t.Errorf("Expected first to be due, got %+v", due)
+	}
+
+	reloaded, err = LoadScheduler(path)
+	if err != nil {
+		t.Fatalf("LoadScheduler returned error: %v", err)
+	}
+	if updates := reloaded.List(); len(updates) != 1 || updates[0].ID != "later" {
+		t.Errorf("Expected only later to remain scheduled, got %+v", updates)
+	}
+}
+
+func TestHandleUpdate_ApplyAt(t *testing.T) {
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData([3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"}), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+	server := newTestServer(client, &Config{})
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	handler := server.routes()
+
+	send := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, &buf))
+		return w
+	}
+
+	req := UpdateRequest{
+		ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+		ImagePrefixes: []string{"ghcr.io/returnearly/api"},
+		NewVersion:    "v2",
+	}
+
+	past := now.Add(-time.Minute)
+	req.ApplyAt = &past
+	if w := send(http.MethodPut, "/update", req); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected apply_at in the past to return %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	applyAt := time.Date(2026, 10, 20, 2, 0, 0, 0, time.UTC)
+	req.ApplyAt = &applyAt
+	w := send(http.MethodPut, "/update", req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	var scheduled ScheduledUpdateResponse
+	if err := json.NewDecoder(w.Body).Decode(&scheduled); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !scheduled.ScheduledUpdate.ApplyAt.Equal(applyAt) {
+		t.Errorf("Expected apply_at %s, got %s", applyAt, scheduled.ScheduledUpdate.ApplyAt)
+	}
+	cancelled := send(http.MethodPut, "/update", req)
+
+	server.runDueUpdates()
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Fatalf("Expected no Railway calls before apply_at, got %v", ops)
+	}
+
+	var list ScheduledUpdatesResponse
+	json.NewDecoder(send(http.MethodGet, "/scheduled-updates", nil).Body).Decode(&list)
+	if len(list.ScheduledUpdates) != 2 {
+		t.Fatalf("Expected 2 scheduled updates, got %+v", list.ScheduledUpdates)
+	}
+
+	json.NewDecoder(cancelled.Body).Decode(&scheduled)
+	if w := send(http.MethodDelete, "/scheduled-updates/"+scheduled.ScheduledUpdate.ID, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected cancel to return %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if w := send(http.MethodDelete, "/scheduled-updates/"+scheduled.ScheduledUpdate.ID, nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected a second cancel to return %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	now = applyAt
+	server.runDueUpdates()
+
+	deploys := 0
+	for _, op := range fake.operations() {
+		if op == "ServiceInstanceDeployV2" {
+			deploys++
+		}
+	}
+	if deploys != 1 {
+		t.Errorf("Expected the remaining scheduled update to deploy once, got %v", fake.operations())
+	}
+	if remaining := server.scheduler.List(); len(remaining) != 0 {
+		t.Errorf("Expected no scheduled updates left, got %+v", remaining)
+	}
+}
+
+func TestHandleUpdate_ApplyAtRedactsVariables(t *testing.T) {
+	_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		return environmentData([3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"}), nil
+	})
+	server := newTestServer(client, &Config{})
+	server.now = func() time.Time { return time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC) }
+	path := filepath.Join(t.TempDir(), "schedule.json")
+	scheduler, err := LoadScheduler(path)
+	if err != nil {
+		t.Fatalf("LoadScheduler returned error: %v", err)
+	}
+	server.scheduler = scheduler
+	handler := server.routes()
+
+	applyAt := time.Date(2026, 10, 20, 2, 0, 0, 0, time.UTC)
+	body, _ := json.Marshal(UpdateRequest{
+		ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+		ImagePrefixes: []string{"ghcr.io/returnearly/api"},
+		NewVersion:    "v2",
+		Variables:     &VariableChanges{Shared: VariableSet{Upsert: map[string]string{"API_SECRET": "s3cret"}}},
+		ApplyAt:       &applyAt,
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/update", bytes.NewReader(body)))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "s3cret") {
+		t.Errorf("Expected the variable value to be redacted, got %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scheduled-updates", nil))
+	if strings.Contains(w.Body.String(), "s3cret") || !strings.Contains(w.Body.String(), "API_SECRET") {
+		t.Errorf("Expected the listed variable value to be redacted, got %s", w.Body.String())
+	}
+
+	// The file keeps the real value for applying after a restart, readable by its owner only
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "s3cret") {
+		t.Errorf("Expected the schedule file to keep the variable value, got %s", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected the schedule file to have mode 0600, got %v", perm)
+	}
+}
+
+func TestHandleUpdate_ApplyAtMissed(t *testing.T) {
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		return map[string]interface{}{}, nil
+	})
+	server := newTestServer(client, &Config{})
+	var audit bytes.Buffer
+	server.audit = NewAuditLog(&audit)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	handler := server.routes()
+
+	applyAt := time.Date(2026, 10, 20, 2, 0, 0, 0, time.UTC)
+	req := UpdateRequest{
+		ProjectID:       "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentIDs:  []string{"550e8400-e29b-41d4-a716-446655440001", "550e8400-e29b-41d4-a716-446655440002"},
+		ImagePrefixes:   []string{"ghcr.io/returnearly/api"},
+		NewVersion:      "v2",
+		ApplyAt:         &applyAt,
+		MaxDelaySeconds: 60,
+	}
+	for i := 0; i < 2; i++ {
+		if w := sendAuthorized(handler, http.MethodPut, "/update", "", req); w.Code != http.StatusAccepted {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+		}
+	}
+	scheduled := server.scheduler.List()
+	if w := sendAuthorized(handler, http.MethodDelete, "/scheduled-updates/"+scheduled[0].ID, "", nil); w.Code != http.StatusOK {
+		t.Fatalf("Expected cancel to return %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	// The server was down past apply_at plus max_delay_seconds
+	now = applyAt.Add(2 * time.Minute)
+	server.runDueUpdates()
+
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected a missed update not to run, got %v", ops)
+	}
+	if remaining := server.scheduler.List(); len(remaining) != 0 {
+		t.Errorf("Expected no scheduled updates left, got %+v", remaining)
+	}
+
+	actions := make(map[string][]string)
+	decoder := json.NewDecoder(&audit)
+	for decoder.More() {
+		var entry AuditEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Failed to decode audit entry: %v", err)
+		}
+		actions[entry.Action] = append(actions[entry.Action], entry.EnvironmentID)
+	}
+	for _, action := range []string{AuditScheduleCancelled, AuditScheduleMissed} {
+		if environments := actions[action]; len(environments) != 2 || environments[0] != req.EnvironmentIDs[0] || environments[1] != req.EnvironmentIDs[1] {
+			t.Errorf("Expected %s entries for both environments, got %v", action, environments)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a semantic version parsed from an image tag such as "v1.2.3" or
+// "1.2.3-rc.1". Build metadata is ignored.
+type semver struct {
+	major, minor, patch int
+	prerelease          string
+}
+
+// parseSemver parses a full MAJOR.MINOR.PATCH version with an optional "v" prefix.
+func parseSemver(tag string) (semver, bool) {
+	v, parts, ok := parseSemverPrefix(tag)
+	return v, ok && parts == 3
+}
+
+// parseSemverPrefix parses a version that may omit its minor and patch numbers, as
+// in constraints like "^1" or "~1.2". It returns how many numbers were given.
+func parseSemverPrefix(s string) (semver, int, bool) {
+	var v semver
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, v.prerelease, _ = strings.Cut(s, "-")
+
+	numbers := strings.Split(s, ".")
+	if len(numbers) > 3 {
+		return semver{}, 0, false
+	}
+	for i, number := range numbers {
+		n, err := strconv.Atoi(number)
+		if err != nil || n < 0 || (len(number) > 1 && number[0] == '0') {
+			return semver{}, 0, false
+		}
+		switch i {
+		case 0:
+			v.major = n
+		case 1:
+			v.minor = n
+		case 2:
+			v.patch = n
+		}
+	}
+	return v, len(numbers), true
+}
+
+// compare returns -1, 0 or 1 as v is lower than, equal to or higher than o, using
+// semantic versioning precedence.
+func (v semver) compare(o semver) int {
+	for _, d := range [][2]int{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if d[0] != d[1] {
+			return compareInts(d[0], d[1])
+		}
+	}
+
+	switch {
+	case v.prerelease == o.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case o.prerelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.prerelease, "."), strings.Split(o.prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrereleaseIdentifiers(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+// comparePrereleaseIdentifiers orders numeric identifiers numerically and below
+// alphanumeric ones, which are ordered lexically.
+func comparePrereleaseIdentifiers(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return compareInts(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// semverComparator is a single bound such as ">=1.2.0".
+type semverComparator struct {
+	op string
+	v  semver
+}
+
+func (c semverComparator) matches(v semver) bool {
+	cmp := v.compare(c.v)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return cmp == 0
+}
+
+// semverConstraint is a version range: a version matches when it satisfies every
+// comparator of any alternative.
+type semverConstraint [][]semverComparator
+
+// parseSemverConstraint parses a range such as ">=1.2.0 <2.0.0", "^1.4", "~1.2.3"
+// or "1.x || >=3.0.0". Comparators separated by spaces or commas must all match;
+// alternatives are separated by "||". "*" matches every version.
+func parseSemverConstraint(expr string) (semverConstraint, error) {
+	var constraint semverConstraint
+	for _, alternative := range strings.Split(expr, "||") {
+		fields := strings.Fields(strings.ReplaceAll(alternative, ",", " "))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid semver range %q: empty alternative", expr)
+		}
+
+		var comparators []semverComparator
+		for _, field := range fields {
+			parsed, err := parseSemverComparator(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid semver range %q: %w", expr, err)
+			}
+			comparators = append(comparators, parsed...)
+		}
+		constraint = append(constraint, comparators)
+	}
+	return constraint, nil
+}
+
+// parseSemverComparator expands one range term into comparators. Caret, tilde and
+// wildcard terms become a lower and an upper bound.
+func parseSemverComparator(term string) ([]semverComparator, error) {
+	if term == "*" || term == "x" || term == "X" {
+		return nil, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if rest, ok := strings.CutPrefix(term, prefix); ok {
+			op, term = prefix, rest
+			break
+		}
+	}
+
+	// Wildcards such as "1.x" or "1.2.*" only fix the leading numbers
+	version, wildcard := term, false
+	for _, suffix := range []string{".x", ".X", ".*"} {
+		for strings.HasSuffix(version, suffix) {
+			version, wildcard = strings.TrimSuffix(version, suffix), true
+		}
+	}
+	if wildcard && op != "" && op != "=" {
+		return nil, fmt.Errorf("wildcard %q cannot be combined with %s", term, op)
+	}
+
+	v, parts, ok := parseSemverPrefix(version)
+	if !ok {
+		return nil, fmt.Errorf("invalid version %q", term)
+	}
+
+	// upper returns the first version past the given number of leading parts
+	upper := func(fixed int) semver {
+		switch fixed {
+		case 1:
+			return semver{major: v.major + 1}
+		case 2:
+			return semver{major: v.major, minor: v.minor + 1}
+		}
+		return semver{major: v.major, minor: v.minor, patch: v.patch + 1}
+	}
+
+	switch op {
+	case "^":
+		fixed := 1
+		switch {
+		case v.major > 0 || parts == 1:
+		case v.minor > 0 || parts == 2:
+			fixed = 2
+		default:
+			fixed = 3
+		}
+		return []semverComparator{{">=", v}, {"<", upper(fixed)}}, nil
+	case "~":
+		fixed := 2
+		if parts == 1 {
+			fixed = 1
+		}
+		return []semverComparator{{">=", v}, {"<", upper(fixed)}}, nil
+	case "", "=":
+		if parts < 3 {
+			return []semverComparator{{">=", v}, {"<", upper(parts)}}, nil
+		}
+		return []semverComparator{{"=", v}}, nil
+	}
+	return []semverComparator{{op, v}}, nil
+}
+
+// Matches reports whether v is in the range. Pre-releases never match, so a range
+// only selects release tags.
+func (c semverConstraint) Matches(v semver) bool {
+	if v.prerelease != "" {
+		return false
+	}
+	for _, alternative := range c {
+		matched := true
+		for _, comparator := range alternative {
+			if !comparator.matches(v) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func TestSemver_Compare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.2.3", "v1.2.3", 0},
+		{"1.2.10", "1.2.9", 1},
+		{"1.10.0", "2.0.0", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-rc.2", "1.0.0-rc.10", -1},
+		{"1.0.0-alpha", "1.0.0-1", 1},
+		{"1.0.0-alpha.1", "1.0.0-alpha", 1},
+		{"1.0.0+build.5", "1.0.0", 0},
+	}
+
+	for _, tt := range tests {
+		a, okA := parseSemver(tt.a)
+		b, okB := parseSemver(tt.b)
+		if !okA || !okB {
+			t.Fatalf("Failed to parse %s or %s", tt.a, tt.b)
+		}
+		if got := a.compare(b); got != tt.expected {
+			t.Errorf("Expected compare(%s, %s) = %d, got %d", tt.a, tt.b, tt.expected, got)
+		}
+	}
+
+	for _, tag := range []string{"latest", "1.2", "1.2.3.4", "01.2.3", "v1.x.0", "sha-abc123"} {
+		if _, ok := parseSemver(tag); ok {
+			t.Errorf("Expected %q not to parse as a version", tag)
+		}
+	}
+}
+
+func TestSemverConstraint_Matches(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{">=1.2.0 <2.0.0", "1.9.9", true},
+		{">=1.2.0 <2.0.0", "2.0.0", false},
+		{">=1.2.0, <2.0.0", "1.1.0", false},
+		{"^1.4", "1.4.0", true},
+		{"^1.4", "1.12.3", true},
+		{"^1.4", "2.0.0", false},
+		{"^0.3.1", "0.3.9", true},
+		{"^0.3.1", "0.4.0", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"1.x", "1.99.0", true},
+		{"1.2.*", "1.3.0", false},
+		{"1.2.3", "1.2.3", true},
+		{"<1.0.0 || >=3.0.0", "3.1.0", true},
+		{"<1.0.0 || >=3.0.0", "2.0.0", false},
+		{"*", "4.5.6", true},
+		{"*", "4.5.6-rc.1", false},
+		{">=1.0.0", "1.1.0-beta", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			constraint, err := parseSemverConstraint(tt.constraint)
+			if err != nil {
+				t.Fatalf("parseSemverConstraint returned error: %v", err)
+			}
+			v, ok := parseSemver(tt.version)
+			if !ok {
+				t.Fatalf("Failed to parse %s", tt.version)
+			}
+			if got := constraint.Matches(v); got != tt.expected {
+				t.Errorf("Expected %s in %q to be %v, got %v", tt.version, tt.constraint, tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseSemverConstraint_Invalid(t *testing.T) {
+	for _, expr := range []string{"", ">=", "^1.x", "1.2.3 ||", "latest"} {
+		if _, err := parseSemverConstraint(expr); err == nil {
+			t.Errorf("Expected error for %q", expr)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"time"
+)
+
+// WebhookSecrets holds the shared secrets for the registry webhook endpoints. An
+// endpoint is only registered when its secret is set.
+type WebhookSecrets struct {
+	GitHub       string
+	DockerHub    string
+	Distribution string
+}
+
+// Server holds the dependencies shared by the HTTP handlers.
+type Server struct {
+	client   *RailwayClient
+	config   *ConfigStore
+	webhooks WebhookSecrets
+	drift    *DriftMonitor
+	watchers *RegistryPoller
+
+	// idempotency remembers /update responses by Idempotency-Key.
+	idempotency *IdempotencyStore
+
+	// approvals holds /update requests waiting for a second identity to approve them.
+	approvals *ApprovalStore
+
+	// scheduler holds /update requests with an apply_at time until they are due.
+	scheduler *Scheduler
+
+	// apiKeys identify callers of /update, /redeploy, /approvals and conversions. When
+	// empty, all are open.
+	apiKeys APIKeys
+	audit   *AuditLog
+	now     func() time.Time
+}
+
+func NewServer(client *RailwayClient, config *ConfigStore, webhooks WebhookSecrets) *Server {
+	return &Server{
+		client:      client,
+		config:      config,
+		webhooks:    webhooks,
+		drift:       NewDriftMonitor(client, config),
+		watchers:    NewRegistryPoller(client, config),
+		idempotency: NewIdempotencyStore(defaultIdempotencyRetention, defaultIdempotencyMaxKeys),
+		approvals:   NewApprovalStore(),
+		scheduler:   NewScheduler(),
+		audit:       NewAuditLog(nil),
+		now:         time.Now,
+	}
+}
+
+// authenticate identifies the caller by API key. When API keys are configured and
+// the request has no valid key it writes a 401 and returns false.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if len(s.apiKeys) == 0 {
+		return "", true
+	}
+
+	identity, ok := s.apiKeys.identify(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Missing or invalid API key"})
+		return "", false
+	}
+	return identity, true
+}
+
+// routes registers every endpoint on a new ServeMux.
+func (s *Server) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/update", s.handleUpdate)
+	mux.HandleFunc("/redeploy", s.handleRedeploy)
+	mux.HandleFunc("/environments/{id}/services", s.handleListServices)
+	mux.HandleFunc("/environments/{id}/services/{service}/convert", s.handleConvertService)
+	mux.HandleFunc("/drift", s.handleDrift)
+	mux.HandleFunc("/watchers", s.handleWatchers)
+	mux.HandleFunc("/locks", s.handleLocks)
+	mux.HandleFunc("/approvals", s.handleListApprovals)
+	mux.HandleFunc("/approvals/{id}/approve", s.handleApprove)
+	mux.HandleFunc("/scheduled-updates", s.handleListScheduled)
+	mux.HandleFunc("/scheduled-updates/{id}", s.handleCancelScheduled)
+	mux.HandleFunc("/debug/vars", s.handleDebugVars)
+
+	if s.webhooks.GitHub != "" {
+		mux.HandleFunc("/webhooks/github", s.handleGitHubWebhook)
+	}
+	if s.webhooks.DockerHub != "" {
+		mux.HandleFunc("/webhooks/dockerhub", s.handleDockerHubWebhook)
+	}
+	if s.webhooks.Distribution != "" {
+		mux.HandleFunc("/webhooks/distribution", s.handleDistributionWebhook)
+	}
+
+	mux.HandleFunc("/health", handleHealth)
+
+	return mux
+}
+
+// handleDebugVars serves the expvar metrics, which name environments and repositories.
+func (s *Server) handleDebugVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	expvar.Handler().ServeHTTP(w, r)
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// redactedValue replaces secret values in debug logs and API responses.
+const redactedValue = "[REDACTED]"
+
+// VariableSet is a batch of environment variable changes for one scope.
+type VariableSet struct {
+	Upsert map[string]string `json:"upsert,omitempty"`
+	Delete []string          `json:"delete,omitempty"`
+}
+
+func (s VariableSet) empty() bool {
+	return len(s.Upsert) == 0 && len(s.Delete) == 0
+}
+
+// VariableChanges are applied alongside an image update, before the service is
+// deployed, so the new image starts with the new configuration. Shared changes apply
+// to the environment's shared variables; Services changes are keyed by service name.
+type VariableChanges struct {
+	Shared   VariableSet            `json:"shared,omitempty"`
+	Services map[string]VariableSet `json:"services,omitempty"`
+}
+
+// Validate checks that variable names are present and not both upserted and deleted.
+func (v *VariableChanges) Validate() error {
+	if err := v.Shared.validate("shared"); err != nil {
+		return err
+	}
+	for name, set := range v.Services {
+		if name == "" {
+			return fmt.Errorf("variables.services keys must be service names")
+		}
+		if err := set.validate("services." + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s VariableSet) validate(scope string) error {
+	for name := range s.Upsert {
+		if name == "" {
+			return fmt.Errorf("variables.%s.upsert contains an empty variable name", scope)
+		}
+	}
+	for _, name := range s.Delete {
+		if name == "" {
+			return fmt.Errorf("variables.%s.delete contains an empty variable name", scope)
+		}
+		if _, ok := s.Upsert[name]; ok {
+			return fmt.Errorf("variables.%s: %s is both upserted and deleted", scope, name)
+		}
+	}
+	return nil
+}
+
+// applyVariables writes set to the environment, scoped to serviceID when it is
+// non-empty. Upserts skip Railway's automatic redeploy so the caller controls the
+// single deployment that follows.
+func (c *RailwayClient) applyVariables(projectID, environmentID, serviceID string, set VariableSet) error {
+	if set.empty() {
+		return nil
+	}
+
+	scope := "shared variables"
+	if serviceID != "" {
+		scope = "service " + serviceID
+	}
+	log.Printf("Applying variables to %s in environment %s: upsert=%v delete=%v", scope, environmentID, sortedKeys(set.Upsert), set.Delete)
+
+	if len(set.Upsert) > 0 {
+		_, err := execute(c, environmentID, variableCollectionUpsertMutation, variableCollectionUpsertVariables{
+			Input: variableCollectionUpsertInput{
+				ProjectID:     projectID,
+				EnvironmentID: environmentID,
+				ServiceID:     serviceID,
+				Variables:     set.Upsert,
+				SkipDeploys:   true,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upsert variables: %w", err)
+		}
+	}
+
+	for _, name := range set.Delete {
+		_, err := execute(c, environmentID, variableDeleteMutation, variableDeleteVariables{
+			Input: variableDeleteInput{
+				ProjectID:     projectID,
+				EnvironmentID: environmentID,
+				ServiceID:     serviceID,
+				Name:          name,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete variable %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// redactVariables returns a copy of GraphQL variables that is safe to log: variable
+// values and registry passwords are replaced with a placeholder.
+func redactVariables(variables map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(variables))
+	for key, value := range variables {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if key != "variables" {
+				redacted[key] = redactVariables(v)
+				continue
+			}
+			masked := make(map[string]interface{}, len(v))
+			for name := range v {
+				masked[name] = redactedValue
+			}
+			redacted[key] = masked
+		default:
+			if key == "password" || key == "value" {
+				redacted[key] = redactedValue
+				continue
+			}
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
+
+// redacted returns a copy of the changes with upserted values replaced with a
+// placeholder, so they can be returned by the API.
+func (v *VariableChanges) redacted() *VariableChanges {
+	masked := &VariableChanges{Shared: v.Shared.redacted()}
+	if v.Services != nil {
+		masked.Services = make(map[string]VariableSet, len(v.Services))
+		for name, set := range v.Services {
+			masked.Services[name] = set.redacted()
+		}
+	}
+	return masked
+}
+
+func (s VariableSet) redacted() VariableSet {
+	masked := VariableSet{Delete: s.Delete}
+	if s.Upsert != nil {
+		masked.Upsert = make(map[string]string, len(s.Upsert))
+		for name := range s.Upsert {
+			masked.Upsert[name] = redactedValue
+		}
+	}
+	return masked
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRedactVariables(t *testing.T) {
+	variables := map[string]interface{}{
+		"environmentId": "env-1",
+		"input": map[string]interface{}{
+			"variables": map[string]interface{}{"FEATURE_FLAG": "secret-value"},
+			"registryCredentials": map[string]interface{}{
+				"username": "bot",
+				"password": "registry-secret",
+			},
+		},
+	}
+
+	data, err := json.Marshal(redactVariables(variables))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged := string(data)
+
+	for _, secret := range []string{"secret-value", "registry-secret"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("Expected %q to be redacted, got %s", secret, logged)
+		}
+	}
+	for _, kept := range []string{"env-1", "FEATURE_FLAG", "bot"} {
+		if !strings.Contains(logged, kept) {
+			t.Errorf("Expected %q to be kept, got %s", kept, logged)
+		}
+	}
+
+	// The original variables must still be sent unredacted
+	input := variables["input"].(map[string]interface{})
+	if input["variables"].(map[string]interface{})["FEATURE_FLAG"] != "secret-value" {
+		t.Error("redactVariables modified its input")
+	}
+}
+
+func TestVariableChanges_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes VariableChanges
+	}{
+		{"empty shared name", VariableChanges{Shared: VariableSet{Upsert: map[string]string{"": "x"}}}},
+		{"empty delete name", VariableChanges{Shared: VariableSet{Delete: []string{""}}}},
+		{"upsert and delete", VariableChanges{Services: map[string]VariableSet{
+			"api": {Upsert: map[string]string{"A": "1"}, Delete: []string{"A"}},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.changes.Validate(); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func variablesFake(t *testing.T) (*fakeRailway, *RailwayClient) {
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment", "EnvironmentProject":
+			return environmentData(
+				[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1"},
+				[3]string{"svc-2", "worker", "ghcr.io/returnearly/worker:v1"},
+			), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+}
+
+func TestUpdateServices_Variables(t *testing.T) {
+	fake, client := variablesFake(t)
+
+	opts := UpdateOptions{
+		Filter:     ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api"}},
+		NewVersion: "v2",
+		Variables: &VariableChanges{
+			Shared: VariableSet{Upsert: map[string]string{"RELEASE": "v2"}},
+			Services: map[string]VariableSet{
+				"api": {Upsert: map[string]string{"FEATURE_X": "on"}, Delete: []string{"OLD_FLAG"}},
+			},
+		},
+	}
+
+	if _, err := client.UpdateServices("env-1", opts); err != nil {
+		t.Fatalf("UpdateServices returned error: %v", err)
+	}
+
+	ops := strings.Join(fake.operations(), ",")
+	expected := "Environment,EnvironmentProject,VariableCollectionUpsert," +
+		"ServiceInstanceUpdate,VariableCollectionUpsert,VariableDelete,ServiceInstanceDeployV2"
+	if ops != expected {
+		t.Errorf("Expected operations %s, got %s", expected, ops)
+	}
+
+	for _, call := range fake.calls {
+		if call.Operation != "VariableCollectionUpsert" {
+			continue
+		}
+		input := call.Variables["input"].(map[string]interface{})
+		if input["projectId"] != "project-1" || input["skipDeploys"] != true {
+			t.Errorf("Unexpected upsert input %v", input)
+		}
+	}
+}
+
+func TestUpdateServices_VariablesForUnmatchedService(t *testing.T) {
+	fake, client := variablesFake(t)
+
+	opts := UpdateOptions{
+		Filter:     ServiceFilter{ImagePrefixes: []string{"ghcr.io/returnearly/api"}},
+		NewVersion: "v2",
+		Variables: &VariableChanges{
+			Services: map[string]VariableSet{"worker": {Upsert: map[string]string{"A": "1"}}},
+		},
+	}
+
+	if _, err := client.UpdateServices("env-1", opts); err == nil {
+		t.Fatal("Expected error for variables targeting an unmatched service")
+	}
+
+	if ops := fake.operations(); len(ops) != 1 {
+		t.Errorf("Expected nothing to change, got operations %v", ops)
+	}
+}
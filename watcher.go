@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultWatchInterval is how often a watcher lists its repository's tags.
+	defaultWatchInterval = 5 * time.Minute
+
+	// watcherTick is how often the poller looks for watchers that are due.
+	watcherTick = 15 * time.Second
+)
+
+// Watcher polls a repository on its registry and updates an environment when a new
+// tag selected by Policy appears. It serves registries that cannot send webhooks.
+// Target may name a configured target instead of setting ProjectID and EnvironmentID;
+// only services the target's filters select are then updated.
+type Watcher struct {
+	Name          string    `json:"name"`
+	Repository    string    `json:"repository"`
+	Policy        TagPolicy `json:"policy"`
+	Target        string    `json:"target,omitempty"`
+	ProjectID     string    `json:"project_id"`
+	EnvironmentID string    `json:"environment_id"`
+
+	// IntervalSeconds is how often the registry is polled (default 300).
+	IntervalSeconds int `json:"interval_seconds,omitempty"`
+
+	// Insecure polls the registry over plain HTTP.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Username and PasswordEnv authenticate to private registries. PasswordEnv
+	// names the environment variable holding the password or access token.
+	Username    string `json:"username,omitempty"`
+	PasswordEnv string `json:"password_env,omitempty"`
+
+	// scope is the filter of the watcher's target, set when the target is resolved.
+	scope *ServiceFilter
+}
+
+// TagPolicy decides which tag a watcher deploys. Exactly one field must be set.
+type TagPolicy struct {
+	// Semver deploys the highest release tag in a range such as "^1.4" or
+	// ">=1.2.0 <2.0.0". Tags may have a "v" prefix.
+	Semver string `json:"semver,omitempty"`
+
+	// Regex deploys the highest tag matching the expression, comparing digit runs
+	// numerically, e.g. "^build-\d+$" or "^main-\d{14}$".
+	Regex string `json:"regex,omitempty"`
+
+	// Digest redeploys when the tag it names, e.g. "latest", is pushed again.
+	Digest string `json:"digest,omitempty"`
+
+	constraint semverConstraint
+	re         *regexp.Regexp
+}
+
+// Validate checks the policy and compiles its range or expression.
+func (p *TagPolicy) Validate() error {
+	set := 0
+	for _, v := range []string{p.Semver, p.Regex, p.Digest} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of semver, regex or digest must be set")
+	}
+
+	var err error
+	switch {
+	case p.Semver != "":
+		p.constraint, err = parseSemverConstraint(p.Semver)
+	case p.Regex != "":
+		if p.re, err = regexp.Compile(p.Regex); err != nil {
+			err = fmt.Errorf("invalid regex %q: %w", p.Regex, err)
+		}
+	}
+	return err
+}
+
+// newest returns the highest tag selected by a semver or regex policy.
+func (p *TagPolicy) newest(tags []string) (string, bool) {
+	best := ""
+	for _, tag := range tags {
+		if !p.selects(tag) {
+			continue
+		}
+		if best == "" || p.compare(tag, best) > 0 {
+			best = tag
+		}
+	}
+	return best, best != ""
+}
+
+func (p *TagPolicy) selects(tag string) bool {
+	if p.Semver != "" {
+		v, ok := parseSemver(tag)
+		return ok && p.constraint.Matches(v)
+	}
+	return p.re.MatchString(tag)
+}
+
+// compare orders two tags selected by the policy.
+func (p *TagPolicy) compare(a, b string) int {
+	if p.Semver != "" {
+		va, _ := parseSemver(a)
+		vb, _ := parseSemver(b)
+		if c := va.compare(vb); c != 0 {
+			return c
+		}
+		// "v1.2.3" and "1.2.3" are the same version; order them stably
+		return compareNatural(a, b)
+	}
+	return compareNatural(a, b)
+}
+
+// ahead reports whether current is newer than candidate, so a service running current
+// would be downgraded, e.g. a service on v2.0.0 under a "^1" policy.
+func (p *TagPolicy) ahead(current, candidate string) bool {
+	if p.Semver != "" {
+		vc, ok := parseSemver(current)
+		vn, _ := parseSemver(candidate)
+		return ok && vc.compare(vn) > 0
+	}
+	return p.re.MatchString(current) && compareNatural(current, candidate) > 0
+}
+
+// compareNatural compares strings with runs of digits compared numerically, so
+// "build-10" sorts after "build-9".
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, restA := splitDigits(a)
+			nb, restB := splitDigits(b)
+			if c := compareDigits(na, nb); c != 0 {
+				return c
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			return compareInts(int(a[0]), int(b[0]))
+		}
+		a, b = a[1:], b[1:]
+	}
+	return compareInts(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareDigits compares two runs of decimal digits of any length by value.
+func compareDigits(a, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return compareInts(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// Validate checks the watcher's IDs, repository and policy.
+func (w *Watcher) Validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if _, err := uuid.Parse(w.ProjectID); err != nil {
+		return fmt.Errorf("watcher %s: invalid project_id: must be a valid UUID", w.Name)
+	}
+	if _, err := uuid.Parse(w.EnvironmentID); err != nil {
+		return fmt.Errorf("watcher %s: invalid environment_id: must be a valid UUID", w.Name)
+	}
+	ref := parseImageRef(w.Repository)
+	if w.Repository == "" || ref.Repository != w.Repository {
+		return fmt.Errorf("watcher %s: repository must be an image repository without tag or digest", w.Name)
+	}
+	if err := w.Policy.Validate(); err != nil {
+		return fmt.Errorf("watcher %s: invalid policy: %w", w.Name, err)
+	}
+	if w.IntervalSeconds < 0 {
+		return fmt.Errorf("watcher %s: interval_seconds cannot be negative", w.Name)
+	}
+	return nil
+}
+
+func (w *Watcher) interval() time.Duration {
+	if w.IntervalSeconds > 0 {
+		return time.Duration(w.IntervalSeconds) * time.Second
+	}
+	return defaultWatchInterval
+}
+
+// registryRepository returns where to poll the watcher's repository.
+func (w *Watcher) registryRepository() RegistryRepository {
+	repo := parseRegistryRepository(w.Repository)
+	repo.Insecure = w.Insecure
+	repo.Username = w.Username
+	if w.PasswordEnv != "" {
+		repo.Password = os.Getenv(w.PasswordEnv)
+	}
+	return repo
+}
+
+// repositoryNames returns the names services may reference the repository by.
+func (w *Watcher) repositoryNames() []string {
+	if repo := parseRegistryRepository(w.Repository); repo.Host == "registry-1.docker.io" {
+		return dockerHubRepositoryNames(repo.Name)
+	}
+	return []string{w.Repository}
+}
+
+// WatcherState is what a watcher last saw and deployed.
+type WatcherState struct {
+	Watcher    string `json:"watcher"`
+	Repository string `json:"repository"`
+
+	// Tag and Digest are the tag and digest last deployed, or for a digest policy
+	// the digest first seen.
+	Tag    string `json:"tag,omitempty"`
+	Digest string `json:"digest,omitempty"`
+
+	CheckedAt       time.Time `json:"checked_at"`
+	TriggeredAt     time.Time `json:"triggered_at"`
+	UpdatedServices []string  `json:"updated_services,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// RegistryPoller runs the configured watchers. It keeps one state per watcher so a
+// tag or digest triggers an update only once.
+type RegistryPoller struct {
+	client   *RailwayClient
+	registry *RegistryClient
+	config   *ConfigStore
+	now      func() time.Time
+
+	mu     sync.Mutex
+	states map[string]*WatcherState
+}
+
+func NewRegistryPoller(client *RailwayClient, config *ConfigStore) *RegistryPoller {
+	return &RegistryPoller{
+		client:   client,
+		registry: NewRegistryClient(),
+		config:   config,
+		now:      time.Now,
+		states:   make(map[string]*WatcherState),
+	}
+}
+
+// Check polls every watcher that has not been checked within its interval.
+func (p *RegistryPoller) Check() {
+	cfg := p.config.Get()
+	for i := range cfg.Watchers {
+		watcher := &cfg.Watchers[i]
+		state := p.state(watcher)
+		if !state.CheckedAt.IsZero() && p.now().Sub(state.CheckedAt) < watcher.interval() {
+			continue
+		}
+		p.poll(cfg, watcher, &state)
+		p.setState(state)
+	}
+}
+
+// state returns a copy of the watcher's state. The state is reset when the
+// watcher's repository changes.
+func (p *RegistryPoller) state(watcher *Watcher) WatcherState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if state, ok := p.states[watcher.Name]; ok && state.Repository == watcher.Repository {
+		return *state
+	}
+	return WatcherState{Watcher: watcher.Name, Repository: watcher.Repository}
+}
+
+func (p *RegistryPoller) setState(state WatcherState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.states[state.Watcher] = &state
+}
+
+// States returns the state of every watcher that has been checked, by name.
+func (p *RegistryPoller) States() []WatcherState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	states := make([]WatcherState, 0, len(p.states))
+	for _, state := range p.states {
+		states = append(states, *state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Watcher < states[j].Watcher
+	})
+	return states
+}
+
+// poll checks the watcher's registry and triggers an update when its policy selects
+// a new tag or digest.
+func (p *RegistryPoller) poll(cfg *Config, watcher *Watcher, state *WatcherState) {
+	state.CheckedAt = p.now()
+	repo := watcher.registryRepository()
+
+	// A digest watcher follows one tag, so services pinned to another tag or a
+	// digest of the repository are left alone
+	matchers := make([]ImageMatcher, 0)
+	for _, name := range watcher.repositoryNames() {
+		matchers = append(matchers, ImageMatcher{Repository: name, tag: watcher.Policy.Digest})
+	}
+	filter := ServiceFilter{Matchers: matchers, Logic: MatchLogicOr, Scope: watcher.scope}
+
+	var tag, digest string
+	if watcher.Policy.Digest != "" {
+		var err error
+		tag = watcher.Policy.Digest
+		if digest, err = p.registry.Digest(repo, tag); err != nil {
+			state.Error = err.Error()
+			log.Printf("Watcher %s failed: %v", watcher.Name, err)
+			return
+		}
+		if state.Digest == "" {
+			// Nothing is known to have changed until the digest has been seen once
+			state.Tag, state.Digest, state.Error = tag, digest, ""
+			return
+		}
+		if digest == state.Digest {
+			state.Error = ""
+			return
+		}
+	} else {
+		tags, err := p.registry.Tags(repo)
+		if err != nil {
+			state.Error = err.Error()
+			log.Printf("Watcher %s failed: %v", watcher.Name, err)
+			return
+		}
+		newest, ok := watcher.Policy.newest(tags)
+		if !ok {
+			state.Error = fmt.Sprintf("no tag of %s matches the policy", watcher.Repository)
+			return
+		}
+		if state.Tag != "" && watcher.Policy.compare(newest, state.Tag) <= 0 {
+			state.Error = ""
+			return
+		}
+
+		running, err := p.runningTags(watcher, filter)
+		if err != nil {
+			state.Error = err.Error()
+			log.Printf("Watcher %s failed: %v", watcher.Name, err)
+			return
+		}
+		if state.Tag == "" {
+			// The state is rebuilt from the services after a restart, so a tag they
+			// already run does not trigger an update again
+			current := make([]string, 0, len(running))
+			for _, tag := range running {
+				current = append(current, tag)
+			}
+			if highest, ok := watcher.Policy.newest(current); ok && watcher.Policy.compare(newest, highest) <= 0 {
+				state.Tag, state.Error = highest, ""
+				return
+			}
+		}
+
+		// Services already on a newer tag, e.g. pinned ahead by hand, are not downgraded
+		for name, current := range running {
+			if watcher.Policy.ahead(current, newest) {
+				filter.ExcludeNames = append(filter.ExcludeNames, name)
+			}
+		}
+		sort.Strings(filter.ExcludeNames)
+		tag = newest
+	}
+
+	// Frozen and protected environments are retried on the next poll, so the new tag
+	// is not recorded
+	err := cfg.checkFreezes([]string{watcher.EnvironmentID}, p.now())
+	if err == nil {
+		err = cfg.checkApprovals([]string{watcher.EnvironmentID})
+	}
+	if err != nil {
+		state.Error = err.Error()
+		log.Printf("Watcher %s skipped %s:%s: %v", watcher.Name, watcher.Repository, tag, err)
+		return
+	}
+
+	log.Printf("Watcher %s found %s:%s, updating environment %s", watcher.Name, watcher.Repository, tag, watcher.EnvironmentID)
+
+	result, err := p.client.UpdateServices(watcher.EnvironmentID, UpdateOptions{
+		Filter:     filter,
+		NewVersion: tag,
+		// A digest change keeps the tag, so services already on it must be redeployed
+		Force:     watcher.Policy.Digest != "",
+		Owner:     "watcher " + watcher.Name,
+		LockWait:  routeLockWait,
+		ProjectID: watcher.ProjectID,
+	})
+
+	// The tag is recorded even when the update fails, so a broken release is not
+	// retried on every poll; failures are reported by the notifiers
+	state.Tag, state.Digest = tag, digest
+	state.TriggeredAt = p.now()
+	state.UpdatedServices = result.UpdatedNames()
+	state.Error = ""
+	if err != nil {
+		state.Error = err.Error()
+		log.Printf("Watcher %s failed to update: %v", watcher.Name, err)
+	}
+}
+
+// runningTags returns the tag each service selected by filter runs, by service name.
+// Services pinned to a digest are left out.
+func (p *RegistryPoller) runningTags(watcher *Watcher, filter ServiceFilter) (map[string]string, error) {
+	services, err := p.client.forProject(watcher.ProjectID).GetServices(watcher.EnvironmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	running := make(map[string]string)
+	for _, service := range services {
+		if ref := parseImageRef(service.Image); filter.Matches(service) && ref.Tag != "" && ref.Digest == "" {
+			running[service.Name] = ref.Tag
+		}
+	}
+	return running, nil
+}
+
+// Watch runs Check in the background. The returned function stops watching.
+func (p *RegistryPoller) Watch() (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(watcherTick)
+		defer ticker.Stop()
+
+		for {
+			p.Check()
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// WatchersResponse is returned by GET /watchers.
+type WatchersResponse struct {
+	Watchers []WatcherState `json:"watchers"`
+}
+
+// handleWatchers serves GET /watchers, reporting what each registry watcher last
+// saw and deployed.
+func (s *Server) handleWatchers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use GET"})
+		return
+	}
+
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(WatchersResponse{Watchers: s.watchers.States()})
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRegistry is an OCI Distribution registry serving one repository behind a
+// bearer token challenge. Tags are split over two pages.
+type fakeRegistry struct {
+	mu     sync.Mutex
+	tags   []string
+	digest string
+}
+
+func (f *fakeRegistry) set(tags []string, digest string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tags, f.digest = tags, digest
+}
+
+// newFakeRegistry serves returnearly/api and returns its repository, e.g.
+// "127.0.0.1:1234/returnearly/api".
+func newFakeRegistry(t *testing.T) (*fakeRegistry, string) {
+	t.Helper()
+	fake := &fakeRegistry{}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if scope := r.URL.Query().Get("scope"); scope != "repository:returnearly/api:pull" {
+				http.Error(w, "unexpected scope "+scope, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "registry-token"})
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="repository:returnearly/api:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v2/returnearly/api/tags/list":
+			half := len(fake.tags) / 2
+			if r.URL.Query().Get("last") == "" {
+				w.Header().Set("Link", `</v2/returnearly/api/tags/list?last=page1&n=1000>; rel="next"`)
+				json.NewEncoder(w).Encode(map[string]interface{}{"name": "returnearly/api", "tags": fake.tags[:half]})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "returnearly/api", "tags": fake.tags[half:]})
+		case "/v2/returnearly/api/manifests/latest":
+			if !strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+				http.Error(w, "missing Accept", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", fake.digest)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return fake, strings.TrimPrefix(server.URL, "http://") + "/returnearly/api"
+}
+
+// watcherPoller returns a poller running watcher against a Railway environment whose
+// services api, api-2, ... run images, and records the images it deploys.
+func watcherPoller(t *testing.T, images []string, watcher Watcher, freezes ...FreezeWindow) (*RegistryPoller, func() []string, *time.Time) {
+	t.Helper()
+	services := make([][3]string, 0, len(images))
+	for i, image := range images {
+		name := "api"
+		if i > 0 {
+			name = fmt.Sprintf("api-%d", i+1)
+		}
+		services = append(services, [3]string{fmt.Sprintf("svc-%d", i+1), name, image})
+	}
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData(services...), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+
+	watcher.Name = "api"
+	watcher.ProjectID = "550e8400-e29b-41d4-a716-446655440000"
+	watcher.EnvironmentID = "550e8400-e29b-41d4-a716-446655440001"
+	watcher.Insecure = true
+	cfg := &Config{Watchers: []Watcher{watcher}, Freezes: freezes}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test config: %v", err)
+	}
+
+	poller := NewRegistryPoller(client, NewConfigStore(cfg))
+	now := time.Date(2024, 5, 3, 12, 0, 0, 0, time.UTC)
+	poller.now = func() time.Time { return now }
+
+	deployed := func() []string {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		var images []string
+		for _, call := range fake.calls {
+			if call.Operation == "ServiceInstanceUpdate" {
+				input := call.Variables["input"].(map[string]interface{})
+				images = append(images, input["source"].(map[string]interface{})["image"].(string))
+			}
+		}
+		return images
+	}
+	return poller, deployed, &now
+}
+
+func TestRegistryPoller_Semver(t *testing.T) {
+	registry, repository := newFakeRegistry(t)
+	registry.set([]string{"v1.0.0", "latest", "v1.2.0", "v2.0.0", "v1.3.0-rc.1", "sha-abc"}, "")
+
+	poller, deployed, now := watcherPoller(t, []string{repository + ":v1.0.0"}, Watcher{
+		Repository: repository,
+		Policy:     TagPolicy{Semver: "^1"},
+	})
+
+	poller.Check()
+	if images := deployed(); len(images) != 1 || images[0] != repository+":v1.2.0" {
+		t.Fatalf("Expected %s:v1.2.0 to be deployed, got %v", repository, images)
+	}
+
+	*now = now.Add(defaultWatchInterval)
+	poller.Check()
+	if images := deployed(); len(images) != 1 {
+		t.Errorf("Expected the same tag not to trigger again, got %v", images)
+	}
+
+	registry.set([]string{"v1.0.0", "v1.2.0", "v1.10.0", "v2.0.0"}, "")
+	*now = now.Add(time.Minute)
+	poller.Check()
+	if images := deployed(); len(images) != 1 {
+		t.Errorf("Expected no poll before the interval elapsed, got %v", images)
+	}
+
+	*now = now.Add(defaultWatchInterval)
+	poller.Check()
+	if images := deployed(); len(images) != 2 || images[1] != repository+":v1.10.0" {
+		t.Errorf("Expected %s:v1.10.0 to be deployed, got %v", repository, images)
+	}
+
+	states := poller.States()
+	if len(states) != 1 || states[0].Tag != "v1.10.0" || states[0].Error != "" {
+		t.Errorf("Unexpected watcher state %+v", states)
+	}
+}
+
+func TestRegistryPoller_NewerServices(t *testing.T) {
+	registry, repository := newFakeRegistry(t)
+	registry.set([]string{"v1.0.0", "v1.2.0", "v2.0.0"}, "")
+
+	// api-2 was moved to v2.0.0 by hand and must not be downgraded
+	poller, deployed, _ := watcherPoller(t, []string{repository + ":v1.0.0", repository + ":v2.0.0"}, Watcher{
+		Repository: repository,
+		Policy:     TagPolicy{Semver: "^1"},
+	})
+
+	poller.Check()
+	if images := deployed(); len(images) != 1 || images[0] != repository+":v1.2.0" {
+		t.Errorf("Expected only api to be updated to %s:v1.2.0, got %v", repository, images)
+	}
+	if state := poller.States()[0]; len(state.UpdatedServices) != 1 || state.UpdatedServices[0] != "api" {
+		t.Errorf("Expected only api to be updated, got %+v", state)
+	}
+}
+
+func TestRegistryPoller_RebuildsState(t *testing.T) {
+	registry, repository := newFakeRegistry(t)
+	registry.set([]string{"build-9", "build-10"}, "")
+
+	// After a restart the services already run the newest tag
+	poller, deployed, _ := watcherPoller(t, []string{repository + ":build-10", repository + ":build-9"}, Watcher{
+		Repository: repository,
+		Policy:     TagPolicy{Regex: `^build-\d+$`},
+	})
+
+	poller.Check()
+	if images := deployed(); len(images) != 0 {
+		t.Errorf("Expected no update, got %v", images)
+	}
+	if state := poller.States()[0]; state.Tag != "build-10" || !state.TriggeredAt.IsZero() {
+		t.Errorf("Expected the state to be rebuilt from build-10, got %+v", state)
+	}
+}
+
+func TestRegistryPoller_Digest(t *testing.T) {
+	registry, repository := newFakeRegistry(t)
+	registry.set(nil, "sha256:aaa")
+
+	// Only api follows latest; its siblings are pinned to a tag or a digest
+	poller, deployed, now := watcherPoller(t, []string{
+		repository + ":latest",
+		repository + ":v1.0.0",
+		repository + "@sha256:abc",
+		repository + ":latest@sha256:abc",
+	}, Watcher{
+		Repository: repository,
+		Policy:     TagPolicy{Digest: "latest"},
+	})
+
+	poller.Check()
+	if images := deployed(); len(images) != 0 {
+		t.Fatalf("Expected the first digest only to be recorded, got %v", images)
+	}
+
+	registry.set(nil, "sha256:bbb")
+	*now = now.Add(defaultWatchInterval)
+	poller.Check()
+	if images := deployed(); len(images) != 1 || images[0] != repository+":latest" {
+		t.Errorf("Expected %s:latest to be redeployed, got %v", repository, images)
+	}
+	if state := poller.States()[0]; state.Digest != "sha256:bbb" {
+		t.Errorf("Expected digest sha256:bbb to be recorded, got %s", state.Digest)
+	}
+}
+
+func TestRegistryPoller_Frozen(t *testing.T) {
+	registry, repository := newFakeRegistry(t)
+	registry.set([]string{"build-9", "build-10", "main"}, "")
+
+	poller, deployed, _ := watcherPoller(t, []string{repository + ":build-8"}, Watcher{
+		Repository: repository,
+		Policy:     TagPolicy{Regex: `^build-\d+$`},
+	}, FreezeWindow{
+		Name:           "always",
+		EnvironmentIDs: []string{"550e8400-e29b-41d4-a716-446655440001"},
+		Cron:           "* * * * *",
+	})
+
+	poller.Check()
+	if images := deployed(); len(images) != 0 {
+		t.Errorf("Expected no update while frozen, got %v", images)
+	}
+	state := poller.States()[0]
+	if state.Tag != "" || !strings.Contains(state.Error, "frozen") {
+		t.Errorf("Expected build-10 to be retried after the freeze, got %+v", state)
+	}
+}
+
+func TestHandleWatchers_Unauthorized(t *testing.T) {
+	_, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		return map[string]interface{}{}, nil
+	})
+	server := newTestServer(client, nil)
+	server.apiKeys = APIKeys{"alice": "alice-key"}
+
+	w := sendAuthorized(server.routes(), http.MethodGet, "/watchers", "wrong-key", nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w = sendAuthorized(server.routes(), http.MethodGet, "/watchers", "alice-key", nil)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+}
+
+func TestTagPolicy_Newest(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   TagPolicy
+		tags     []string
+		expected string
+	}{
+		{"semver range", TagPolicy{Semver: ">=1.2.0 <2.0.0"}, []string{"1.1.0", "v1.9.0", "1.10.0", "2.0.0"}, "1.10.0"},
+		{"regex natural order", TagPolicy{Regex: `^build-\d+$`}, []string{"build-9", "build-10", "build-08", "main"}, "build-10"},
+		{"regex timestamps", TagPolicy{Regex: `^main-\d{14}$`}, []string{"main-20240503120000", "main-20240430090000"}, "main-20240503120000"},
+		{"no match", TagPolicy{Semver: "^3"}, []string{"1.0.0", "latest"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); err != nil {
+				t.Fatalf("Invalid policy: %v", err)
+			}
+			if got, _ := tt.policy.newest(tt.tags); got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParseRegistryRepository(t *testing.T) {
+	tests := []struct {
+		repository string
+		host, name string
+	}{
+		{"ghcr.io/returnearly/api", "ghcr.io", "returnearly/api"},
+		{"registry:5000/team/app", "registry:5000", "team/app"},
+		{"localhost/app", "localhost", "app"},
+		{"returnearly/web", "registry-1.docker.io", "returnearly/web"},
+		{"nginx", "registry-1.docker.io", "library/nginx"},
+		{"docker.io/library/nginx", "registry-1.docker.io", "library/nginx"},
+	}
+
+	for _, tt := range tests {
+		repo := parseRegistryRepository(tt.repository)
+		if repo.Host != tt.host || repo.Name != tt.name {
+			t.Errorf("Expected %s to be %s on %s, got %s on %s", tt.repository, tt.name, tt.host, repo.Name, repo.Host)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// distributionEnvelope is a CNCF Distribution (Docker Registry v2) notification
+// envelope, as sent by Distribution and registries built on it.
+type distributionEnvelope struct {
+	Events []distributionEvent `json:"events"`
+}
+
+type distributionEvent struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Target struct {
+		MediaType  string `json:"mediaType"`
+		Digest     string `json:"digest"`
+		Repository string `json:"repository"`
+		URL        string `json:"url"`
+		Tag        string `json:"tag"`
+	} `json:"target"`
+	Request struct {
+		Host string `json:"host"`
+	} `json:"request"`
+}
+
+// handleDistributionWebhook receives Distribution notification envelopes and updates
+// the environments routed to every tagged manifest push. The registry endpoint must
+// be configured to send "Authorization: Bearer <token>".
+func (s *Server) handleDistributionWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use POST"})
+		return
+	}
+
+	if !validWebhookToken("Bearer "+s.webhooks.Distribution, r.Header.Get("Authorization")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid webhook token"})
+		return
+	}
+
+	var envelope distributionEnvelope
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookBodySize)).Decode(&envelope); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Invalid JSON: %v", err)})
+		return
+	}
+
+	// Registries retry and batch notifications, so the same push can appear twice
+	seen := make(map[string]bool)
+	results := make([]RouteResult, 0)
+	var repository, tag string
+
+	for _, event := range envelope.Events {
+		if event.Action != "push" || event.Target.Tag == "" || event.Target.Repository == "" {
+			continue
+		}
+
+		eventRepository := event.Target.Repository
+		if event.Request.Host != "" {
+			eventRepository = event.Request.Host + "/" + eventRepository
+		}
+
+		image := eventRepository + ":" + event.Target.Tag
+		if seen[image] {
+			continue
+		}
+		seen[image] = true
+		repository, tag = eventRepository, event.Target.Tag
+
+		results = append(results, triggerRoutes(s.client, s.config.Get(), []string{eventRepository}, event.Target.Tag)...)
+	}
+
+	if len(seen) == 0 {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(WebhookResponse{Message: "Ignored envelope: no tagged push events"})
+		return
+	}
+
+	if len(seen) > 1 {
+		repository, tag = "", ""
+	}
+	writeRouteResults(w, results, repository, tag)
+}
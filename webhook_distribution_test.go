@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func distributionRoutes(t *testing.T) []Route {
+	t.Helper()
+	routes := []Route{{
+		Name:          "worker-staging",
+		Repository:    ImageMatcher{Glob: "registry.example.com/returnearly/*"},
+		ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+		EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+	}}
+	if err := routes[0].Validate(); err != nil {
+		t.Fatalf("Invalid test route: %v", err)
+	}
+	return routes
+}
+
+func TestHandleDistributionWebhook_Push(t *testing.T) {
+	fake, client := newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData(
+				[3]string{"svc-1", "worker", "registry.example.com/returnearly/worker:v1.9.0"},
+				[3]string{"svc-2", "other", "registry.example.com/returnearly/other:v9.0.0"},
+			), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+	body := readFixture(t, "distribution_notification.json")
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/distribution", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/vnd.docker.distribution.events.v1+json")
+	req.Header.Set("Authorization", "Bearer "+testWebhookSecret)
+	w := httptest.NewRecorder()
+
+	newTestServer(client, &Config{Routes: distributionRoutes(t)}).handleDistributionWebhook(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp WebhookResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// Only the tagged manifest push counts: the layer push and the pull are ignored
+	if resp.Repository != "registry.example.com/returnearly/worker" || resp.Tag != "v2.0.0" {
+		t.Errorf("Unexpected repository/tag %s:%s", resp.Repository, resp.Tag)
+	}
+	if len(resp.Routes) != 1 || len(resp.Routes[0].UpdatedServices) != 1 || resp.Routes[0].UpdatedServices[0] != "worker" {
+		t.Fatalf("Expected only worker to be updated, got %+v", resp.Routes)
+	}
+
+	updates := 0
+	for _, call := range fake.calls {
+		if call.Operation == "ServiceInstanceUpdate" {
+			updates++
+			image := call.Variables["input"].(map[string]interface{})["source"].(map[string]interface{})["image"]
+			if image != "registry.example.com/returnearly/worker:v2.0.0" {
+				t.Errorf("Unexpected image %v", image)
+			}
+		}
+	}
+	if updates != 1 {
+		t.Errorf("Expected 1 service update, got %d", updates)
+	}
+}
+
+func TestHandleDistributionWebhook_InvalidToken(t *testing.T) {
+	fake, client := registryFake(t)
+	body := readFixture(t, "distribution_notification.json")
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/distribution", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer wrong")
+	w := httptest.NewRecorder()
+
+	newTestServer(client, &Config{Routes: distributionRoutes(t)}).handleDistributionWebhook(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestHandleDistributionWebhook_NoPushEvents(t *testing.T) {
+	fake, client := registryFake(t)
+	body := []byte(`{"events":[{"action":"pull","target":{"repository":"returnearly/worker","tag":"v1"}}]}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/distribution", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testWebhookSecret)
+	w := httptest.NewRecorder()
+
+	newTestServer(client, &Config{Routes: distributionRoutes(t)}).handleDistributionWebhook(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
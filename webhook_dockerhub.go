@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// dockerHubPushEvent is the subset of Docker Hub's repository webhook payload needed
+// to identify a pushed tag.
+type dockerHubPushEvent struct {
+	CallbackURL string `json:"callback_url"`
+	PushData    struct {
+		Tag    string `json:"tag"`
+		Pusher string `json:"pusher"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName  string `json:"repo_name"`
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	} `json:"repository"`
+}
+
+// handleDockerHubWebhook receives Docker Hub push webhooks and updates the
+// environments routed to the pushed repository. Docker Hub does not sign its
+// webhooks, so the URL must carry the shared token as ?token=.
+func (s *Server) handleDockerHubWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use POST"})
+		return
+	}
+
+	if !validWebhookToken(s.webhooks.DockerHub, r.URL.Query().Get("token")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid webhook token"})
+		return
+	}
+
+	var payload dockerHubPushEvent
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxWebhookBodySize)).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Invalid JSON: %v", err)})
+		return
+	}
+
+	repository := payload.Repository.RepoName
+	tag := payload.PushData.Tag
+	if repository == "" || tag == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Payload must include repository.repo_name and push_data.tag"})
+		return
+	}
+
+	writeRouteResults(w, triggerRoutes(s.client, s.config.Get(), dockerHubRepositoryNames(repository), tag), repository, tag)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleDockerHubWebhook_Push(t *testing.T) {
+	fake, client := registryFake(t)
+	body := readFixture(t, "dockerhub_push.json")
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/dockerhub?token="+testWebhookSecret, bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, &Config{Routes: testRoutes(t)}).handleDockerHubWebhook(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp WebhookResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if resp.Repository != "returnearly/web" || resp.Tag != "v5.1.0" {
+		t.Errorf("Unexpected repository/tag %s:%s", resp.Repository, resp.Tag)
+	}
+	if len(resp.Routes) != 1 || resp.Routes[0].Route != "web-staging" {
+		t.Fatalf("Expected web-staging route to trigger, got %+v", resp.Routes)
+	}
+
+	for _, call := range fake.calls {
+		if call.Operation == "ServiceInstanceUpdate" {
+			image := call.Variables["input"].(map[string]interface{})["source"].(map[string]interface{})["image"]
+			if image != "returnearly/web:v5.1.0" {
+				t.Errorf("Unexpected image %v", image)
+			}
+		}
+	}
+}
+
+func TestTriggerRoutes_RepushedTag(t *testing.T) {
+	tests := []struct {
+		name        string
+		mutableTags []string
+		updated     int
+		expected    []string
+	}{
+		// A duplicate webhook for the tag web already runs changes nothing
+		{"immutable tag", nil, 0, []string{"Environment"}},
+		{"mutable tag", []string{"v5"}, 1, []string{"Environment", "ServiceInstanceUpdate", "ServiceInstanceDeployV2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, client := registryFake(t)
+			routes := testRoutes(t)
+			routes[1].MutableTags = tt.mutableTags
+
+			// web already runs returnearly/web:v5
+			results := triggerRoutes(client, &Config{Routes: routes}, dockerHubRepositoryNames("returnearly/web"), "v5")
+
+			if len(results) != 1 || results[0].Error != "" {
+				t.Fatalf("Expected web-staging to succeed, got %+v", results)
+			}
+			if len(results[0].UpdatedServices) != tt.updated || len(results[0].UnchangedServices) != 1-tt.updated {
+				t.Errorf("Expected %d service(s) to be redeployed, got %+v", tt.updated, results[0])
+			}
+			if ops := fake.operations(); strings.Join(ops, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected operations %v, got %v", tt.expected, ops)
+			}
+		})
+	}
+}
+
+func TestHandleDockerHubWebhook_InvalidToken(t *testing.T) {
+	fake, client := registryFake(t)
+	body := readFixture(t, "dockerhub_push.json")
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/dockerhub?token=wrong", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, &Config{Routes: testRoutes(t)}).handleDockerHubWebhook(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestDockerHubRepositoryNames(t *testing.T) {
+	names := dockerHubRepositoryNames("library/nginx")
+
+	for _, expected := range []string{"library/nginx", "docker.io/library/nginx", "nginx"} {
+		found := false
+		for _, name := range names {
+			if name == expected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected %q in %v", expected, names)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxWebhookBodySize bounds the payload read from registry webhooks.
+const maxWebhookBodySize = 5 << 20
+
+// githubRegistryPackageEvent is the subset of GitHub's registry_package webhook
+// payload needed to identify a published container image.
+type githubRegistryPackageEvent struct {
+	Action          string `json:"action"`
+	RegistryPackage struct {
+		Name           string `json:"name"`
+		Namespace      string `json:"namespace"`
+		PackageType    string `json:"package_type"`
+		PackageVersion struct {
+			Version           string `json:"version"`
+			ContainerMetadata struct {
+				Tag struct {
+					Name   string `json:"name"`
+					Digest string `json:"digest"`
+				} `json:"tag"`
+			} `json:"container_metadata"`
+		} `json:"package_version"`
+	} `json:"registry_package"`
+}
+
+// handleGitHubWebhook receives GitHub registry_package webhooks, verifies their
+// X-Hub-Signature-256 signature and updates the environments routed to the
+// published ghcr.io image.
+func (s *Server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Method not allowed, use POST"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Failed to read body: %v", err)})
+		return
+	}
+
+	if !validGitHubSignature(s.webhooks.GitHub, body, r.Header.Get("X-Hub-Signature-256")) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid webhook signature"})
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event != "registry_package" {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(WebhookResponse{Message: fmt.Sprintf("Ignored %s event", event)})
+		return
+	}
+
+	var payload githubRegistryPackageEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Invalid JSON: %v", err)})
+		return
+	}
+
+	pkg := payload.RegistryPackage
+	tag := pkg.PackageVersion.ContainerMetadata.Tag.Name
+	if payload.Action != "published" || !strings.EqualFold(pkg.PackageType, "container") || tag == "" {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(WebhookResponse{Message: "Ignored event: not a tagged container publish"})
+		return
+	}
+
+	repository := strings.ToLower("ghcr.io/" + pkg.Namespace + "/" + pkg.Name)
+	writeRouteResults(w, triggerRoutes(s.client, s.config.Get(), []string{repository}, tag), repository, tag)
+}
+
+// validGitHubSignature checks a "sha256=<hex>" HMAC of body against secret.
+func validGitHubSignature(secret string, body []byte, signature string) bool {
+	if secret == "" {
+		return false
+	}
+
+	digest, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	expected, err := hex.DecodeString(digest)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// validWebhookToken compares a shared webhook token in constant time.
+func validWebhookToken(expected, provided string) bool {
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(provided)) == 1
+}
+
+// writeRouteResults responds to a registry webhook with the routes it triggered.
+// repository and tag may be empty when the webhook reported several images.
+func writeRouteResults(w http.ResponseWriter, results []RouteResult, repository, tag string) {
+	if len(results) == 0 {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(WebhookResponse{
+			Message:    "No routes matched the pushed image",
+			Repository: repository,
+			Tag:        tag,
+		})
+		return
+	}
+
+	failed, skipped := 0, 0
+	for _, result := range results {
+		switch {
+		case result.Error != "":
+			failed++
+		case result.Skipped != "":
+			skipped++
+		}
+	}
+
+	// Skipped routes are not errors: a registry retrying the push would be skipped again
+	status := http.StatusOK
+	message := fmt.Sprintf("Triggered %d route(s)", len(results)-skipped)
+	switch {
+	case failed > 0:
+		status = http.StatusInternalServerError
+		message = fmt.Sprintf("%d of %d route(s) failed", failed, len(results))
+	case skipped == len(results):
+		status = http.StatusAccepted
+		message = fmt.Sprintf("Skipped %d route(s)", skipped)
+	case skipped > 0:
+		message += fmt.Sprintf(", skipped %d", skipped)
+	}
+
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(WebhookResponse{
+		Message:    message,
+		Repository: repository,
+		Tag:        tag,
+		Routes:     results,
+	})
+}
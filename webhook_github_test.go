@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+func githubSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	return data
+}
+
+// testRoutes returns validated routes for ghcr.io/returnearly/api and docker.io/returnearly/web.
+func testRoutes(t *testing.T) []Route {
+	t.Helper()
+	routes := []Route{
+		{
+			Name:          "api-staging",
+			Repository:    ImageMatcher{Repository: "ghcr.io/returnearly/api"},
+			TagPattern:    `^v\d+\.\d+\.\d+$`,
+			ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+			EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+		},
+		{
+			Name:          "web-staging",
+			Repository:    ImageMatcher{Glob: "returnearly/web"},
+			ProjectID:     "550e8400-e29b-41d4-a716-446655440000",
+			EnvironmentID: "550e8400-e29b-41d4-a716-446655440001",
+		},
+	}
+	for i := range routes {
+		if err := routes[i].Validate(); err != nil {
+			t.Fatalf("Invalid test route: %v", err)
+		}
+	}
+	return routes
+}
+
+// registryFake serves an environment with an api service on ghcr.io and a web
+// service on Docker Hub.
+func registryFake(t *testing.T) (*fakeRailway, *RailwayClient) {
+	return newFakeRailway(t, func(operation string, variables map[string]interface{}) (interface{}, error) {
+		switch operation {
+		case "Environment":
+			return environmentData(
+				[3]string{"svc-1", "api", "ghcr.io/returnearly/api:v1.2.2"},
+				[3]string{"svc-2", "api-legacy", "ghcr.io/returnearly/api-legacy:v1"},
+				[3]string{"svc-3", "web", "returnearly/web:v5"},
+			), nil
+		case "ServiceInstanceDeployV2":
+			return map[string]interface{}{"serviceInstanceDeployV2": "deploy-1"}, nil
+		}
+		return map[string]interface{}{}, nil
+	})
+}
+
+func TestHandleGitHubWebhook_Published(t *testing.T) {
+	fake, client := registryFake(t)
+	body := readFixture(t, "github_registry_package_published.json")
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "registry_package")
+	req.Header.Set("X-Hub-Signature-256", githubSignature(testWebhookSecret, body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, &Config{Routes: testRoutes(t)}).handleGitHubWebhook(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp WebhookResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if resp.Repository != "ghcr.io/returnearly/api" || resp.Tag != "v1.2.3" {
+		t.Errorf("Unexpected repository/tag %s:%s", resp.Repository, resp.Tag)
+	}
+	if len(resp.Routes) != 1 || resp.Routes[0].Route != "api-staging" {
+		t.Fatalf("Expected api-staging route to trigger, got %+v", resp.Routes)
+	}
+	if len(resp.Routes[0].UpdatedServices) != 1 || resp.Routes[0].UpdatedServices[0] != "api" {
+		t.Errorf("Expected only api to be updated, got %v", resp.Routes[0].UpdatedServices)
+	}
+
+	for _, call := range fake.calls {
+		if call.Operation == "ServiceInstanceUpdate" {
+			image := call.Variables["input"].(map[string]interface{})["source"].(map[string]interface{})["image"]
+			if image != "ghcr.io/returnearly/api:v1.2.3" {
+				t.Errorf("Unexpected image %v", image)
+			}
+		}
+	}
+}
+
+func TestHandleGitHubWebhook_InvalidSignature(t *testing.T) {
+	fake, client := registryFake(t)
+	body := readFixture(t, "github_registry_package_published.json")
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "registry_package")
+	req.Header.Set("X-Hub-Signature-256", githubSignature("wrong-secret", body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, &Config{Routes: testRoutes(t)}).handleGitHubWebhook(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestHandleGitHubWebhook_IgnoresOtherEvents(t *testing.T) {
+	fake, client := registryFake(t)
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/github", bytes.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "ping")
+	req.Header.Set("X-Hub-Signature-256", githubSignature(testWebhookSecret, body))
+	w := httptest.NewRecorder()
+
+	newTestServer(client, &Config{Routes: testRoutes(t)}).handleGitHubWebhook(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if ops := fake.operations(); len(ops) != 0 {
+		t.Errorf("Expected no Railway calls, got %v", ops)
+	}
+}
+
+func TestRoute_Matches(t *testing.T) {
+	routes := testRoutes(t)
+
+	tests := []struct {
+		repository string
+		tag        string
+		expected   bool
+	}{
+		{"ghcr.io/returnearly/api", "v1.2.3", true},
+		{"ghcr.io/returnearly/api", "sha-abc123", false},
+		{"ghcr.io/returnearly/api-legacy", "v1.2.3", false},
+	}
+
+	for _, tt := range tests {
+		if result := routes[0].Matches([]string{tt.repository}, tt.tag); result != tt.expected {
+			t.Errorf("Matches(%q, %q) = %v, expected %v", tt.repository, tt.tag, result, tt.expected)
+		}
+	}
+}